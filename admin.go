@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+	"github.com/hiromuota166/iropico_color_calc/pkg/scoring"
+)
+
+// Operator endpoints used during live events, mostly through `iropico admin`.
+
+const (
+	flagQueueSize = 1000
+	// Submissions scoring at least flagMinScore whose pixels barely vary are
+	// queued for review: a screen filled with the theme color scores
+	// perfectly without a photo of anything.
+	flagMinScore  = 95.0
+	flagMaxStdDev = 0.01 // linear RGB, per channel
+	maxTailWait   = 30 * time.Second
+)
+
+// activeTheme is the theme /score falls back to when a request names none.
+var activeTheme themeState
+
+type themeState struct {
+	mu    sync.Mutex
+	hex   string
+	since time.Time
+}
+
+type ActiveTheme struct {
+	ThemeHex string    `json:"theme_hex" doc:"Empty when no theme is active."`
+	Since    time.Time `json:"since"`
+}
+
+func (t *themeState) get() ActiveTheme {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hex == "" {
+		return ActiveTheme{}
+	}
+	return ActiveTheme{ThemeHex: "#" + t.hex, Since: t.since}
+}
+
+// rotate makes theme active and returns the previously active one.
+func (t *themeState) rotate(theme string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev := t.hex
+	t.hex, t.since = theme, time.Now().UTC()
+	return prev
+}
+
+type RotateThemeRequest struct {
+	ThemeHex  string `json:"theme_hex" doc:"New active theme as hex, rgb(), hsl() or a CSS color name."`
+	KeepRound bool   `json:"keep_round,omitempty" doc:"Leave the previous theme's round open instead of closing it."`
+}
+
+type RotateThemeResp struct {
+	Active ActiveTheme    `json:"active"`
+	Closed *Retrospective `json:"closed,omitempty" doc:"Retrospective of the previous theme's round, if it was closed."`
+}
+
+func handleGetTheme(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(activeTheme.get())
+}
+
+func handleRotateTheme(w http.ResponseWriter, r *http.Request) {
+	var req RotateThemeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, r, bodyError(err))
+		return
+	}
+	tr, tg, tb, _, err := colormath.ParseColor(req.ThemeHex)
+	if err != nil {
+		writeRequestError(w, r, themeHexError(err))
+		return
+	}
+	theme := themeKey(tr, tg, tb)
+	var resp RotateThemeResp
+	if prev := activeTheme.rotate(theme); prev != "" && prev != theme && !req.KeepRound {
+		if resp.Closed, err = retros.close(prev); err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "retrospective: "+err.Error())
+			return
+		}
+		if resp.Closed != nil {
+			signGallery(archive, resp.Closed)
+		}
+	}
+	resp.Active = activeTheme.get()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type RescoreRequest struct {
+	ThemeHex      string `json:"theme_hex" doc:"Theme color as hex, rgb(), hsl() or a CSS color name."`
+	Metric        string `json:"metric,omitempty" enum:"@metrics"`
+	Aggregation   string `json:"aggregation,omitempty" enum:"@aggregations"`
+	Normalization string `json:"normalization,omitempty" enum:"gamut,global"`
+	Background    string `json:"background,omitempty" enum:"@backgrounds"`
+	Grayscale     string `json:"grayscale,omitempty" enum:"@grayscaleModes"`
+	Vision        string `json:"vision,omitempty" enum:"@visionModes"`
+	Lang          string `json:"lang,omitempty" enum:"@languages"`
+	Seed          uint32 `json:"seed,omitempty" doc:"As in ScoreRequest; use the disputed submission's to reproduce its score."`
+}
+
+// handleRescore scores an archived image again without recording it as a
+// new submission.
+func handleRescore(w http.ResponseWriter, r *http.Request) {
+	id, ok := archivedImage(w, r)
+	if !ok {
+		return
+	}
+	var req RescoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, r, bodyError(err))
+		return
+	}
+	img, err := archive.get(id)
+	if errors.Is(err, errBlobNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not archived")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "archive: "+err.Error())
+		return
+	}
+	resp, _, err := scoreSubmission(r.Context(), scoreParams{
+		Image:         img,
+		ThemeHex:      req.ThemeHex,
+		Metric:        req.Metric,
+		Aggregation:   req.Aggregation,
+		Normalization: req.Normalization,
+		Background:    req.Background,
+		Grayscale:     req.Grayscale,
+		Vision:        req.Vision,
+		Lang:          req.Lang,
+		Seed:          req.Seed,
+		Rescore:       true,
+	})
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type FlushCacheResp struct {
+	Entries int `json:"entries" doc:"Cached entries dropped."`
+}
+
+func flushCaches() int {
+	var n int
+	for _, m := range scoring.Metrics {
+		n += m.FlushCache()
+	}
+	return n + scoreResults.flush()
+}
+
+func handleFlushCache(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FlushCacheResp{Entries: flushCaches()})
+}
+
+// ConfigReloadResp reports a reload and the reloadable sections now in
+// effect.
+type ConfigReloadResp struct {
+	Changed    []string         `json:"changed" doc:"Dotted names of the fields that changed, e.g. scoring.curve_exponent; empty when none did."`
+	Scoring    ScoringConfig    `json:"scoring"`
+	Limits     LimitsConfig     `json:"limits" doc:"Only the max_image_* limits reload; the rest need a restart."`
+	Cache      CacheConfig      `json:"cache"`
+	RateLimit  RateLimitConfig  `json:"rate_limit"`
+	Quota      QuotaConfig      `json:"quota" doc:"redis_url, with its password masked, needs a restart."`
+	Experiment ExperimentConfig `json:"experiment"`
+}
+
+// handleReloadConfig reloads the configuration. In-flight requests finish
+// under the config they started with, as each reads it once.
+func handleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	changed, err := reloadConfig()
+	var fixed restartRequiredError
+	switch {
+	case errors.As(err, &fixed):
+		writeRequestError(w, r, &requestError{Status: http.StatusConflict, Code: codeRestartRequired, Msg: err.Error(),
+			Details: map[string]any{"fields": []string(fixed)}})
+		return
+	case err != nil:
+		writeRequestError(w, r, &requestError{Status: http.StatusUnprocessableEntity, Code: codeInvalidConfig, Msg: err.Error()})
+		return
+	}
+	log.Printf("config reloaded via admin api; changed: %v", changed)
+	if changed == nil {
+		changed = []string{}
+	}
+	c := config()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConfigReloadResp{Changed: changed, Scoring: c.Scoring, Limits: c.Limits, Cache: c.Cache, RateLimit: c.RateLimit, Quota: c.Quota.redacted(), Experiment: c.Experiment})
+}
+
+type FlaggedSubmission struct {
+	Seq      int64     `json:"seq"`
+	At       time.Time `json:"at"`
+	ThemeHex string    `json:"theme_hex"`
+	UserID   string    `json:"user_id,omitempty"`
+	Score    float64   `json:"score"`
+	ImageID  string    `json:"image_id" doc:"SHA-256 of the image; fetch it from /admin/archive/{id} when archiving is on."`
+	Reason   string    `json:"reason"`
+}
+
+type FlaggedResp struct {
+	Items []FlaggedSubmission `json:"items"`
+	Next  int64               `json:"next" doc:"Pass as after= to continue tailing."`
+}
+
+var flagged = &flagQueue{wake: make(chan struct{})}
+
+// flagQueue keeps the most recent flagged submissions in memory. Readers
+// long-poll with the last seq they saw.
+type flagQueue struct {
+	mu    sync.Mutex
+	items []FlaggedSubmission
+	seq   int64
+	wake  chan struct{} // closed and replaced on every push
+}
+
+func (q *flagQueue) push(f FlaggedSubmission) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	f.Seq = q.seq
+	q.items = append(q.items, f)
+	if len(q.items) > flagQueueSize {
+		q.items = q.items[len(q.items)-flagQueueSize:]
+	}
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// since returns the items after seq, waiting up to ctx's deadline for at
+// least one.
+func (q *flagQueue) since(ctx context.Context, after int64) FlaggedResp {
+	for {
+		q.mu.Lock()
+		var out []FlaggedSubmission
+		for _, f := range q.items {
+			if f.Seq > after {
+				out = append(out, f)
+			}
+		}
+		next, wake := max(after, q.seq), q.wake
+		q.mu.Unlock()
+		if len(out) > 0 {
+			return FlaggedResp{Items: out, Next: out[len(out)-1].Seq}
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return FlaggedResp{Items: []FlaggedSubmission{}, Next: next}
+		}
+	}
+}
+
+// flagReason returns why a scored submission needs review, or "".
+func flagReason(score float64, res scoring.Result) string {
+	if score >= flagMinScore && res.StdDev <= flagMaxStdDev {
+		return "near-uniform image with high score"
+	}
+	return ""
+}
+
+func handleFlagged(w http.ResponseWriter, r *http.Request) {
+	var after int64
+	if v := r.URL.Query().Get("after"); v != "" {
+		var err error
+		if after, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "after", Msg: "bad after: " + err.Error()})
+			return
+		}
+	}
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		var err error
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+			writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "wait", Msg: "bad wait: want a duration such as 25s"})
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), min(wait, maxTailWait))
+	defer cancel()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flagged.since(ctx, after))
+}
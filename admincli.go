@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+const adminUsage = `usage: iropico admin [-server URL] [-token KEY] <command> [args]
+
+commands:
+  close-round <hex>             close the open round of a theme
+  rescore [flags] <id> <hex>    score an archived image against a theme
+  rescore-rooms [flags] [room-id...]
+                                rescore rooms' submissions and leaderboards
+  rotate-theme [-keep-round] <hex>
+                                make hex the active theme
+  flush-cache                   drop cached scoring data
+  reload-config                 reload tunables from the server's config
+  tail-flagged [-after N]       stream submissions flagged for review
+
+The server defaults to $IROPICO_SERVER (or http://localhost:8080) and the
+token to $IROPICO_ADMIN_TOKEN; it must be one of the server's admin keys.
+`
+
+type adminClient struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// do sends an admin request and decodes a JSON response into out.
+func (c *adminClient) do(ctx context.Context, method, path string, body, out any) error {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.server, "/")+path, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var e ErrorResponse
+		if json.Unmarshal(msg, &e) == nil && e.Error.Code != "" {
+			return fmt.Errorf("%s %s: %s: %s", method, path, e.Error.Code, e.Error.Message)
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func runAdmin(args []string) int {
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), adminUsage) }
+	server := os.Getenv("IROPICO_SERVER")
+	if server == "" {
+		server = "http://localhost:8080"
+	}
+	c := &adminClient{http: &http.Client{Timeout: 2 * maxTailWait}}
+	fs.StringVar(&c.server, "server", server, "server base URL")
+	fs.StringVar(&c.token, "token", os.Getenv("IROPICO_ADMIN_TOKEN"), "admin API key")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	var err error
+	switch cmd {
+	case "close-round":
+		err = adminCloseRound(ctx, c, rest)
+	case "rescore":
+		err = adminRescore(ctx, c, rest)
+	case "rescore-rooms":
+		err = adminRescoreRooms(ctx, c, rest)
+	case "rotate-theme":
+		err = adminRotateTheme(ctx, c, rest)
+	case "flush-cache":
+		var resp FlushCacheResp
+		if err = c.do(ctx, http.MethodPost, "/admin/cache/flush", nil, &resp); err == nil {
+			err = printJSON(resp)
+		}
+	case "reload-config":
+		var resp ConfigReloadResp
+		if err = c.do(ctx, http.MethodPost, "/admin/config", nil, &resp); err == nil {
+			err = printJSON(resp)
+		}
+	case "tail-flagged":
+		err = adminTailFlagged(ctx, c, rest)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown admin command %q\n\n%s", cmd, adminUsage)
+		return 2
+	}
+	if errors.Is(err, errUsage) {
+		return 2
+	}
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("usage")
+
+// themeArg normalizes a theme given in any form ParseColor accepts.
+func themeArg(s string) (string, error) {
+	r, g, b, _, err := colormath.ParseColor(s)
+	if err != nil {
+		return "", fmt.Errorf("bad theme %q: %w", s, err)
+	}
+	return themeKey(r, g, b), nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func adminCloseRound(ctx context.Context, c *adminClient, args []string) error {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: iropico admin close-round <hex>")
+		return errUsage
+	}
+	theme, err := themeArg(args[0])
+	if err != nil {
+		return err
+	}
+	var rec Retrospective
+	if err := c.do(ctx, http.MethodPost, "/admin/rounds/"+theme+"/close", nil, &rec); err != nil {
+		return err
+	}
+	return printJSON(rec)
+}
+
+func adminRescore(ctx context.Context, c *adminClient, args []string) error {
+	fs := flag.NewFlagSet("rescore", flag.ContinueOnError)
+	var req RescoreRequest
+	fs.StringVar(&req.Metric, "metric", "", "color metric (default: server's)")
+	fs.StringVar(&req.Aggregation, "aggregation", "", "aggregation (default: server's)")
+	fs.StringVar(&req.Normalization, "normalization", "", "gamut or global (default: server's)")
+	fs.StringVar(&req.Background, "background", "", "alpha, white, theme or ignore (default: server's)")
+	fs.StringVar(&req.Grayscale, "grayscale", "", "off, auto or on (default: server's)")
+	fs.StringVar(&req.Vision, "vision", "", "normal, protanopia, deuteranopia or tritanopia (default: normal)")
+	fs.StringVar(&req.Lang, "lang", "", "feedback language: ja or en (default: ja)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: iropico admin rescore [flags] <image-id> <hex>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		if err == nil {
+			fs.Usage()
+		}
+		return errUsage
+	}
+	id := strings.ToLower(fs.Arg(0))
+	if !validImageID(id) {
+		return fmt.Errorf("bad image id %q: want hex sha256", fs.Arg(0))
+	}
+	theme, err := themeArg(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	req.ThemeHex = "#" + theme
+	var resp ScoreResponse
+	if err := c.do(ctx, http.MethodPost, "/admin/archive/"+id+"/score", req, &resp); err != nil {
+		return err
+	}
+	return printJSON(resp)
+}
+
+func adminRescoreRooms(ctx context.Context, c *adminClient, args []string) error {
+	fs := flag.NewFlagSet("rescore-rooms", flag.ContinueOnError)
+	var req RoomRescoreRequest
+	var from, to string
+	fs.StringVar(&from, "from", "", "without room IDs, rooms created at or after this RFC 3339 time")
+	fs.StringVar(&to, "to", "", "without room IDs, rooms created before this RFC 3339 time")
+	fs.StringVar(&req.Metric, "metric", "", "color metric (default: version's or server's)")
+	fs.StringVar(&req.Aggregation, "aggregation", "", "aggregation (default: version's or server's)")
+	fs.StringVar(&req.ScoringVersion, "version", "", "scoring version supplying the method (default: v1)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: iropico admin rescore-rooms [flags] [room-id...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	req.RoomIDs = fs.Args()
+	for _, t := range []struct {
+		flag, v string
+		dst     *time.Time
+	}{{"from", from, &req.From}, {"to", to, &req.To}} {
+		if t.v == "" {
+			continue
+		}
+		var err error
+		if *t.dst, err = time.Parse(time.RFC3339, t.v); err != nil {
+			return fmt.Errorf("bad -%s: %w", t.flag, err)
+		}
+	}
+	var resp RoomRescoreResp
+	if err := c.do(ctx, http.MethodPost, "/admin/rescore", req, &resp); err != nil {
+		return err
+	}
+	return printJSON(resp)
+}
+
+func adminRotateTheme(ctx context.Context, c *adminClient, args []string) error {
+	fs := flag.NewFlagSet("rotate-theme", flag.ContinueOnError)
+	var req RotateThemeRequest
+	fs.BoolVar(&req.KeepRound, "keep-round", false, "leave the previous theme's round open")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: iropico admin rotate-theme [-keep-round] <hex>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		if err == nil {
+			fs.Usage()
+		}
+		return errUsage
+	}
+	theme, err := themeArg(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	req.ThemeHex = "#" + theme
+	var resp RotateThemeResp
+	if err := c.do(ctx, http.MethodPost, "/admin/theme", req, &resp); err != nil {
+		return err
+	}
+	return printJSON(resp)
+}
+
+// adminTailFlagged prints flagged submissions as JSON lines until
+// interrupted, retrying with backoff when the server is unreachable.
+func adminTailFlagged(ctx context.Context, c *adminClient, args []string) error {
+	fs := flag.NewFlagSet("tail-flagged", flag.ContinueOnError)
+	after := fs.Int64("after", 0, "start after this seq (0 prints the whole queue)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	enc := json.NewEncoder(os.Stdout)
+	backoff := time.Second
+	for ctx.Err() == nil {
+		q := url.Values{"after": {strconv.FormatInt(*after, 10)}, "wait": {maxTailWait.String()}}
+		var resp FlaggedResp
+		if err := c.do(ctx, http.MethodGet, "/admin/flagged?"+q.Encode(), nil, &resp); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "admin: %v; retrying in %s\n", err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+			}
+			backoff = min(2*backoff, time.Minute)
+			continue
+		}
+		backoff = time.Second
+		for _, f := range resp.Items {
+			if err := enc.Encode(f); err != nil {
+				return err
+			}
+		}
+		*after = resp.Next
+	}
+	return nil
+}
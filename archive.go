@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errBlobNotFound = errors.New("blob not found")
+	errBlobChanged  = errors.New("blob changed")
+)
+
+// blobStore is the byte store behind the submission archive. Keys are
+// slash-separated relative paths.
+type blobStore interface {
+	get(key string) ([]byte, error)
+	put(key string, data []byte) error
+	delete(key string) error
+	// getVersion is get that also returns a version identifying the
+	// blob's current contents.
+	getVersion(key string) ([]byte, string, error)
+	// putIf is put that only succeeds, returning the new version, while
+	// key is at version, "" meaning it does not exist; otherwise it fails
+	// with errBlobChanged.
+	putIf(key string, data []byte, version string) (string, error)
+}
+
+var errNoSignedURLs = errors.New("store cannot sign urls")
+
+// urlSigner is implemented by stores that can hand out time-limited URLs
+// for reading a blob directly.
+type urlSigner interface {
+	signedURL(key string, ttl time.Duration) (string, error)
+}
+
+// diskStore keeps blobs as files under dir. Its versions are content
+// hashes, checked under mu, so its preconditions hold for the one process
+// writing a local directory.
+type diskStore struct {
+	dir string
+
+	mu sync.Mutex
+}
+
+func newDiskStore(dir string) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskStore{dir: dir}, nil
+}
+
+func (s *diskStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s *diskStore) get(key string) ([]byte, error) {
+	b, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return b, err
+}
+
+func (s *diskStore) put(key string, data []byte) error {
+	p := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *diskStore) delete(key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *diskStore) getVersion(key string) ([]byte, string, error) {
+	b, err := s.get(key)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, imageID(b), nil
+}
+
+func (s *diskStore) putIf(key string, data []byte, version string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, cur, err := s.getVersion(key)
+	if err != nil && !errors.Is(err, errBlobNotFound) {
+		return "", err
+	}
+	if cur != version {
+		return "", errBlobChanged
+	}
+	if err := s.put(key, data); err != nil {
+		return "", err
+	}
+	return imageID(data), nil
+}
+
+// archive stores submitted images; nil when archiving is disabled.
+var archive *imageArchive
+
+// imageArchive stores each distinct image once under its SHA-256 and counts
+// the submissions referencing it, so retries and duplicate uploads share one
+// blob. The blob is deleted when the last reference is released. Counts live
+// next to the blobs and only change through the store's preconditions, so
+// replicas can share a bucket.
+type imageArchive struct {
+	store blobStore
+
+	// pending counts the adds still running in the background.
+	pending sync.WaitGroup
+}
+
+func newImageArchive(store blobStore) *imageArchive {
+	return &imageArchive{store: store}
+}
+
+// Updating a reference count is retried up to archiveRetries times while
+// other writers change it, archiveRetryDelay apart and longer each time.
+// A release deleting an image marks its count for archiveDeleteTimeout,
+// holding off adds that would race the deletion; a mark older than that
+// was left by a release that never finished.
+const (
+	archiveRetries       = 8
+	archiveRetryDelay    = 50 * time.Millisecond
+	archiveDeleteTimeout = 2 * time.Minute
+)
+
+var errRefsContended = errors.New("reference count kept changing")
+
+func imageID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func validImageID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == sha256.Size && id == strings.ToLower(id)
+}
+
+func blobKey(id string) string { return path.Join("images", id[:2], id) }
+func refsKey(id string) string { return path.Join("refs", id[:2], id) }
+
+// refCount is the stored reference count of an image; N is -1 while the
+// image is being deleted. At, the time of the write, makes every write's
+// contents new, so a count returning to an earlier value never matches a
+// version read before.
+type refCount struct {
+	N  int
+	At time.Time
+}
+
+// refs reads id's reference count and its version, "" if there is none.
+func (a *imageArchive) refs(id string) (refCount, string, error) {
+	b, v, err := a.store.getVersion(refsKey(id))
+	if errors.Is(err, errBlobNotFound) {
+		return refCount{}, "", nil
+	}
+	if err != nil {
+		return refCount{}, "", err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return refCount{}, "", fmt.Errorf("refs %s: empty", id)
+	}
+	var rc refCount
+	if rc.N, err = strconv.Atoi(fields[0]); err != nil {
+		return refCount{}, "", fmt.Errorf("refs %s: %w", id, err)
+	}
+	if len(fields) > 1 {
+		ns, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return refCount{}, "", fmt.Errorf("refs %s: %w", id, err)
+		}
+		rc.At = time.Unix(0, ns)
+	}
+	return rc, v, nil
+}
+
+// setRefs stores n as id's reference count if it is still at version.
+func (a *imageArchive) setRefs(id string, n int, version string) (string, error) {
+	return a.store.putIf(refsKey(id), fmt.Appendf(nil, "%d %d\n", n, time.Now().UnixNano()), version)
+}
+
+// retry waits before attempt, the first one not at all.
+func retry(attempt int) {
+	time.Sleep(time.Duration(attempt) * archiveRetryDelay)
+}
+
+// add stores data if it is new and takes a reference to it.
+func (a *imageArchive) add(data []byte) (string, error) {
+	id := imageID(data)
+	for attempt := range archiveRetries {
+		retry(attempt)
+		rc, v, err := a.refs(id)
+		if err != nil {
+			return "", err
+		}
+		if rc.N < 0 && time.Since(rc.At) < archiveDeleteTimeout {
+			continue
+		}
+		n := max(rc.N, 0)
+		// The blob goes in before the count; were it deleted since the
+		// count was read, the count has changed and this attempt fails.
+		if n == 0 {
+			if err := a.store.put(blobKey(id), data); err != nil {
+				return "", err
+			}
+		}
+		_, err = a.setRefs(id, n+1, v)
+		if errors.Is(err, errBlobChanged) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+	return "", fmt.Errorf("add %s: %w", id, errRefsContended)
+}
+
+// addAsync is add in the background, logging a failure; wait waits for
+// it.
+func (a *imageArchive) addAsync(data []byte) {
+	a.pending.Add(1)
+	go func() {
+		defer a.pending.Done()
+		if _, err := a.add(data); err != nil {
+			log.Printf("archive: %v", err)
+		}
+	}()
+}
+
+// wait waits for the adds addAsync started.
+func (a *imageArchive) wait() { a.pending.Wait() }
+
+// release drops one reference to id and deletes the image with the last
+// one. It returns the remaining count.
+func (a *imageArchive) release(id string) (int, error) {
+	for attempt := range archiveRetries {
+		retry(attempt)
+		rc, v, err := a.refs(id)
+		if err != nil {
+			return 0, err
+		}
+		if rc.N <= 0 {
+			return 0, errBlobNotFound
+		}
+		if rc.N > 1 {
+			_, err := a.setRefs(id, rc.N-1, v)
+			if errors.Is(err, errBlobChanged) {
+				continue
+			}
+			return rc.N - 1, err
+		}
+		marked, err := a.setRefs(id, -1, v)
+		if errors.Is(err, errBlobChanged) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := a.store.delete(blobKey(id)); err != nil {
+			return 0, err
+		}
+		// The count stays behind at 0 rather than being deleted, so an
+		// add that read no count before this one existed cannot match.
+		if _, err := a.setRefs(id, 0, marked); err != nil && !errors.Is(err, errBlobChanged) {
+			return 0, err
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("release %s: %w", id, errRefsContended)
+}
+
+func (a *imageArchive) get(id string) ([]byte, error) {
+	return a.store.get(blobKey(id))
+}
+
+// url returns a signed URL for reading image id straight from the store,
+// or "" if the store cannot sign URLs.
+func (a *imageArchive) url(id string) string {
+	u, ok := a.store.(urlSigner)
+	if !ok {
+		return ""
+	}
+	s, err := u.signedURL(blobKey(id), time.Duration(config().Archive.SignedURLTTL))
+	if err != nil {
+		if !errors.Is(err, errNoSignedURLs) {
+			log.Printf("archive: sign url: %v", err)
+		}
+		return ""
+	}
+	return s
+}
+
+type ArchiveReleaseResp struct {
+	Refs int `json:"refs" doc:"References left; 0 means the image was deleted."`
+}
+
+func archivedImage(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if archive == nil {
+		writeError(w, r, http.StatusNotFound, codeArchiveDisabled, "archive disabled")
+		return "", false
+	}
+	id := r.PathValue("id")
+	if !validImageID(id) {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "id", Msg: "bad image id: want lowercase hex sha256"})
+		return "", false
+	}
+	return id, true
+}
+
+func handleGetArchivedImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := archivedImage(w, r)
+	if !ok {
+		return
+	}
+	b, err := archive.get(id)
+	if errors.Is(err, errBlobNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not archived")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "archive: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(b))
+	w.Write(b)
+}
+
+// handleReleaseArchivedImage drops one submission's reference to an image.
+func handleReleaseArchivedImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := archivedImage(w, r)
+	if !ok {
+		return
+	}
+	n, err := archive.release(id)
+	if errors.Is(err, errBlobNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not archived")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "archive: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ArchiveReleaseResp{Refs: n})
+}
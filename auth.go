@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// loadKeys combines inline keys with those in path (one key per line, #
+// comments allowed).
+func loadKeys(inline []string, path string) ([]string, error) {
+	keys := append([]string(nil), inline...)
+	if path == "" {
+		return keys, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+type ctxKey int
+
+const (
+	principalKey ctxKey = iota
+	receivedAtKey
+)
+
+// principal is who a request was authenticated as: a service holding an API
+// key, or a player identified by a verified ID token.
+type principal struct {
+	APIKey  bool
+	Admin   bool
+	Sandbox bool
+	UserID  string
+}
+
+func principalFrom(ctx context.Context) principal {
+	p, _ := ctx.Value(principalKey).(principal)
+	return p
+}
+
+var errAdminDisabled = errors.New("admin api disabled")
+
+// authenticator accepts either a configured API key or, when verifier is
+// set, a valid ID token as the bearer credential; with neither configured,
+// public calls are open. Sandbox keys are always accepted and mark the
+// principal as sandboxed. Admin calls always require one of adminKeys and
+// are disabled when there are none. It backs both the HTTP and gRPC servers.
+type authenticator struct {
+	keys        [][32]byte
+	sandboxKeys [][32]byte
+	adminKeys   [][32]byte
+	verifier    *jwtVerifier
+}
+
+func newAuthenticator(keys, sandboxKeys, adminKeys []string, verifier *jwtVerifier) *authenticator {
+	return &authenticator{keys: hashKeys(keys), sandboxKeys: hashKeys(sandboxKeys), adminKeys: hashKeys(adminKeys), verifier: verifier}
+}
+
+// sandboxed reports whether an Authorization header carries a sandbox key.
+func (a *authenticator) sandboxed(header string) bool {
+	token, ok := bearerToken(header)
+	return ok && len(a.sandboxKeys) > 0 && matchKey(token, a.sandboxKeys)
+}
+
+func (a *authenticator) open() bool { return len(a.keys) == 0 && a.verifier == nil }
+
+// authenticate checks an Authorization header value.
+func (a *authenticator) authenticate(ctx context.Context, header string, admin bool) (principal, error) {
+	token, ok := bearerToken(header)
+	if admin {
+		if len(a.adminKeys) == 0 {
+			return principal{}, errAdminDisabled
+		}
+		if !ok || !matchKey(token, a.adminKeys) {
+			return principal{}, errors.New("unauthorized")
+		}
+		return principal{APIKey: true, Admin: true}, nil
+	}
+	switch {
+	case a.sandboxed(header):
+		return principal{APIKey: true, Sandbox: true}, nil
+	case a.open():
+		return principal{}, nil
+	case ok && matchKey(token, a.keys):
+		return principal{APIKey: true}, nil
+	case ok && a.verifier != nil && looksLikeJWT(token):
+		claims, err := a.verifier.verify(ctx, token)
+		if err != nil {
+			return principal{}, fmt.Errorf("invalid id token: %w", err)
+		}
+		return principal{UserID: claims.Subject}, nil
+	}
+	return principal{}, errors.New("unauthorized")
+}
+
+// withAuth authenticates every request but those to the public paths.
+func withAuth(next http.Handler, auth *authenticator, public map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if public[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := auth.authenticate(r.Context(), r.Header.Get("Authorization"), strings.HasPrefix(r.URL.Path, "/admin/"))
+		if errors.Is(err, errAdminDisabled) {
+			writeError(w, r, http.StatusForbidden, codeAdminDisabled, err.Error())
+			return
+		}
+		if err != nil {
+			unauthorized(w, r, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, p)))
+	})
+}
+
+func hashKeys(keys []string) [][32]byte {
+	hashed := make([][32]byte, len(keys))
+	for i, k := range keys {
+		hashed[i] = sha256.Sum256([]byte(k))
+	}
+	return hashed
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="iropico"`)
+	writeError(w, r, http.StatusUnauthorized, codeUnauthorized, msg)
+}
+
+func bearerToken(h string) (string, bool) {
+	const prefix = "bearer "
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	t := strings.TrimSpace(h[len(prefix):])
+	return t, t != ""
+}
+
+// matchKey compares digests so neither key length nor position leaks via timing.
+func matchKey(token string, hashed [][32]byte) bool {
+	sum := sha256.Sum256([]byte(token))
+	found := 0
+	for i := range hashed {
+		found |= subtle.ConstantTimeCompare(sum[:], hashed[i][:])
+	}
+	return found == 1
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// flightGroup runs at most one call per key at a time; callers that arrive
+// while it is running wait for it and share its result. Used to collapse
+// double-tap submissions into a single decode and scan.
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+}
+
+// Do reports shared=true to callers that received another caller's result.
+func (g *flightGroup[T]) Do(key string, fn func() (T, error)) (v T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall[T]{}
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.val, c.err, true
+	}
+	c := &flightCall[T]{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		if p := recover(); p != nil {
+			c.err = fmt.Errorf("panic: %v", p)
+			g.finish(key, c)
+			panic(p)
+		}
+		g.finish(key, c)
+	}()
+	c.val, c.err = fn()
+	return c.val, c.err, false
+}
+
+func (g *flightGroup[T]) finish(key string, c *flightCall[T]) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"math"
+	"net/http"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// ColorNames names a color for players, e.g. "あなたの色は 茜色 でした".
+type ColorNames struct {
+	CSS      NamedColor `json:"css" doc:"Nearest CSS named color."`
+	Japanese NamedColor `json:"japanese" doc:"Nearest traditional Japanese color (和色)."`
+}
+
+type NamedColor struct {
+	Name     string  `json:"name"`
+	Hex      string  `json:"hex" doc:"The named color's own value."`
+	Distance float64 `json:"distance" doc:"CIEDE2000 difference from the named color, rounded to two decimals; under about 2 is barely visible."`
+}
+
+type NearestColorsResp struct {
+	Hex   string     `json:"hex"`
+	Names ColorNames `json:"names"`
+}
+
+// colorNames finds the nearest named colors to a linear sRGB color.
+func colorNames(lr, lg, lb float64) ColorNames {
+	nearest := func(list []colormath.NamedColor) NamedColor {
+		c, d := colormath.NearestNamed(list, lr, lg, lb)
+		return NamedColor{Name: c.Name, Hex: "#" + themeKey(c.R, c.G, c.B), Distance: math.Round(d*100) / 100}
+	}
+	return ColorNames{CSS: nearest(colormath.CSSColors), Japanese: nearest(colormath.JapaneseColors)}
+}
+
+func handleNearestColors(w http.ResponseWriter, r *http.Request) {
+	hex := cmp.Or(r.URL.Query().Get("hex"), activeTheme.get().ThemeHex)
+	cr, cg, cb, _, err := colormath.ParseColor(hex)
+	if err != nil {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidThemeHex, Field: "hex", Msg: "bad hex: " + err.Error()})
+		return
+	}
+	lin := colormath.SRGB8ToLinear
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NearestColorsResp{Hex: "#" + themeKey(cr, cg, cb), Names: colorNames(lin(cr), lin(cg), lin(cb))})
+}
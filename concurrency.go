@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// work bounds concurrent image decoding across HTTP and gRPC; main sets it
+// from the config.
+var work *workLimiter
+
+// workLimiter is a semaphore with a bounded wait queue. Decoding holds a
+// full-size pixel buffer, so this, not request rate, is what keeps a burst
+// of large uploads within the instance's memory.
+type workLimiter struct {
+	slots    chan struct{}
+	queued   atomic.Int64
+	maxQueue int64
+	wait     time.Duration
+}
+
+func newWorkLimiter(lc LimitsConfig) *workLimiter {
+	return &workLimiter{
+		slots:    make(chan struct{}, lc.MaxConcurrent),
+		maxQueue: int64(lc.MaxQueued),
+		wait:     time.Duration(lc.QueueTimeout),
+	}
+}
+
+var errOverloaded = &requestError{Status: http.StatusServiceUnavailable, Code: codeOverloaded, Msg: "server busy; retry shortly",
+	Details: map[string]any{"retry_after_seconds": 1}}
+
+// acquire takes a slot, waiting in the queue if there is room; the caller
+// must call release when done.
+func (l *workLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		return errOverloaded
+	}
+	defer l.queued.Add(-1)
+	t := time.NewTimer(l.wait)
+	defer t.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-t.C:
+		return errOverloaded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *workLimiter) release() { <-l.slots }
+
+// wrap runs h in a slot; a nil limiter, as in the CLI, does not limit.
+func (l *workLimiter) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := l.acquire(r.Context()); err != nil {
+			if err == errOverloaded {
+				w.Header().Set("Retry-After", "1")
+				writeRequestError(w, r, err)
+			}
+			return // the client went away
+		}
+		defer l.release()
+		h(w, r)
+	}
+}
+
+// unaryInterceptor limits every RPC; they all decode images.
+func (l *workLimiter) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := l.acquire(ctx); err != nil {
+			if err == errOverloaded {
+				return nil, grpcError(err)
+			}
+			return nil, status.FromContextError(err).Err()
+		}
+		defer l.release()
+		return handler(ctx, req)
+	}
+}
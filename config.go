@@ -0,0 +1,667 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+	"github.com/hiromuota166/iropico_color_calc/pkg/scoring"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every tunable. It is built from defaults, then the optional
+// file named by CONFIG_FILE (.json, .yaml or .yml), then environment
+// variables, and validated before the server starts. A reload (SIGHUP or
+// POST /admin/config) repeats this and swaps in the result if it changes
+// only reloadableFields.
+type Config struct {
+	Port string `json:"port" yaml:"port"`
+
+	Server    ServerConfig    `json:"server" yaml:"server"`
+	GRPC      GRPCConfig      `json:"grpc" yaml:"grpc"`
+	CORS      CORSConfig      `json:"cors" yaml:"cors"`
+	Auth      AuthConfig      `json:"auth" yaml:"auth"`
+	Scoring   ScoringConfig   `json:"scoring" yaml:"scoring"`
+	Limits    LimitsConfig    `json:"limits" yaml:"limits"`
+	Cache     CacheConfig     `json:"cache" yaml:"cache"`
+	RateLimit RateLimitConfig `json:"rate_limit" yaml:"rate_limit"`
+	Quota     QuotaConfig     `json:"quota" yaml:"quota"`
+	Debug     DebugConfig     `json:"debug" yaml:"debug"`
+	// Experiment assigns callers to scoring-method variants; see
+	// experiment.go.
+	Experiment ExperimentConfig `json:"experiment" yaml:"experiment"`
+
+	RetroDir string `json:"retro_dir" yaml:"retro_dir"`
+	// ArchiveDir keeps every submitted image, deduplicated; empty disables
+	// archiving unless Archive names a bucket instead.
+	ArchiveDir string             `json:"archive_dir" yaml:"archive_dir"`
+	Archive    ArchiveStoreConfig `json:"archive" yaml:"archive"`
+	// RoomDir persists game rooms; empty keeps them in memory only.
+	RoomDir string `json:"room_dir" yaml:"room_dir"`
+	// StatsDir persists the score counts behind /stats; empty keeps them in
+	// memory only.
+	StatsDir string `json:"stats_dir" yaml:"stats_dir"`
+}
+
+// ArchiveStoreConfig keeps the archive in an S3-compatible bucket: Amazon
+// S3, or Google Cloud Storage through its XML API with HMAC keys (endpoint
+// https://storage.googleapis.com, region auto).
+type ArchiveStoreConfig struct {
+	Bucket string `json:"bucket" yaml:"bucket"`
+	// Endpoint defaults to Amazon S3 in Region.
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	Region   string `json:"region" yaml:"region"`
+	// Prefix, if set, is prepended to every object key as a directory.
+	Prefix string `json:"prefix" yaml:"prefix"`
+	// PathStyle addresses objects as endpoint/bucket/key rather than
+	// bucket.endpoint/key, for MinIO and similar servers.
+	PathStyle       bool   `json:"path_style" yaml:"path_style"`
+	AccessKeyID     string `json:"access_key_id" yaml:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key" yaml:"secret_access_key"`
+	// SignedURLTTL is how long image URLs in score responses and round
+	// galleries stay valid; at most 7 days.
+	SignedURLTTL Duration `json:"signed_url_ttl" yaml:"signed_url_ttl"`
+}
+
+type ServerConfig struct {
+	ReadHeaderTimeout Duration `json:"read_header_timeout" yaml:"read_header_timeout"`
+	ReadTimeout       Duration `json:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout      Duration `json:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	ShutdownTimeout   Duration `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+	// AccessLog logs a line per request; see withAccessLog.
+	AccessLog bool `json:"access_log" yaml:"access_log"`
+}
+
+type GRPCConfig struct {
+	// Port for the gRPC listener; empty disables it.
+	Port string `json:"port" yaml:"port"`
+}
+
+type DebugConfig struct {
+	// PprofAddr is the listen address ("localhost:6060") of a separate
+	// server for net/http/pprof; empty disables it. It has no
+	// authentication, so bind it to loopback or a private interface.
+	PprofAddr string `json:"pprof_addr" yaml:"pprof_addr"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `json:"allowed_origins" yaml:"allowed_origins"`
+}
+
+type AuthConfig struct {
+	APIKeys     []string `json:"api_keys" yaml:"api_keys"`
+	APIKeysFile string   `json:"api_keys_file" yaml:"api_keys_file"`
+	// Sandbox keys write to an isolated namespace; see sandbox.go.
+	SandboxAPIKeys     []string  `json:"sandbox_api_keys" yaml:"sandbox_api_keys"`
+	SandboxAPIKeysFile string    `json:"sandbox_api_keys_file" yaml:"sandbox_api_keys_file"`
+	AdminAPIKeys       []string  `json:"admin_api_keys" yaml:"admin_api_keys"`
+	AdminAPIKeysFile   string    `json:"admin_api_keys_file" yaml:"admin_api_keys_file"`
+	JWT                JWTConfig `json:"jwt" yaml:"jwt"`
+}
+
+type JWTConfig struct {
+	FirebaseProjectID string `json:"firebase_project_id" yaml:"firebase_project_id"`
+	JWKSURL           string `json:"jwks_url" yaml:"jwks_url"`
+	Issuer            string `json:"issuer" yaml:"issuer"`
+	Audience          string `json:"audience" yaml:"audience"`
+}
+
+type ScoringConfig struct {
+	// MaxSamples is the per-image pixel sampling budget.
+	// The metric, aggregation and normalization are pinned by the scoring
+	// version instead; see scoring.Versions.
+	MaxSamples int `json:"max_samples" yaml:"max_samples"`
+	// CurveExponent shapes the final score as 100·(s/100)^exp; 1 is linear,
+	// values above 1 make high scores harder to reach.
+	CurveExponent float64 `json:"curve_exponent" yaml:"curve_exponent"`
+	// Background is the default treatment of transparent pixels.
+	Background string `json:"background" yaml:"background"`
+	// MinOpaqueFraction rejects images with a smaller share of opaque
+	// pixels under the "ignore" and "theme" backgrounds.
+	MinOpaqueFraction float64 `json:"min_opaque_fraction" yaml:"min_opaque_fraction"`
+	// Grayscale is the default grayscale mode: off, auto or on.
+	Grayscale string `json:"grayscale" yaml:"grayscale"`
+	// VividnessBonus in [0, 1] makes that share of the score depend on
+	// matching the theme's vividness, so dull approximations lose to vivid
+	// matches; 0 disables it.
+	VividnessBonus float64 `json:"vividness_bonus" yaml:"vividness_bonus"`
+	// BlobBonus in [0, 1] makes that share of the score depend on the
+	// matching color forming one sizable region rather than scattered
+	// pixels; 0 disables it.
+	BlobBonus float64 `json:"blob_bonus" yaml:"blob_bonus"`
+}
+
+// CacheConfig bounds the caches behind retried uploads: score results by
+// image, and responses by Idempotency-Key.
+type CacheConfig struct {
+	// Entries is the most results kept; 0 disables the cache.
+	Entries int      `json:"entries" yaml:"entries"`
+	TTL     Duration `json:"ttl" yaml:"ttl"`
+	// IdempotencyEntries is the most keyed responses kept; 0 disables
+	// Idempotency-Key support.
+	IdempotencyEntries int      `json:"idempotency_entries" yaml:"idempotency_entries"`
+	IdempotencyTTL     Duration `json:"idempotency_ttl" yaml:"idempotency_ttl"`
+}
+
+type LimitsConfig struct {
+	MaxBodyBytes int64 `json:"max_body_bytes" yaml:"max_body_bytes"`
+	// Images declaring larger dimensions are rejected before decoding;
+	// the pixel limit bounds decoder memory at about 4 bytes per pixel.
+	MaxImageWidth  int `json:"max_image_width" yaml:"max_image_width"`
+	MaxImageHeight int `json:"max_image_height" yaml:"max_image_height"`
+	MaxImagePixels int `json:"max_image_pixels" yaml:"max_image_pixels"`
+	// MaxConcurrent bounds requests decoding or scoring at once; size it so
+	// MaxConcurrent × MaxImagePixels × 4 bytes fits in memory. Up to
+	// MaxQueued more wait at most QueueTimeout for a slot before a 503.
+	MaxConcurrent int      `json:"max_concurrent" yaml:"max_concurrent"`
+	MaxQueued     int      `json:"max_queued" yaml:"max_queued"`
+	QueueTimeout  Duration `json:"queue_timeout" yaml:"queue_timeout"`
+	// ScoreTimeout bounds decoding and scoring one submission, after which
+	// it fails with a 503 instead of holding its slot; 0 does not bound
+	// it. Work also stops when the client goes away.
+	ScoreTimeout Duration `json:"score_timeout" yaml:"score_timeout"`
+}
+
+type RateLimitConfig struct {
+	// RequestsPerMinute per client IP; 0 disables rate limiting.
+	RequestsPerMinute float64 `json:"requests_per_minute" yaml:"requests_per_minute"`
+	Burst             int     `json:"burst" yaml:"burst"`
+	// SandboxRequestsPerMinute applies to sandbox keys instead; 0 leaves
+	// them unlimited.
+	SandboxRequestsPerMinute float64 `json:"sandbox_requests_per_minute" yaml:"sandbox_requests_per_minute"`
+	// TrustedProxies is how many proxies in front of the server append to
+	// X-Forwarded-For; the client is the hop that many from the end. 0
+	// ignores the header and uses the connection's address.
+	TrustedProxies int `json:"trusted_proxies" yaml:"trusted_proxies"`
+}
+
+// QuotaConfig caps scored submissions per client and UTC day; see quota.go.
+type QuotaConfig struct {
+	// DailySubmissions per player: the user ID of an ID token, or else the
+	// client IP. 0 leaves players unlimited.
+	DailySubmissions int `json:"daily_submissions" yaml:"daily_submissions"`
+	// APIKeyDailySubmissions per API key, for services submitting on their
+	// players' behalf; 0 leaves them unlimited.
+	APIKeyDailySubmissions int `json:"api_key_daily_submissions" yaml:"api_key_daily_submissions"`
+	// SandboxDailySubmissions per sandbox key; 0 leaves them unlimited.
+	SandboxDailySubmissions int `json:"sandbox_daily_submissions" yaml:"sandbox_daily_submissions"`
+	// RedisURL, redis://[user:password@]host:port[/db] or rediss:// for
+	// TLS, keeps the counts in Redis, shared by every instance; empty counts
+	// per instance, in memory.
+	RedisURL string `json:"redis_url" yaml:"redis_url"`
+}
+
+// redacted returns q with any password in RedisURL masked.
+func (q QuotaConfig) redacted() QuotaConfig {
+	if u, err := url.Parse(q.RedisURL); err == nil {
+		q.RedisURL = u.Redacted()
+	}
+	return q
+}
+
+// Duration accepts Go duration strings ("30s", "2m") in config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Port: "8080",
+		Server: ServerConfig{
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(30 * time.Second),
+			WriteTimeout:      Duration(60 * time.Second),
+			IdleTimeout:       Duration(120 * time.Second),
+			// Cloud Run allows 10s between SIGTERM and SIGKILL.
+			ShutdownTimeout: Duration(9 * time.Second),
+		},
+		CORS: CORSConfig{AllowedOrigins: []string{"*"}},
+		Scoring: ScoringConfig{
+			MaxSamples:        4096,
+			CurveExponent:     1,
+			Background:        scoring.BackgroundAlpha,
+			MinOpaqueFraction: 0.1,
+			Grayscale:         scoring.GrayscaleOff,
+		},
+		Limits: LimitsConfig{
+			MaxBodyBytes:   10 << 20,
+			MaxImageWidth:  16384,
+			MaxImageHeight: 16384,
+			MaxImagePixels: 50_000_000,
+			MaxConcurrent:  4,
+			MaxQueued:      64,
+			QueueTimeout:   Duration(10 * time.Second),
+			ScoreTimeout:   Duration(30 * time.Second),
+		},
+		Cache: CacheConfig{
+			Entries:            10000,
+			TTL:                Duration(10 * time.Minute),
+			IdempotencyEntries: 100000,
+			IdempotencyTTL:     Duration(24 * time.Hour),
+		},
+		RateLimit: RateLimitConfig{Burst: 20, TrustedProxies: 1},
+		Archive:   ArchiveStoreConfig{Region: "us-east-1", SignedURLTTL: Duration(time.Hour)},
+	}
+}
+
+var (
+	currentConfig atomic.Pointer[Config]
+	reloadMu      sync.Mutex
+)
+
+func init() { currentConfig.Store(defaultConfig()) }
+
+// config returns the active configuration.
+func config() *Config { return currentConfig.Load() }
+
+func loadConfig() (*Config, error) {
+	c := defaultConfig()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(strings.NewReader(string(b)))
+		dec.DisallowUnknownFields()
+		return dec.Decode(c)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(b)))
+		dec.KnownFields(true)
+		return dec.Decode(c)
+	}
+	return errors.New("unsupported extension (want .json, .yaml or .yml)")
+}
+
+// applyEnv overlays environment variables; the names predate the config
+// file and are kept for existing deployments.
+func (c *Config) applyEnv() error {
+	str := func(env string, dst *string) {
+		if v, ok := os.LookupEnv(env); ok {
+			*dst = v
+		}
+	}
+	list := func(env string, dst *[]string) {
+		if v, ok := os.LookupEnv(env); ok {
+			*dst = splitList(v)
+		}
+	}
+	var errs []error
+	parse := func(env string, set func(string) error) {
+		if v, ok := os.LookupEnv(env); ok {
+			if err := set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", env, err))
+			}
+		}
+	}
+	num := func(env string, dst *int) {
+		parse(env, func(v string) (err error) { *dst, err = strconv.Atoi(v); return })
+	}
+	float := func(env string, dst *float64) {
+		parse(env, func(v string) (err error) { *dst, err = strconv.ParseFloat(v, 64); return })
+	}
+
+	str("PORT", &c.Port)
+	str("GRPC_PORT", &c.GRPC.Port)
+	list("CORS_ALLOWED_ORIGINS", &c.CORS.AllowedOrigins)
+	list("API_KEYS", &c.Auth.APIKeys)
+	str("API_KEYS_FILE", &c.Auth.APIKeysFile)
+	list("SANDBOX_API_KEYS", &c.Auth.SandboxAPIKeys)
+	str("SANDBOX_API_KEYS_FILE", &c.Auth.SandboxAPIKeysFile)
+	list("ADMIN_API_KEYS", &c.Auth.AdminAPIKeys)
+	str("ADMIN_API_KEYS_FILE", &c.Auth.AdminAPIKeysFile)
+	str("FIREBASE_PROJECT_ID", &c.Auth.JWT.FirebaseProjectID)
+	str("JWKS_URL", &c.Auth.JWT.JWKSURL)
+	str("JWT_ISSUER", &c.Auth.JWT.Issuer)
+	str("JWT_AUDIENCE", &c.Auth.JWT.Audience)
+	num("MAX_SAMPLES", &c.Scoring.MaxSamples)
+	float("SCORE_CURVE_EXPONENT", &c.Scoring.CurveExponent)
+	str("DEFAULT_BACKGROUND", &c.Scoring.Background)
+	float("MIN_OPAQUE_FRACTION", &c.Scoring.MinOpaqueFraction)
+	str("DEFAULT_GRAYSCALE", &c.Scoring.Grayscale)
+	float("VIVIDNESS_BONUS", &c.Scoring.VividnessBonus)
+	float("BLOB_BONUS", &c.Scoring.BlobBonus)
+	parse("MAX_BODY_BYTES", func(v string) (err error) { c.Limits.MaxBodyBytes, err = strconv.ParseInt(v, 10, 64); return })
+	num("MAX_IMAGE_WIDTH", &c.Limits.MaxImageWidth)
+	num("MAX_IMAGE_HEIGHT", &c.Limits.MaxImageHeight)
+	num("MAX_IMAGE_PIXELS", &c.Limits.MaxImagePixels)
+	num("MAX_CONCURRENT", &c.Limits.MaxConcurrent)
+	num("MAX_QUEUED", &c.Limits.MaxQueued)
+	parse("QUEUE_TIMEOUT", func(v string) error { return c.Limits.QueueTimeout.UnmarshalText([]byte(v)) })
+	parse("SCORE_TIMEOUT", func(v string) error { return c.Limits.ScoreTimeout.UnmarshalText([]byte(v)) })
+	num("RESULT_CACHE_ENTRIES", &c.Cache.Entries)
+	parse("RESULT_CACHE_TTL", func(v string) error { return c.Cache.TTL.UnmarshalText([]byte(v)) })
+	num("IDEMPOTENCY_CACHE_ENTRIES", &c.Cache.IdempotencyEntries)
+	parse("IDEMPOTENCY_TTL", func(v string) error { return c.Cache.IdempotencyTTL.UnmarshalText([]byte(v)) })
+	float("RATE_LIMIT_PER_MINUTE", &c.RateLimit.RequestsPerMinute)
+	num("RATE_LIMIT_BURST", &c.RateLimit.Burst)
+	float("SANDBOX_RATE_LIMIT_PER_MINUTE", &c.RateLimit.SandboxRequestsPerMinute)
+	num("TRUSTED_PROXIES", &c.RateLimit.TrustedProxies)
+	num("QUOTA_DAILY_SUBMISSIONS", &c.Quota.DailySubmissions)
+	num("QUOTA_API_KEY_DAILY_SUBMISSIONS", &c.Quota.APIKeyDailySubmissions)
+	num("QUOTA_SANDBOX_DAILY_SUBMISSIONS", &c.Quota.SandboxDailySubmissions)
+	str("REDIS_URL", &c.Quota.RedisURL)
+	str("RETRO_DIR", &c.RetroDir)
+	str("ARCHIVE_DIR", &c.ArchiveDir)
+	str("ARCHIVE_BUCKET", &c.Archive.Bucket)
+	str("ARCHIVE_ENDPOINT", &c.Archive.Endpoint)
+	str("ARCHIVE_REGION", &c.Archive.Region)
+	str("ARCHIVE_PREFIX", &c.Archive.Prefix)
+	parse("ARCHIVE_PATH_STYLE", func(v string) (err error) { c.Archive.PathStyle, err = strconv.ParseBool(v); return })
+	// The standard AWS variables work too; the ARCHIVE_ ones take precedence.
+	str("AWS_ACCESS_KEY_ID", &c.Archive.AccessKeyID)
+	str("AWS_SECRET_ACCESS_KEY", &c.Archive.SecretAccessKey)
+	str("ARCHIVE_ACCESS_KEY_ID", &c.Archive.AccessKeyID)
+	str("ARCHIVE_SECRET_ACCESS_KEY", &c.Archive.SecretAccessKey)
+	parse("ARCHIVE_SIGNED_URL_TTL", func(v string) error { return c.Archive.SignedURLTTL.UnmarshalText([]byte(v)) })
+	str("ROOM_DIR", &c.RoomDir)
+	str("STATS_DIR", &c.StatsDir)
+	str("PPROF_ADDR", &c.Debug.PprofAddr)
+	parse("ACCESS_LOG", func(v string) (err error) { c.Server.AccessLog, err = strconv.ParseBool(v); return })
+	str("EXPERIMENT_NAME", &c.Experiment.Name)
+	parse("EXPERIMENT_VARIANTS", func(v string) (err error) { c.Experiment.Variants, err = parseExperimentVariants(v); return })
+	return errors.Join(errs...)
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	bad := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{field}, args...)...))
+	}
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		bad("port", "%q is not a valid TCP port", c.Port)
+	}
+	if c.GRPC.Port != "" {
+		if p, err := strconv.Atoi(c.GRPC.Port); err != nil || p < 1 || p > 65535 {
+			bad("grpc.port", "%q is not a valid TCP port", c.GRPC.Port)
+		} else if c.GRPC.Port == c.Port {
+			bad("grpc.port", "must differ from port")
+		}
+	}
+	if c.Debug.PprofAddr != "" {
+		if _, port, err := net.SplitHostPort(c.Debug.PprofAddr); err != nil {
+			bad("debug.pprof_addr", "%q is not a host:port address", c.Debug.PprofAddr)
+		} else if port == c.Port || port == c.GRPC.Port {
+			bad("debug.pprof_addr", "port must differ from port and grpc.port")
+		}
+	}
+	for _, d := range []struct {
+		name string
+		v    Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+	} {
+		if d.v <= 0 {
+			bad(d.name, "must be positive")
+		}
+	}
+	if len(c.CORS.AllowedOrigins) == 0 {
+		bad("cors.allowed_origins", `must not be empty (use ["*"] to allow any origin)`)
+	}
+	if c.Scoring.MaxSamples < 1 {
+		bad("scoring.max_samples", "must be at least 1, got %d", c.Scoring.MaxSamples)
+	}
+	if err := (scoring.Options{Background: c.Scoring.Background}).Validate(); err != nil {
+		bad("scoring.background", "%v", err)
+	}
+	if err := (scoring.Options{Grayscale: c.Scoring.Grayscale}).Validate(); err != nil {
+		bad("scoring.grayscale", "%v", err)
+	}
+	if c.Scoring.MinOpaqueFraction < 0 || c.Scoring.MinOpaqueFraction > 1 {
+		bad("scoring.min_opaque_fraction", "must be between 0 and 1, got %g", c.Scoring.MinOpaqueFraction)
+	}
+	if c.Scoring.CurveExponent <= 0 {
+		bad("scoring.curve_exponent", "must be positive, got %g", c.Scoring.CurveExponent)
+	}
+	if c.Scoring.VividnessBonus < 0 || c.Scoring.VividnessBonus > 1 {
+		bad("scoring.vividness_bonus", "must be between 0 and 1, got %g", c.Scoring.VividnessBonus)
+	}
+	if c.Scoring.BlobBonus < 0 || c.Scoring.BlobBonus > 1 {
+		bad("scoring.blob_bonus", "must be between 0 and 1, got %g", c.Scoring.BlobBonus)
+	}
+	if c.Limits.MaxBodyBytes < 1 {
+		bad("limits.max_body_bytes", "must be positive, got %d", c.Limits.MaxBodyBytes)
+	}
+	if c.Limits.MaxImageWidth < 1 {
+		bad("limits.max_image_width", "must be positive, got %d", c.Limits.MaxImageWidth)
+	}
+	if c.Limits.MaxImageHeight < 1 {
+		bad("limits.max_image_height", "must be positive, got %d", c.Limits.MaxImageHeight)
+	}
+	if c.Limits.MaxImagePixels < 1 {
+		bad("limits.max_image_pixels", "must be positive, got %d", c.Limits.MaxImagePixels)
+	}
+	if c.Limits.MaxConcurrent < 1 {
+		bad("limits.max_concurrent", "must be at least 1, got %d", c.Limits.MaxConcurrent)
+	}
+	if c.Limits.MaxQueued < 0 {
+		bad("limits.max_queued", "must not be negative, got %d", c.Limits.MaxQueued)
+	}
+	if c.Limits.QueueTimeout < 0 {
+		bad("limits.queue_timeout", "must not be negative")
+	}
+	if c.Limits.ScoreTimeout < 0 {
+		bad("limits.score_timeout", "must not be negative")
+	}
+	if c.Cache.Entries < 0 {
+		bad("cache.entries", "must not be negative, got %d", c.Cache.Entries)
+	}
+	if c.Cache.Entries > 0 && c.Cache.TTL <= 0 {
+		bad("cache.ttl", "must be positive when the cache is enabled")
+	}
+	if c.Cache.IdempotencyEntries < 0 {
+		bad("cache.idempotency_entries", "must not be negative, got %d", c.Cache.IdempotencyEntries)
+	}
+	if c.Cache.IdempotencyEntries > 0 && c.Cache.IdempotencyTTL <= 0 {
+		bad("cache.idempotency_ttl", "must be positive when idempotency keys are enabled")
+	}
+	if c.RateLimit.RequestsPerMinute < 0 {
+		bad("rate_limit.requests_per_minute", "must not be negative")
+	}
+	if c.RateLimit.SandboxRequestsPerMinute < 0 {
+		bad("rate_limit.sandbox_requests_per_minute", "must not be negative")
+	}
+	if c.RateLimit.RequestsPerMinute > 0 && c.RateLimit.Burst < 1 {
+		bad("rate_limit.burst", "must be at least 1 when rate limiting is enabled")
+	}
+	if c.RateLimit.TrustedProxies < 0 {
+		bad("rate_limit.trusted_proxies", "must not be negative, got %d", c.RateLimit.TrustedProxies)
+	}
+	for _, q := range []struct {
+		field string
+		v     int
+	}{
+		{"quota.daily_submissions", c.Quota.DailySubmissions},
+		{"quota.api_key_daily_submissions", c.Quota.APIKeyDailySubmissions},
+		{"quota.sandbox_daily_submissions", c.Quota.SandboxDailySubmissions},
+	} {
+		if q.v < 0 {
+			bad(q.field, "must not be negative, got %d", q.v)
+		}
+	}
+	if c.Quota.RedisURL != "" {
+		if _, err := parseRedisURL(c.Quota.RedisURL); err != nil {
+			bad("quota.redis_url", "%v", err)
+		}
+	}
+	if a := c.Archive; a.Bucket != "" {
+		if c.ArchiveDir != "" {
+			bad("archive.bucket", "set either archive.bucket or archive_dir, not both")
+		}
+		if a.Region == "" {
+			bad("archive.region", "must be set with archive.bucket")
+		}
+		if a.AccessKeyID == "" || a.SecretAccessKey == "" {
+			bad("archive.access_key_id", "archive.access_key_id and archive.secret_access_key must be set with archive.bucket")
+		}
+		if _, err := newS3Store(a); err != nil {
+			bad("archive.endpoint", "%v", err)
+		}
+		if a.SignedURLTTL <= 0 || time.Duration(a.SignedURLTTL) > s3MaxSignedURLTTL {
+			bad("archive.signed_url_ttl", "must be positive and at most 7 days")
+		}
+	}
+	c.Experiment.validate(bad)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// reloadableFields are the config fields, or prefixes of them, that the
+// running server reads per request. Everything else is used once at startup
+// (listeners, timeouts, keys, stores, the work limiter and gRPC's message
+// size), so a reload that changes it is refused rather than half-applied.
+var reloadableFields = []string{
+	"scoring.",
+	"cache.",
+	"rate_limit.",
+	"quota.daily_submissions",
+	"quota.api_key_daily_submissions",
+	"quota.sandbox_daily_submissions",
+	"experiment.",
+	"server.access_log",
+	"limits.max_image_width",
+	"limits.max_image_height",
+	"limits.max_image_pixels",
+	"limits.score_timeout",
+}
+
+// restartRequiredError lists the fields a reload would change that need a
+// restart.
+type restartRequiredError []string
+
+func (e restartRequiredError) Error() string {
+	return "changing " + strings.Join(e, ", ") + " requires a restart"
+}
+
+// reloadConfig loads the configuration again and makes it active, returning
+// the names of the fields that changed. The active config is kept if the
+// new one is invalid or changes a field outside reloadableFields.
+func reloadConfig() ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	changed := changedFields(config(), c)
+	var fixed restartRequiredError
+	for _, f := range changed {
+		if !slices.ContainsFunc(reloadableFields, func(p string) bool { return strings.HasPrefix(f, p) }) {
+			fixed = append(fixed, f)
+		}
+	}
+	if len(fixed) > 0 {
+		return nil, fixed
+	}
+	currentConfig.Store(c)
+	return changed, nil
+}
+
+// changedFields returns the dotted JSON names of the fields that differ
+// between a and b, sorted.
+func changedFields(a, b *Config) []string {
+	fa, fb := configFields(a), configFields(b)
+	var out []string
+	for k, v := range fb {
+		if fa[k] != v {
+			out = append(out, k)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// configFields flattens c into its leaf fields' dotted JSON names and
+// encoded values. Every Config has the same fields, so comparing two maps
+// key by key finds every change.
+func configFields(c *Config) map[string]string {
+	b, _ := json.Marshal(c)
+	var tree map[string]any
+	json.Unmarshal(b, &tree)
+	out := map[string]string{}
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		if m, ok := v.(map[string]any); ok {
+			for k, v := range m {
+				walk(prefix+k+".", v)
+			}
+			return
+		}
+		b, _ := json.Marshal(v)
+		out[strings.TrimSuffix(prefix, ".")] = string(b)
+	}
+	walk("", tree)
+	return out
+}
+
+// imageLimits returns the dimension limits for submitted images.
+func (c *Config) imageLimits() imaging.Limits {
+	return imaging.Limits{MaxWidth: c.Limits.MaxImageWidth, MaxHeight: c.Limits.MaxImageHeight, MaxPixels: c.Limits.MaxImagePixels}
+}
+
+// scoreOptions returns the request-independent scoring options.
+func (c *Config) scoreOptions() scoring.Options {
+	return scoring.Options{
+		MaxSamples:    c.Scoring.MaxSamples,
+		CurveExponent: c.Scoring.CurveExponent,
+		Background:    c.Scoring.Background,
+		Grayscale:     c.Scoring.Grayscale,
+
+		VividnessBonus: c.Scoring.VividnessBonus,
+		BlobBonus:      c.Scoring.BlobBonus,
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+	"github.com/hiromuota166/iropico_color_calc/pkg/scoring"
+)
+
+// testsuite holds a small fixed set of images and themes that is scored
+// under every registered scorer so deployments can be compared.
+//
+//go:embed testsuite
+var testsuiteFS embed.FS
+
+type suiteCase struct {
+	Image    string   `json:"image"`
+	ThemeHex string   `json:"theme_hex"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+}
+
+type ConsistencyRow struct {
+	Image    string    `json:"image"`
+	ThemeHex string    `json:"theme_hex"`
+	Scores   []float64 `json:"scores"`
+	Spread   float64   `json:"spread"`
+}
+
+type ConsistencyFailure struct {
+	Image    string  `json:"image"`
+	ThemeHex string  `json:"theme_hex"`
+	Scorer   string  `json:"scorer"`
+	Score    float64 `json:"score"`
+	Want     string  `json:"want"`
+}
+
+// ConsistencyReport is a cases×scorers matrix: Rows[i].Scores[j] is the
+// score of case i under Scorers[j].
+type ConsistencyReport struct {
+	Scorers   []string             `json:"scorers"`
+	Rows      []ConsistencyRow     `json:"rows"`
+	MaxSpread float64              `json:"max_spread"`
+	Failures  []ConsistencyFailure `json:"failures"`
+}
+
+type testSuite struct {
+	Cases  []suiteCase
+	Images map[string]image.Image
+}
+
+var loadSuite = sync.OnceValues(func() (*testSuite, error) {
+	b, err := testsuiteFS.ReadFile("testsuite/suite.json")
+	if err != nil {
+		return nil, err
+	}
+	var suite struct {
+		Cases []suiteCase `json:"cases"`
+	}
+	if err := json.Unmarshal(b, &suite); err != nil {
+		return nil, fmt.Errorf("suite.json: %w", err)
+	}
+	imgs := map[string]image.Image{}
+	for _, c := range suite.Cases {
+		if imgs[c.Image] != nil {
+			continue
+		}
+		data, err := testsuiteFS.ReadFile("testsuite/" + c.Image)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := imaging.Decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Image, err)
+		}
+		imgs[c.Image] = img
+	}
+	return &testSuite{suite.Cases, imgs}, nil
+})
+
+func consistencyReport() (*ConsistencyReport, error) {
+	suite, err := loadSuite()
+	if err != nil {
+		return nil, err
+	}
+	opts := config().scoreOptions()
+	rep := &ConsistencyReport{Rows: []ConsistencyRow{}, Failures: []ConsistencyFailure{}}
+	for _, sc := range scoring.Scorers {
+		rep.Scorers = append(rep.Scorers, sc.Name)
+	}
+	for _, c := range suite.Cases {
+		tr, tg, tb, _, err := colormath.ParseColor(c.ThemeHex)
+		if err != nil {
+			return nil, fmt.Errorf("suite case %s: %w", c.Image, err)
+		}
+		row := ConsistencyRow{Image: c.Image, ThemeHex: c.ThemeHex}
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, sc := range scoring.Scorers {
+			s := math.Round(sc.Score(suite.Images[c.Image], tr, tg, tb, opts).Score*10) / 10
+			row.Scores = append(row.Scores, s)
+			lo, hi = math.Min(lo, s), math.Max(hi, s)
+			if c.Min != nil && s < *c.Min {
+				rep.Failures = append(rep.Failures, ConsistencyFailure{c.Image, c.ThemeHex, sc.Name, s, fmt.Sprintf(">= %g", *c.Min)})
+			}
+			if c.Max != nil && s > *c.Max {
+				rep.Failures = append(rep.Failures, ConsistencyFailure{c.Image, c.ThemeHex, sc.Name, s, fmt.Sprintf("<= %g", *c.Max)})
+			}
+		}
+		row.Spread = math.Round((hi-lo)*10) / 10
+		rep.MaxSpread = math.Max(rep.MaxSpread, row.Spread)
+		rep.Rows = append(rep.Rows, row)
+	}
+	return rep, nil
+}
+
+func handleConsistency(w http.ResponseWriter, r *http.Request) {
+	rep, err := consistencyReport()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "consistency: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+func withCORS(next http.Handler, origins []string) http.Handler {
+	anyOrigin := slices.Contains(origins, "*")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if o := r.Header.Get("Origin"); o != "" && slices.Contains(origins, o) {
+			w.Header().Set("Access-Control-Allow-Origin", o)
+		}
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Theme-Hex, Idempotency-Key, X-Session-ID")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"strings"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+)
+
+// Error codes are part of the API: clients switch on them, so existing
+// codes must keep their meaning. Messages are for humans and may change.
+const (
+	codeInvalidJSON          = "INVALID_JSON"
+	codeInvalidBase64        = "INVALID_BASE64"
+	codeImageTooLarge        = "IMAGE_TOO_LARGE"
+	codeUnsupportedFormat    = "UNSUPPORTED_FORMAT"
+	codeCorruptImage         = "CORRUPT_IMAGE"
+	codeMostlyTransparent    = "MOSTLY_TRANSPARENT"
+	codeInvalidThemeHex      = "INVALID_THEME_HEX"
+	codeUnknownMetric        = "UNKNOWN_METRIC"
+	codeUnknownAggregation   = "UNKNOWN_AGGREGATION"
+	codeUnknownNormalization = "UNKNOWN_NORMALIZATION"
+	codeUnknownVersion       = "UNKNOWN_API_VERSION"
+	codeInvalidParameter     = "INVALID_PARAMETER"
+	codeUnauthorized         = "UNAUTHORIZED"
+	codeAdminDisabled        = "ADMIN_DISABLED"
+	codeForbidden            = "FORBIDDEN"
+	codeRateLimited          = "RATE_LIMITED"
+	codeQuotaExceeded        = "QUOTA_EXCEEDED"
+	codeOverloaded           = "OVERLOADED"
+	codeScoreTimeout         = "SCORE_TIMEOUT"
+	codeCanceled             = "CANCELED"
+	codeNotFound             = "NOT_FOUND"
+	codeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	codeArchiveDisabled      = "ARCHIVE_DISABLED"
+	codeNoSubmissions        = "NO_SUBMISSIONS"
+	codeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+	codeRoomClosed           = "ROOM_CLOSED"
+	codeRoomFull             = "ROOM_FULL"
+	codeEmptyMask            = "EMPTY_MASK"
+	codeInvalidConfig        = "INVALID_CONFIG"
+	codeRestartRequired      = "RESTART_REQUIRED"
+	codeInternal             = "INTERNAL"
+)
+
+var errorCodes = []string{
+	codeInvalidJSON, codeInvalidBase64, codeImageTooLarge, codeUnsupportedFormat, codeCorruptImage,
+	codeMostlyTransparent, codeInvalidThemeHex, codeUnknownMetric, codeUnknownAggregation, codeUnknownNormalization,
+	codeUnknownVersion, codeInvalidParameter, codeUnauthorized, codeAdminDisabled, codeForbidden, codeRateLimited, codeQuotaExceeded, codeOverloaded,
+	codeScoreTimeout, codeCanceled, codeNotFound, codeMethodNotAllowed, codeArchiveDisabled, codeNoSubmissions, codeIdempotencyKeyReused,
+	codeRoomClosed, codeRoomFull, codeEmptyMask, codeInvalidConfig, codeRestartRequired, codeInternal,
+}
+
+type APIError struct {
+	Code    string         `json:"code" enum:"@errorCodes" doc:"Stable, machine-readable error code."`
+	Message string         `json:"message" doc:"Human-readable description, in Japanese when Accept-Language prefers ja and otherwise English; do not match on it."`
+	Field   string         `json:"field,omitempty" doc:"Request field at fault, when there is one."`
+	Details map[string]any `json:"details,omitempty"`
+	Errors  []FieldError   `json:"errors,omitempty" doc:"Every problem request validation found, at most one per field, this error's first; absent for errors found later, such as an image that fails to decode."`
+}
+
+// FieldError is one problem with a request, described as in APIError.
+type FieldError struct {
+	Code    string         `json:"code" enum:"@errorCodes"`
+	Message string         `json:"message"`
+	Field   string         `json:"field,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// requestError is an error with its HTTP status and API error code; the
+// transports render it with writeRequestError or grpcError.
+type requestError struct {
+	Status  int
+	Code    string
+	Field   string
+	Msg     string
+	Details map[string]any
+	// Fields, set by request validation, lists every problem it found; the
+	// error itself is the first.
+	Fields []*requestError
+}
+
+func (e *requestError) Error() string { return e.Msg }
+
+// writeRequestError writes err as an ErrorResponse, its message in the
+// language r's Accept-Language prefers when the catalog has it.
+func writeRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	status, apiErr := apiError(r, err)
+	if lang := requestLang(r); lang != "" {
+		w.Header().Set("Content-Language", lang)
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if s, ok := apiErr.Details["retry_after_seconds"]; ok && w.Header().Get("Retry-After") == "" {
+		w.Header().Set("Retry-After", fmt.Sprint(s))
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{apiErr})
+}
+
+// apiError renders err for r as writeRequestError does, with its HTTP
+// status, for responses that report errors inside a larger body.
+func apiError(r *http.Request, err error) (int, APIError) {
+	var re *requestError
+	if !errors.As(err, &re) {
+		re = &requestError{Status: http.StatusInternalServerError, Code: codeInternal, Msg: err.Error()}
+	}
+	lang := requestLang(r)
+	message := func(re *requestError) string {
+		if lang == "" {
+			return re.Msg
+		}
+		return localizedMessage(re, lang)
+	}
+	apiErr := APIError{Code: re.Code, Message: message(re), Field: re.Field, Details: re.Details}
+	for _, f := range re.Fields {
+		apiErr.Errors = append(apiErr.Errors, FieldError{Code: f.Code, Message: message(f), Field: f.Field, Details: f.Details})
+	}
+	return re.Status, apiErr
+}
+
+// writeError is the envelope counterpart of http.Error.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	writeRequestError(w, r, &requestError{Status: status, Code: code, Msg: msg})
+}
+
+// bodyError classifies a failure to decode a JSON request body.
+func bodyError(err error) *requestError {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &requestError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Code:    codeImageTooLarge,
+			Msg:     "request body too large",
+			Details: map[string]any{"limit_bytes": tooLarge.Limit},
+		}
+	}
+	re := &requestError{Status: http.StatusBadRequest, Code: codeInvalidJSON, Msg: "bad json: " + err.Error()}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		re.Field = typeErr.Field
+	}
+	return re
+}
+
+// imageDecodeError classifies an imaging.Decode failure.
+func imageDecodeError(err error, data []byte) *requestError {
+	var dimErr *imaging.DimensionError
+	if errors.As(err, &dimErr) {
+		return &requestError{
+			Status: http.StatusRequestEntityTooLarge,
+			Code:   codeImageTooLarge,
+			Field:  "image_base64",
+			Msg:    err.Error(),
+			Details: map[string]any{
+				"width": dimErr.Width, "height": dimErr.Height,
+				"max_width": dimErr.Limits.MaxWidth, "max_height": dimErr.Limits.MaxHeight, "max_pixels": dimErr.Limits.MaxPixels,
+			},
+		}
+	}
+	if errors.Is(err, image.ErrFormat) {
+		return &requestError{
+			Status:  http.StatusBadRequest,
+			Code:    codeUnsupportedFormat,
+			Field:   "image_base64",
+			Msg:     "unsupported image format; want PNG, JPEG or GIF",
+			Details: map[string]any{"detected": strings.TrimSuffix(http.DetectContentType(data), "; charset=utf-8")},
+		}
+	}
+	return &requestError{Status: http.StatusBadRequest, Code: codeCorruptImage, Field: "image_base64", Msg: "decode fail: " + err.Error()}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/scoring"
+)
+
+// An experiment trials scoring methods on a share of traffic before one
+// becomes the default. Each caller is assigned a variant by hashing the
+// experiment's name with their user ID or, failing that, the session ID
+// their client sends, so a player keeps one variant for the whole
+// experiment. Responses carry the assignment and /stats splits scores by
+// it (group_by=variant).
+
+// ExperimentConfig is the running experiment; an empty Name disables it.
+// Renaming the experiment reshuffles every assignment, while changing
+// weights moves only the callers at the boundaries.
+type ExperimentConfig struct {
+	Name     string              `json:"name" yaml:"name"`
+	Variants []ExperimentVariant `json:"variants" yaml:"variants"`
+}
+
+type ExperimentVariant struct {
+	Name string `json:"name" yaml:"name"`
+	// Weight is the variant's share of traffic relative to the others'.
+	Weight int `json:"weight" yaml:"weight"`
+	// Metric and Aggregation default to the configured ones, so a variant
+	// setting neither is the control.
+	Metric      string `json:"metric" yaml:"metric"`
+	Aggregation string `json:"aggregation" yaml:"aggregation"`
+}
+
+// maxSessionIDLen bounds X-Session-ID values; clients normally send a UUID.
+const maxSessionIDLen = 255
+
+// variant returns the variant subject is assigned to, or false if there is
+// no experiment or no subject.
+func (c ExperimentConfig) variant(subject string) (ExperimentVariant, bool) {
+	if c.Name == "" || subject == "" {
+		return ExperimentVariant{}, false
+	}
+	var total uint64
+	for _, v := range c.Variants {
+		total += uint64(v.Weight)
+	}
+	sum := sha256.Sum256([]byte(c.Name + "\x00" + subject))
+	n := binary.BigEndian.Uint64(sum[:8]) % total
+	for _, v := range c.Variants {
+		if n < uint64(v.Weight) {
+			return v, true
+		}
+		n -= uint64(v.Weight)
+	}
+	return ExperimentVariant{}, false
+}
+
+// experimentVariant assigns p to a variant of the running experiment, whose
+// method then replaces the one the scoring version pins. Requests choosing
+// their metric or aggregation keep what they asked for and are not
+// assigned, as are rescores and callers without a subject, such as rooms.
+func experimentVariant(p scoreParams) (ExperimentVariant, bool) {
+	if _, ok := scoring.LookupVersion(p.Version); !ok || p.Rescore || p.Metric != "" || p.Aggregation != "" {
+		return ExperimentVariant{}, false
+	}
+	return config().Experiment.variant(p.ExperimentSubject)
+}
+
+// validExperimentName reports whether s can name an experiment or
+// variant: 1 to 64 lowercase letters, digits, '-' and '_', so it needs no
+// escaping in stats files and query parameters.
+func validExperimentName(s string) bool {
+	if s == "" || len(s) > 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func (c ExperimentConfig) validate(bad func(field, format string, args ...any)) {
+	if c.Name == "" {
+		if len(c.Variants) > 0 {
+			bad("experiment.name", "must be set with experiment.variants")
+		}
+		return
+	}
+	if !validExperimentName(c.Name) {
+		bad("experiment.name", "%q must be 1 to 64 of a-z, 0-9, - and _", c.Name)
+	}
+	if len(c.Variants) < 2 {
+		bad("experiment.variants", "need at least two variants, got %d", len(c.Variants))
+	}
+	seen := map[string]bool{}
+	for i, v := range c.Variants {
+		field := fmt.Sprintf("experiment.variants[%d]", i)
+		if !validExperimentName(v.Name) {
+			bad(field+".name", "%q must be 1 to 64 of a-z, 0-9, - and _", v.Name)
+		} else if seen[v.Name] {
+			bad(field+".name", "duplicate variant %q", v.Name)
+		}
+		seen[v.Name] = true
+		if v.Weight < 1 {
+			bad(field+".weight", "must be at least 1, got %d", v.Weight)
+		}
+		if _, ok := scoring.LookupMetric(v.Metric); !ok {
+			bad(field+".metric", "unknown metric %q", v.Metric)
+		}
+		if _, ok := scoring.LookupAggregation(v.Aggregation); !ok {
+			bad(field+".aggregation", "unknown aggregation %q", v.Aggregation)
+		}
+	}
+}
+
+// parseExperimentVariants parses EXPERIMENT_VARIANTS: comma-separated
+// name[=metric[/aggregation]]:weight, e.g. "control:90,de=ciede2000:10".
+func parseExperimentVariants(s string) ([]ExperimentVariant, error) {
+	var out []ExperimentVariant
+	for _, item := range splitList(s) {
+		spec, weight, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("%q: want name[=metric[/aggregation]]:weight", item)
+		}
+		var v ExperimentVariant
+		var err error
+		if v.Weight, err = strconv.Atoi(weight); err != nil {
+			return nil, fmt.Errorf("%q: bad weight", item)
+		}
+		var method string
+		v.Name, method, _ = strings.Cut(spec, "=")
+		v.Metric, v.Aggregation, _ = strings.Cut(method, "/")
+		out = append(out, v)
+	}
+	return out, nil
+}
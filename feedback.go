@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+	"github.com/hiromuota166/iropico_color_calc/pkg/scoring"
+)
+
+// feedbackLanguages are the languages feedback is written in; the first is
+// the default.
+var feedbackLanguages = []string{"ja", "en"}
+
+// feedbackText holds each language's phrases. The hue_* sentences stand
+// alone; their "+" forms take an issue phrase, as does "+" by itself when
+// hue is not worth mentioning.
+var feedbackText = map[string]map[string]string{
+	"ja": {
+		"perfect":    "テーマの色にぴったりです！",
+		"good":       "テーマの色に近いです。",
+		"hue_close":  "色相は近いです。",
+		"hue_close+": "色相は近いですが、%s。",
+		"hue_off":    "色相が少しずれています。",
+		"hue_off+":   "色相が少しずれていて、%s。",
+		"hue_far":    "色相がテーマとかなり違います。",
+		"hue_far+":   "色相がテーマとかなり違い、%s。",
+		"+":          "%s。",
+		"dark":       "写真が暗すぎます",
+		"bright":     "写真が明るすぎます",
+		"dull":       "色がくすんでいます",
+		"vivid":      "色が鮮やかすぎます",
+		"scattered":  "テーマの色が写っている部分が少ないです",
+	},
+	"en": {
+		"perfect":    "A perfect match for the theme!",
+		"good":       "Close to the theme color.",
+		"hue_close":  "The hue is close.",
+		"hue_close+": "The hue is close, but %s.",
+		"hue_off":    "The hue is a little off.",
+		"hue_off+":   "The hue is a little off, and %s.",
+		"hue_far":    "The hue is far from the theme.",
+		"hue_far+":   "The hue is far from the theme, and %s.",
+		"+":          "%s.",
+		"dark":       "the photo is too dark",
+		"bright":     "the photo is too bright",
+		"dull":       "the colors are too dull",
+		"vivid":      "the colors are too vivid",
+		"scattered":  "too little of the photo is in the theme color",
+	},
+}
+
+// Thresholds in Oklch between the average color and the theme. Lightness
+// and chroma issues are ranked by how far past their threshold they are.
+const (
+	feedbackHueClose  = 20.0 // degrees
+	feedbackHueFar    = 60.0
+	feedbackLightness = 0.1
+	feedbackDull      = 0.05
+	feedbackVivid     = 0.06
+	// Below feedbackGray chroma a color's hue is not worth talking about.
+	feedbackGray = 0.04
+	// feedbackScattered is the coverage below which a close average is put
+	// down to a mix of colors.
+	feedbackScattered = 0.25
+	feedbackPerfect   = 90.0
+)
+
+// feedbackLang resolves a requested feedback language.
+func feedbackLang(lang string) (string, error) {
+	if lang == "" {
+		return feedbackLanguages[0], nil
+	}
+	if !slices.Contains(feedbackLanguages, lang) {
+		return "", &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "lang",
+			Msg: fmt.Sprintf("unknown lang %q", lang), Details: map[string]any{"allowed": feedbackLanguages}}
+	}
+	return lang, nil
+}
+
+// scoreFeedback describes, for players, the biggest difference between
+// res's average color and the theme: its hue, then whichever of lightness,
+// chroma or coverage is furthest off. Both are compared as seen under the
+// vision mode res was scored with.
+func scoreFeedback(res scoring.Result, tr, tg, tb uint8, vision, lang string) string {
+	text := feedbackText[lang]
+	tr, tg, tb = scoring.VisionTheme(vision, tr, tg, tb)
+	lin := colormath.SRGB8ToLinear
+	theme := colormath.OklabToOklch(colormath.LinearToOklab(lin(tr), lin(tg), lin(tb)))
+	avg := colormath.OklabToOklch(colormath.LinearToOklab(res.AvgR, res.AvgG, res.AvgB))
+	// The feedback ignores what the metric ignores: hue and mostly chroma
+	// under lightness scoring, lightness and maybe chroma under hue matching.
+	lightnessOnly := strings.HasPrefix(res.Method, scoring.Lightness.Label)
+	var noLightness, noChroma bool
+	for _, m := range scoring.Metrics {
+		if strings.HasPrefix(res.Method, m.Label+"(") || strings.HasPrefix(res.Method, m.Label+"-") {
+			noLightness, noChroma = m.NoLightness, m.NoChroma
+		}
+	}
+	gray := lightnessOnly || theme[1] < feedbackGray
+
+	var issue string
+	var worst float64
+	consider := func(name string, over float64) {
+		if over > 1 && over > worst {
+			issue, worst = name, over
+		}
+	}
+	dl, dc := avg[0]-theme[0], avg[1]-theme[1]
+	if !noLightness {
+		consider("dark", -dl/feedbackLightness)
+		consider("bright", dl/feedbackLightness)
+	}
+	if !lightnessOnly {
+		if !gray && (!noChroma || avg[1] < colormath.HueChroma) {
+			consider("dull", -dc/feedbackDull)
+		}
+		if !noChroma {
+			consider("vivid", dc/feedbackVivid)
+		}
+	}
+	if issue == "" && res.Coverage < feedbackScattered {
+		issue = "scattered"
+	}
+
+	hue := ""
+	if !gray && avg[1] >= feedbackGray {
+		switch dh := hueDistance(avg[2], theme[2]); {
+		case dh <= feedbackHueClose:
+			hue = "hue_close"
+		case dh <= feedbackHueFar:
+			hue = "hue_off"
+		default:
+			hue = "hue_far"
+		}
+	}
+
+	switch {
+	case issue == "" && res.Score >= feedbackPerfect && (hue == "" || hue == "hue_close"):
+		return text["perfect"]
+	case issue == "" && hue == "":
+		return text["good"]
+	case issue == "":
+		return text[hue]
+	case hue == "":
+		return upperFirst(fmt.Sprintf(text["+"], text[issue]))
+	}
+	return fmt.Sprintf(text[hue+"+"], text[issue])
+}
+
+// hueDistance is the angle between two hues in degrees, in [0, 180].
+func hueDistance(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	return math.Min(d, 360-d)
+}
+
+func upperFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+	iropicov1 "github.com/hiromuota166/iropico_color_calc/proto/iropico/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const maxBatchSize = 64
+
+type grpcScoringServer struct {
+	iropicov1.UnimplementedScoringServiceServer
+}
+
+func newGRPCServer(cfg *Config, auth *authenticator) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.MaxRecvMsgSize(int(cfg.Limits.MaxBodyBytes)),
+		grpc.ChainUnaryInterceptor(grpcRateLimitInterceptor(auth), grpcAuthInterceptor(auth), work.unaryInterceptor()),
+	)
+	iropicov1.RegisterScoringServiceServer(s, grpcScoringServer{})
+	return s
+}
+
+// grpcAuthorization is the "authorization" metadata of ctx's call.
+func grpcAuthorization(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// grpcClientIP is the client address of ctx's call, from its
+// x-forwarded-for metadata as clientIP reads the header, else its peer.
+func grpcClientIP(ctx context.Context) string {
+	var xff []string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		xff = md.Get("x-forwarded-for")
+	}
+	var remote string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remote = p.Addr.String()
+	}
+	return forwardedIP(xff, remote)
+}
+
+// grpcRateLimitInterceptor holds each call to the HTTP API's rate limits,
+// sharing their buckets; a batch is one call, as it is over HTTP.
+func grpcRateLimitInterceptor(auth *authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if ok, wait := rateLimit(auth, grpcAuthorization(ctx), grpcClientIP(ctx)); !ok {
+			return nil, grpcError(rateLimitError(wait))
+		}
+		return handler(ctx, req)
+	}
+}
+
+// grpcAuthInterceptor applies the HTTP API's credentials to the
+// "authorization" metadata key.
+func grpcAuthInterceptor(auth *authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		p, err := auth.authenticate(ctx, grpcAuthorization(ctx), false)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return handler(context.WithValue(ctx, principalKey, p), req)
+	}
+}
+
+// grpcError maps a requestError onto a gRPC status, carrying the API error
+// code as ErrorInfo.Reason, the problems validation found as BadRequest
+// field violations, and a 429's wait as RetryInfo.
+func grpcError(err error) error {
+	var re *requestError
+	if !errors.As(err, &re) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	code := codes.Internal
+	switch re.Status {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case statusClientClosedRequest:
+		code = codes.Canceled
+	}
+	if re.Code == codeScoreTimeout {
+		code = codes.DeadlineExceeded
+	}
+	info := &errdetails.ErrorInfo{Reason: re.Code, Domain: "iropico"}
+	if re.Field != "" {
+		info.Metadata = map[string]string{"field": re.Field}
+	}
+	details := []protoadapt.MessageV1{info}
+	if len(re.Fields) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, f := range re.Fields {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: f.Field, Description: f.Msg, Reason: f.Code})
+		}
+		details = append(details, br)
+	}
+	if wait, ok := re.Details["retry_after_seconds"].(float64); ok {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(time.Duration(wait) * time.Second)})
+	}
+	st, derr := status.New(code, re.Msg).WithDetails(details...)
+	if derr != nil {
+		return status.Error(code, re.Msg)
+	}
+	return st.Err()
+}
+
+// score runs one request; idemKey is empty in batches, whose requests
+// would otherwise share the RPC's key. The x-session-id and
+// accept-language metadata are the HTTP headers of those names.
+func (grpcScoringServer) score(ctx context.Context, req *iropicov1.ScoreRequest, idemKey string) (*iropicov1.ScoreResponse, error) {
+	received, captured := time.Now(), capturedAt(req.GetCapturedAtMs())
+	var sessionID, lang string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-session-id"); len(v) > 0 {
+			sessionID = v[0]
+		}
+		lang = acceptLanguage(strings.Join(md.Get("accept-language"), ","))
+	}
+	resp, _, err := scoreSubmission(ctx, scoreParams{
+		Image:         req.GetImage(),
+		Mask:          req.GetMask(),
+		ThemeHex:      req.GetThemeHex(),
+		Metric:        req.GetMetric(),
+		Aggregation:   req.GetAggregation(),
+		Normalization: req.GetNormalization(),
+		Background:    req.GetBackground(),
+		Grayscale:     req.GetGrayscale(),
+		Vision:        req.GetVision(),
+		Lang:          cmp.Or(req.GetLang(), lang),
+		AllMethods:    req.GetIncludeAllMethods(),
+		Seed:          req.GetSeed(),
+		UserID:        principalFrom(ctx).UserID,
+		Sandbox:       principalFrom(ctx).Sandbox,
+		Version:       req.GetScoringVersion(),
+		Quota:         quotaClientOf(principalFrom(ctx), grpcAuthorization(ctx), grpcClientIP(ctx)),
+		ReceivedAt:    received,
+		CapturedAt:    captured,
+
+		IdempotencyKey:    idemKey,
+		ExperimentSubject: cmp.Or(principalFrom(ctx).UserID, sessionID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	observeDone(received, captured)
+	return resp.proto(), nil
+}
+
+func (s grpcScoringServer) Score(ctx context.Context, req *iropicov1.ScoreRequest) (*iropicov1.ScoreResponse, error) {
+	var idemKey string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("idempotency-key"); len(v) > 0 {
+			idemKey = v[0]
+		}
+	}
+	resp, err := s.score(ctx, req, idemKey)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return resp, nil
+}
+
+func (s grpcScoringServer) BatchScore(ctx context.Context, req *iropicov1.BatchScoreRequest) (*iropicov1.BatchScoreResponse, error) {
+	if n := len(req.GetRequests()); n > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "batch of %d exceeds limit of %d", n, maxBatchSize)
+	}
+	out := &iropicov1.BatchScoreResponse{}
+	for _, r := range req.GetRequests() {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		res := &iropicov1.BatchScoreResult{}
+		if resp, err := s.score(ctx, r, ""); err != nil {
+			res.Error, res.ErrorCode = err.Error(), codeInternal
+			var re *requestError
+			if errors.As(err, &re) {
+				res.ErrorCode = re.Code
+			}
+		} else {
+			res.Response = resp
+		}
+		out.Results = append(out.Results, res)
+	}
+	return out, nil
+}
+
+func (grpcScoringServer) ExtractPalette(ctx context.Context, req *iropicov1.ExtractPaletteRequest) (*iropicov1.ExtractPaletteResponse, error) {
+	img, _, err := imaging.DecodeWithin(req.GetImage(), config().imageLimits())
+	if err != nil {
+		return nil, grpcError(imageDecodeError(err, req.GetImage()))
+	}
+	out := &iropicov1.ExtractPaletteResponse{}
+	for _, c := range palette(img, int(req.GetCount())) {
+		out.Colors = append(out.Colors, &iropicov1.PaletteColor{Hex: c.Hex, Proportion: c.Proportion})
+	}
+	return out, nil
+}
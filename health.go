@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+)
+
+// Orchestrators restart instances that fail /livez and stop routing to ones
+// that fail /readyz, so /livez only shows the process is serving while
+// /readyz shows it can score.
+
+// probePaths are polled by orchestrators and exempt from rate limiting.
+var probePaths = map[string]bool{"/healthz": true, "/livez": true, "/readyz": true}
+
+// selfTestImage and selfTestTheme are the embedded image /readyz scores,
+// and its color; the score must reach selfTestMin.
+const (
+	selfTestImage = "testsuite/solid-1e90ff.png"
+	selfTestTheme = 0x1e90ff
+	selfTestMin   = 99.0
+)
+
+// warmedUp is set once warmUp has run; until then /readyz fails.
+var warmedUp atomic.Bool
+
+// warmUp decodes the embedded test suite and scores it under every scorer,
+// filling the metrics' gamut caches, before the instance reports ready.
+func warmUp() {
+	t0 := time.Now()
+	rep, err := consistencyReport()
+	if err != nil {
+		log.Printf("warm-up: %v", err)
+		return
+	}
+	if len(rep.Failures) > 0 {
+		log.Printf("warm-up: %d test suite scores out of range; see /admin/consistency", len(rep.Failures))
+	}
+	warmedUp.Store(true)
+	log.Printf("warmed up in %v", time.Since(t0).Round(time.Millisecond))
+}
+
+type ReadyCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type ReadyResp struct {
+	Ready  bool         `json:"ready"`
+	Checks []ReadyCheck `json:"checks"`
+}
+
+func handleLivez(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+// handleReadyz runs every readiness check and answers 503 unless all pass.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	resp := readiness()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func readiness() ReadyResp {
+	resp := ReadyResp{Ready: true}
+	check := func(name string, err error) {
+		c := ReadyCheck{Name: name, OK: err == nil}
+		if err != nil {
+			c.Error = err.Error()
+			resp.Ready = false
+		}
+		resp.Checks = append(resp.Checks, c)
+	}
+	if warmedUp.Load() {
+		check("warm_up", nil)
+	} else {
+		check("warm_up", errors.New("not warmed up yet"))
+	}
+	check("self_test", selfTest())
+	cfg := config()
+	if archive != nil {
+		check("archive", cachedStoreProbe(archive.store))
+	}
+	if cfg.RetroDir != "" {
+		check("retro_dir", dirProbe(cfg.RetroDir))
+	}
+	if cfg.RoomDir != "" {
+		check("room_dir", dirProbe(cfg.RoomDir))
+	}
+	if cfg.StatsDir != "" {
+		check("stats_dir", dirProbe(cfg.StatsDir))
+	}
+	return resp
+}
+
+// selfTest decodes the embedded self-test image within the configured
+// limits and scores it against its own color with the default scorer.
+func selfTest() error {
+	data, err := testsuiteFS.ReadFile(selfTestImage)
+	if err != nil {
+		return err
+	}
+	img, _, err := imaging.DecodeWithin(data, config().imageLimits())
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	sc, opts, err := resolveScorer(scoreParams{})
+	if err != nil {
+		return err
+	}
+	res := sc.Score(img, selfTestTheme>>16, selfTestTheme>>8&0xff, selfTestTheme&0xff, opts)
+	if res.Score < selfTestMin {
+		return fmt.Errorf("%s scored %.1f against its own color, want at least %g", sc.Name, res.Score, selfTestMin)
+	}
+	return nil
+}
+
+// probeKey is this instance's own probe blob, so replicas sharing a store
+// never overwrite or delete each other's.
+var probeKey = "readyz-probe-" + rand.Text()
+
+// storeProbeTTL is how long a store probe's result is reused, so frequent
+// /readyz polls do not each cost three requests to the store.
+const storeProbeTTL = 5 * time.Second
+
+var lastStoreProbe struct {
+	mu  sync.Mutex
+	at  time.Time
+	err error
+}
+
+// cachedStoreProbe is storeProbe run at most once per storeProbeTTL.
+func cachedStoreProbe(s blobStore) error {
+	p := &lastStoreProbe
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.at.IsZero() || time.Since(p.at) >= storeProbeTTL {
+		p.err, p.at = storeProbe(s), time.Now()
+	}
+	return p.err
+}
+
+// storeProbe writes, reads back and deletes a small blob.
+func storeProbe(s blobStore) error {
+	want := []byte(time.Now().UTC().Format(time.RFC3339Nano))
+	if err := s.put(probeKey, want); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+	got, err := s.get(probeKey)
+	if err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	if string(got) != string(want) {
+		return errors.New("read back different bytes")
+	}
+	if err := s.delete(probeKey); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+// dirProbe checks that a store directory is still writable.
+func dirProbe(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+)
+
+const (
+	defaultHueBins = 12
+	maxHueBins     = 360
+	defaultLSBins  = 10
+	maxLSBins      = 100
+	// Samples whose sRGB chroma (max - min channel) is below
+	// achromaticChroma have no meaningful hue and are counted apart.
+	achromaticChroma = 0.04
+)
+
+type HistogramRequest struct {
+	ImageBase64 string `json:"image_base64" doc:"PNG, JPEG or GIF as standard or URL-safe base64; a data: URL prefix is allowed."`
+	HueBins     int    `json:"hue_bins,omitempty" doc:"Equal-width hue bins starting at 0°; defaults to 12, at most 360."`
+	Lightness   bool   `json:"lightness,omitempty" doc:"Also return an HSL lightness histogram."`
+	Saturation  bool   `json:"saturation,omitempty" doc:"Also return an HSL saturation histogram."`
+	Bins        int    `json:"bins,omitempty" doc:"Bins of the lightness and saturation histograms; defaults to 10, at most 100."`
+}
+
+type HistogramBin struct {
+	From  float64 `json:"from" doc:"Inclusive lower edge: degrees for hue, percent otherwise."`
+	To    float64 `json:"to" doc:"Exclusive upper edge, inclusive for the last bin."`
+	Share float64 `json:"share" doc:"Alpha-weighted share of all sampled pixels."`
+}
+
+type HistogramResp struct {
+	Hue        []HistogramBin `json:"hue" doc:"Chromatic pixels only; with achromatic, the shares sum to 1."`
+	Achromatic float64        `json:"achromatic" doc:"Share of near-gray pixels, which have no meaningful hue."`
+	Lightness  []HistogramBin `json:"lightness,omitempty"`
+	Saturation []HistogramBin `json:"saturation,omitempty"`
+}
+
+// histogram bins the sampled pixels of img by HSL hue and, when ls > 0, by
+// lightness and saturation.
+func histogram(img image.Image, hueBins, ls int) HistogramResp {
+	hue := make([]float64, hueBins)
+	light, sat := make([]float64, ls), make([]float64, ls)
+	var achromatic, total float64
+	bin := func(v float64, n int) int { return min(int(v*float64(n)), n-1) }
+	for _, s := range imaging.SampleLinearRGB(img, config().Scoring.MaxSamples, 0) {
+		if s.W == 0 {
+			continue
+		}
+		total += s.W
+		r, g, b := colormath.LinearToSRGB(s.R), colormath.LinearToSRGB(s.G), colormath.LinearToSRGB(s.B)
+		h, sv, l := colormath.SRGBToHSL(r, g, b)
+		if math.Max(r, math.Max(g, b))-math.Min(r, math.Min(g, b)) < achromaticChroma {
+			achromatic += s.W
+		} else {
+			hue[bin(h/360, hueBins)] += s.W
+		}
+		if ls > 0 {
+			light[bin(l, ls)] += s.W
+			sat[bin(sv, ls)] += s.W
+		}
+	}
+	share := func(w float64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return math.Round(w/total*1e4) / 1e4
+	}
+	bins := func(ws []float64, full float64) []HistogramBin {
+		out := make([]HistogramBin, len(ws))
+		for i, w := range ws {
+			out[i] = HistogramBin{
+				From:  math.Round(full*float64(i)/float64(len(ws))*100) / 100,
+				To:    math.Round(full*float64(i+1)/float64(len(ws))*100) / 100,
+				Share: share(w),
+			}
+		}
+		return out
+	}
+	resp := HistogramResp{Hue: bins(hue, 360), Achromatic: share(achromatic)}
+	if ls > 0 {
+		resp.Lightness, resp.Saturation = bins(light, 100), bins(sat, 100)
+	}
+	return resp
+}
+
+func handleHistogram(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config().Limits.MaxBodyBytes)
+	var req HistogramRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, r, bodyError(err))
+		return
+	}
+	if req.HueBins < 0 || req.HueBins > maxHueBins {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "hue_bins",
+			Msg: fmt.Sprintf("hue_bins must be between 1 and %d, or 0 for the default", maxHueBins)})
+		return
+	}
+	if req.Bins < 0 || req.Bins > maxLSBins {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "bins",
+			Msg: fmt.Sprintf("bins must be between 1 and %d, or 0 for the default", maxLSBins)})
+		return
+	}
+	img, err := decodeUploadedImage(req.ImageBase64)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	hueBins, ls := cmp.Or(req.HueBins, defaultHueBins), 0
+	if req.Lightness || req.Saturation {
+		ls = cmp.Or(req.Bins, defaultLSBins)
+	}
+	resp := histogram(img, hueBins, ls)
+	if !req.Lightness {
+		resp.Lightness = nil
+	}
+	if !req.Saturation {
+		resp.Saturation = nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
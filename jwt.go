@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	firebaseJWKSURL = "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
+	jwtLeeway       = time.Minute
+	jwksDefaultTTL  = time.Hour
+	// jwksMinRefresh bounds how often an unknown kid can force a refetch.
+	jwksMinRefresh = time.Minute
+	// jwksFetchTimeout bounds a fetch of the key set, which runs on its own
+	// rather than under the request that needed it.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	IssuedAt  int64           `json:"iat"`
+	NotBefore int64           `json:"nbf"`
+}
+
+// jwtVerifier checks RS256/ES256 tokens against a JWKS endpoint, e.g. the
+// one Firebase Auth publishes for ID tokens.
+type jwtVerifier struct {
+	jwksURL  string
+	issuer   string
+	audience string
+	client   *http.Client
+	fetches  flightGroup[struct{}]
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+	// fetched is when the last fetch finished, successful or not.
+	fetched time.Time
+	expires time.Time
+}
+
+// newJWTVerifier builds a verifier for a Firebase project, or for a generic
+// JWKS URL with optional issuer / audience checks. It returns nil when
+// neither is configured.
+func newJWTVerifier(jc JWTConfig) *jwtVerifier {
+	v := &jwtVerifier{client: &http.Client{}}
+	if p := jc.FirebaseProjectID; p != "" {
+		v.jwksURL = firebaseJWKSURL
+		v.issuer = "https://securetoken.google.com/" + p
+		v.audience = p
+	}
+	if jc.JWKSURL != "" {
+		v.jwksURL = jc.JWKSURL
+	}
+	if jc.Issuer != "" {
+		v.issuer = jc.Issuer
+	}
+	if jc.Audience != "" {
+		v.audience = jc.Audience
+	}
+	if v.jwksURL == "" {
+		return nil
+	}
+	return v
+}
+
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func (v *jwtVerifier) verify(ctx context.Context, token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	key, err := v.key(ctx, hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch hdr.Alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("key %q is not an RSA key", hdr.Kid)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return nil, errors.New("bad signature")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("key %q is not an EC key", hdr.Kid)
+		}
+		if len(sig) != 64 {
+			return nil, fmt.Errorf("ES256 signature is %d bytes, want 64", len(sig))
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, errors.New("bad signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", hdr.Alg)
+	}
+
+	var c jwtClaims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(jwtLeeway)) {
+		return nil, errors.New("token expired")
+	}
+	if c.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(c.NotBefore, 0)) {
+		return nil, errors.New("token not yet valid")
+	}
+	if c.IssuedAt != 0 && now.Add(jwtLeeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return nil, errors.New("token issued in the future")
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return nil, errors.New("wrong issuer")
+	}
+	if v.audience != "" && !c.hasAudience(v.audience) {
+		return nil, errors.New("wrong audience")
+	}
+	if c.Subject == "" {
+		return nil, errors.New("missing sub")
+	}
+	return &c, nil
+}
+
+func (c *jwtClaims) hasAudience(want string) bool {
+	var one string
+	if json.Unmarshal(c.Audience, &one) == nil {
+		return one == want
+	}
+	var many []string
+	if json.Unmarshal(c.Audience, &many) == nil {
+		for _, a := range many {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (v *jwtVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	now := time.Now()
+	k, ok := v.keys[kid]
+	stale := now.After(v.expires)
+	unknown := !ok && now.Sub(v.fetched) >= jwksMinRefresh
+	v.mu.Unlock()
+	if stale || unknown {
+		if err := v.refresh(ctx); err != nil {
+			if ok {
+				return k, nil
+			}
+			return nil, fmt.Errorf("jwks: %w", err)
+		}
+		v.mu.Lock()
+		k, ok = v.keys[kid]
+		v.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	return k, nil
+}
+
+// refresh fetches the key set, joining a fetch already in flight, and
+// waits for it as long as ctx allows. A caller giving up does not cancel
+// the fetch for the others.
+func (v *jwtVerifier) refresh(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err, _ := v.fetches.Do(v.jwksURL, func() (struct{}, error) { return struct{}{}, v.fetch() })
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// fetch downloads the key set and installs it.
+func (v *jwtVerifier) fetch() error {
+	start := time.Now()
+	keys, maxAge, err := v.download()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetched = time.Now()
+	if err != nil {
+		return err
+	}
+	v.keys, v.expires = keys, start.Add(maxAge)
+	return nil
+}
+
+// download fetches and parses the key set, returning it with its
+// Cache-Control lifetime.
+func (v *jwtVerifier) download() (map[string]crypto.PublicKey, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, 0, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jk := range set.Keys {
+		switch {
+		case jk.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(jk.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(jk.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[jk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case jk.Kty == "EC" && jk.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(jk.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(jk.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[jk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, 0, errors.New("no usable keys")
+	}
+	return keys, cacheMaxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+func cacheMaxAge(cc string) time.Duration {
+	for _, d := range strings.Split(cc, ",") {
+		d = strings.TrimSpace(d)
+		if s, ok := strings.CutPrefix(d, "max-age="); ok {
+			if n, err := strconv.Atoi(s); err == nil && n > 0 {
+				return time.Duration(n) * time.Second
+			}
+		}
+	}
+	return jwksDefaultTTL
+}
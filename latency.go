@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pipeline stages timed for each submission. network spans the client's
+// capture time to server receipt, so it includes upload time and any clock
+// skew between the device and the server.
+const (
+	stageNetwork  = "network"
+	stageRead     = "read"
+	stageDecode   = "decode"
+	stageScore    = "score"
+	stageServer   = "server"
+	stageEndToEnd = "end_to_end"
+	latencyWindow = 4096
+)
+
+var latencyStages = []string{stageNetwork, stageRead, stageDecode, stageScore, stageServer, stageEndToEnd}
+
+var latencies = newLatencyRecorder()
+
+// latencyRecorder keeps the latest latencyWindow durations of each stage.
+type latencyRecorder struct {
+	mu      sync.Mutex
+	rings   map[string]*latencyRing
+	skewed  int
+	started time.Time
+}
+
+type latencyRing struct {
+	ms   []float64
+	next int
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	l := &latencyRecorder{rings: map[string]*latencyRing{}, started: time.Now().UTC()}
+	for _, s := range latencyStages {
+		l.rings[s] = &latencyRing{}
+	}
+	return l
+}
+
+func (l *latencyRecorder) observe(stage string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := l.rings[stage]
+	ms := float64(d) / float64(time.Millisecond)
+	if len(r.ms) < latencyWindow {
+		r.ms = append(r.ms, ms)
+		return
+	}
+	r.ms[r.next] = ms
+	r.next = (r.next + 1) % latencyWindow
+}
+
+// observeSpan records to-from; a negative span means the client clock is
+// ahead and is only counted.
+func (l *latencyRecorder) observeSpan(stage string, from, to time.Time) {
+	if d := to.Sub(from); d >= 0 {
+		l.observe(stage, d)
+		return
+	}
+	l.mu.Lock()
+	l.skewed++
+	l.mu.Unlock()
+}
+
+type StageLatency struct {
+	Stage string  `json:"stage"`
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+type LatencyReport struct {
+	Since       time.Time      `json:"since"`
+	Window      int            `json:"window" doc:"Most recent samples kept per stage."`
+	Stages      []StageLatency `json:"stages"`
+	ClockSkewed int            `json:"clock_skewed" doc:"Submissions whose client capture time was after server receipt; left out of network."`
+}
+
+func (l *latencyRecorder) report() LatencyReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rep := LatencyReport{Since: l.started, Window: latencyWindow, ClockSkewed: l.skewed}
+	for _, s := range latencyStages {
+		ms := append([]float64(nil), l.rings[s].ms...)
+		sort.Float64s(ms)
+		st := StageLatency{Stage: s, Count: len(ms)}
+		if len(ms) > 0 {
+			st.P50, st.P90, st.P99 = quantile(ms, 0.5), quantile(ms, 0.9), quantile(ms, 0.99)
+			st.Max = quantile(ms, 1)
+		}
+		rep.Stages = append(rep.Stages, st)
+	}
+	return rep
+}
+
+// quantile reads q from sorted by nearest rank, rounded to 0.01 ms.
+func quantile(sorted []float64, q float64) float64 {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return math.Round(sorted[max(i, 0)]*100) / 100
+}
+
+// scoreTimings are the server-side stage durations of one submission, for
+// the Server-Timing response header.
+type scoreTimings struct {
+	Read, Decode, Score time.Duration
+	// Cached is set when the score came from scoreResults.
+	Cached bool
+}
+
+func (t *scoreTimings) header() string {
+	var parts []string
+	for _, s := range []struct {
+		name string
+		d    time.Duration
+	}{{stageRead, t.Read}, {stageDecode, t.Decode}, {stageScore, t.Score}} {
+		if s.d > 0 {
+			parts = append(parts, fmt.Sprintf("%s;dur=%.2f", s.name, float64(s.d)/float64(time.Millisecond)))
+		}
+	}
+	if t.Cached {
+		parts = append(parts, `cache;desc="hit"`)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// capturedAt converts a client's Unix-millisecond capture time; 0 means
+// unset.
+func capturedAt(ms int64) time.Time {
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// observeDone records the server and end-to-end stages of a finished
+// submission.
+func observeDone(received, captured time.Time) {
+	now := time.Now()
+	latencies.observe(stageServer, now.Sub(received))
+	// Skew was already counted for the network stage.
+	if d := now.Sub(captured); !captured.IsZero() && d >= 0 {
+		latencies.observe(stageEndToEnd, d)
+	}
+}
+
+// withReceivedAt stamps each request with its arrival time before any other
+// middleware runs.
+func withReceivedAt(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), receivedAtKey, time.Now())))
+	})
+}
+
+func receivedAt(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(receivedAtKey).(time.Time); ok {
+		return t
+	}
+	return time.Now()
+}
+
+func handleLatency(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(latencies.report())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache is a size-bounded cache whose entries also expire. The bounds
+// are passed per call so a config change applies without a restart.
+type lruCache[K comparable, V any] struct {
+	mu    sync.Mutex
+	order *list.List // front is most recently used
+	items map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	val   V
+	added time.Time
+}
+
+// get returns the value for key unless it is missing or older than ttl.
+func (c *lruCache[K, V]) get(key K, ttl time.Duration) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*lruEntry[K, V])
+	if time.Since(e.added) > ttl {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return e.val, true
+}
+
+// add stores val, evicting the least recently used entries beyond limit;
+// limit <= 0 stores nothing.
+func (c *lruCache[K, V]) add(key K, val V, limit int) {
+	if limit <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.items == nil {
+		c.order, c.items = list.New(), map[K]*list.Element{}
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value = &lruEntry[K, V]{key, val, time.Now()}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[K, V]{key, val, time.Now()})
+	for c.order.Len() > limit {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.items, el.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+// flush empties the cache and returns how many entries it held.
+func (c *lruCache[K, V]) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.items)
+	c.order, c.items = nil, nil
+	return n
+}
@@ -1,271 +1,196 @@
 package main
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/hex"
-	"encoding/json"
+	"context"
 	"errors"
-	"image"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
 	"log"
-	"math"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
-	"strings"
-	"fmt"
-)
-
-type ScoreRequest struct {
-	ImageBase64 string `json:"image_base64"`
-	ThemeHex    string `json:"theme_hex"`
-}
-
-type ScoreResponse struct {
-	Score       float64 `json:"score"`
-	AvgColorHex string  `json:"avg_color_hex"`
-	Method      string  `json:"method"`
-}
-
-type DebugReq struct {
-	ImageBase64 string `json:"image_base64"`
-}
+	"os/signal"
+	"syscall"
+	"time"
 
-type DebugResp struct {
-	DecodedLen int    `json:"decoded_len"`
-	First8Hex  string `json:"first8_hex"`
-	MimeGuess  string `json:"mime_guess"`
-	Note       string `json:"note"`
-}
+	"google.golang.org/grpc"
+)
 
 func main() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
-	mux.HandleFunc("/score", handleScore)
-	mux.HandleFunc("/debug", handleDebug)
-
-	handler := withCORS(mux)
-
-	port := os.Getenv("PORT")
-	if port == "" { port = "8080" }
-	log.Printf("listening on :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, handler))
-}
-
-func withCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-func handleScore(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
-
-	var req ScoreRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
-		return
+	if len(os.Args) > 1 && os.Args[1] == "score" {
+		os.Exit(runScore(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdmin(os.Args[2:]))
 	}
 
-	imgBytes, err := decodeBase64Image(req.ImageBase64)
+	cfg, err := loadConfig()
 	if err != nil {
-		http.Error(w, "bad image: "+err.Error(), http.StatusBadRequest)
-		return
+		log.Fatal(err)
 	}
+	currentConfig.Store(cfg)
+
+	work = newWorkLimiter(cfg.Limits)
 
-	img, _, err := image.Decode(bytes.NewReader(imgBytes))
+	keys, err := loadKeys(cfg.Auth.APIKeys, cfg.Auth.APIKeysFile)
 	if err != nil {
-		http.Error(w, "decode fail: "+err.Error(), http.StatusBadRequest)
-		return
+		log.Fatalf("load api keys: %v", err)
 	}
-
-	tr, tg, tb, err := parseHexColor(req.ThemeHex)
+	sandboxKeys, err := loadKeys(cfg.Auth.SandboxAPIKeys, cfg.Auth.SandboxAPIKeysFile)
 	if err != nil {
-		http.Error(w, "bad theme_hex: "+err.Error(), http.StatusBadRequest)
-		return
+		log.Fatalf("load sandbox api keys: %v", err)
 	}
-
-	lr, lg, lb := averageLinearRGB(img)
-
-	ltR := srgbToLinear(float64(tr) / 255.0)
-	ltG := srgbToLinear(float64(tg) / 255.0)
-	ltB := srgbToLinear(float64(tb) / 255.0)
-
-	dist := math.Sqrt((lr-ltR)*(lr-ltR) + (lg-ltG)*(lg-ltG) + (lb-ltB)*(lb-ltB))
-	maxDist := math.Sqrt(3.0)
-	score := 100.0 * (1.0 - dist/maxDist)
-	if score < 0 {
-		score = 0
+	adminKeys, err := loadKeys(cfg.Auth.AdminAPIKeys, cfg.Auth.AdminAPIKeysFile)
+	if err != nil {
+		log.Fatalf("load admin api keys: %v", err)
 	}
-	if score > 100 {
-		score = 100
+	auth := newAuthenticator(keys, sandboxKeys, adminKeys, newJWTVerifier(cfg.Auth.JWT))
+	if auth.open() {
+		log.Printf("no API keys or JWT verifier configured; authentication disabled")
 	}
 
-	sr := linearToSrgb(lr)
-	sg := linearToSrgb(lg)
-	sb := linearToSrgb(lb)
-	avgHex := "#" + to2Hex(sr) + to2Hex(sg) + to2Hex(sb)
-
-	resp := ScoreResponse{
-		Score:       math.Round(score*10) / 10,
-		AvgColorHex: avgHex,
-		Method:      "linear-srgb-euclidean(sampled)",
+	retros, err = newRetroStore(cfg.RetroDir)
+	if err != nil {
+		log.Fatalf("retrospective store: %v", err)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
-}
-
-func handleDebug(w http.ResponseWriter, r *http.Request) {
-  var req DebugReq
-  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-    http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest); return
-  }
-  s := strings.TrimSpace(req.ImageBase64)
-  if i := strings.Index(s, ","); i != -1 && strings.HasPrefix(strings.ToLower(s), "data:") {
-    s = s[i+1:]
-  }
-  b, err := base64.StdEncoding.DecodeString(s)
-  if err != nil {
-    http.Error(w, "bad base64: "+err.Error(), http.StatusBadRequest); return
-  }
-
-  first := b
-  if len(first) > 8 { first = first[:8] }
-  mime := http.DetectContentType(b)
-
-  var decOK bool
-  var width, height int
-  var decErr string
-  if img, _, err := image.Decode(bytes.NewReader(b)); err == nil {
-    decOK = true
-    bounds := img.Bounds()
-    width, height = bounds.Dx(), bounds.Dy()
-  } else {
-    decErr = err.Error()
-  }
-
-  json.NewEncoder(w).Encode(map[string]any{
-    "decoded_len": len(b),
-    "first8_hex":  hex.EncodeToString(first),
-    "mime_guess":  mime,
-    "decode_ok":   decOK,
-    "decode_err":  decErr,
-    "width":       width,
-    "height":      height,
-    "note":        "ブラウザの canvas.toDataURL('image/png') で作ったデータなら decode_ok=true になるはず",
-  })
-}
-
-func decodeBase64Image(s string) ([]byte, error) {
-  s = strings.TrimSpace(s)
-  if i := strings.Index(s, ","); i != -1 && strings.HasPrefix(strings.ToLower(s), "data:") {
-    s = s[i+1:]
-  }
-  s = strings.ReplaceAll(s, "\n", "")
-  s = strings.ReplaceAll(s, "\r", "")
-  s = strings.ReplaceAll(s, " ", "")
-
-  if b, err := base64.StdEncoding.DecodeString(s); err == nil {
-    return b, nil
-  } else {
-    s2 := strings.NewReplacer("-", "+", "_", "/").Replace(s)
-    if b2, err2 := base64.StdEncoding.DecodeString(s2); err2 == nil {
-      return b2, nil
-    }
-    if b3, err3 := base64.RawStdEncoding.DecodeString(s2); err3 == nil {
-      return b3, nil
-    }
-    return nil, fmt.Errorf("base64 decode failed")
-  }
-}
-
-
-func parseHexColor(h string) (r, g, b uint8, err error) {
-	if strings.HasPrefix(h, "#") {
-		h = h[1:]
+	sandboxRetros, err = newRetroStore(sandboxDir(cfg.RetroDir))
+	if err != nil {
+		log.Fatalf("sandbox retrospective store: %v", err)
 	}
-	if len(h) != 6 {
-		return 0, 0, 0, errors.New("want #RRGGBB")
+	rooms, err = newRoomStore(cfg.RoomDir)
+	if err != nil {
+		log.Fatalf("room store: %v", err)
 	}
-	ri, err := strconv.ParseUint(h[0:2], 16, 8)
+	sandboxRooms, err = newRoomStore(sandboxDir(cfg.RoomDir))
 	if err != nil {
-		return
+		log.Fatalf("sandbox room store: %v", err)
 	}
-	gi, err := strconv.ParseUint(h[2:4], 16, 8)
+	stats, err = newStatsStore(cfg.StatsDir)
 	if err != nil {
-		return
+		log.Fatalf("stats store: %v", err)
 	}
-	bi, err := strconv.ParseUint(h[4:6], 16, 8)
+	sandboxStats, err = newStatsStore(sandboxDir(cfg.StatsDir))
 	if err != nil {
-		return
+		log.Fatalf("sandbox stats store: %v", err)
 	}
-	return uint8(ri), uint8(gi), uint8(bi), nil
-}
-
-func averageLinearRGB(img image.Image) (float64, float64, float64) {
-	b := img.Bounds()
-	w, h := b.Dx(), b.Dy()
-	const maxSamples = 4096
-	step := int(math.Max(1, math.Sqrt(float64(w*h/maxSamples))))
-	var sumR, sumG, sumB, sumW float64
-
-	for y := b.Min.Y; y < b.Max.Y; y += step {
-		for x := b.Min.X; x < b.Max.X; x += step {
-			r16, g16, b16, a16 := img.At(x, y).RGBA()
-			sr := float64(r16) / 65535.0
-			sg := float64(g16) / 65535.0
-			sb := float64(b16) / 65535.0
-			wa := float64(a16) / 65535.0
-			lr := srgbToLinear(sr)
-			lg := srgbToLinear(sg)
-			lb := srgbToLinear(sb)
-			sumR += lr * wa
-			sumG += lg * wa
-			sumB += lb * wa
-			sumW += wa
+	if quotas, err = newQuotaCounter(cfg.Quota.RedisURL); err != nil {
+		log.Fatalf("quota: %v", err)
+	}
+	if rq, ok := quotas.(redisQuota); ok {
+		if err := rq.c.ping(); err != nil {
+			log.Printf("quota: redis unreachable, letting submissions through until it is: %v", err)
 		}
 	}
-	if sumW == 0 {
-		return 0, 0, 0
+	var store blobStore
+	switch {
+	case cfg.Archive.Bucket != "":
+		s3, err := newS3Store(cfg.Archive)
+		if err != nil {
+			log.Fatalf("archive: %v", err)
+		}
+		store = s3
+		if cfg.Archive.Prefix != "" {
+			store = prefixStore{s3, cfg.Archive.Prefix}
+		}
+	case cfg.ArchiveDir != "":
+		if store, err = newDiskStore(cfg.ArchiveDir); err != nil {
+			log.Fatalf("archive: %v", err)
+		}
 	}
-	return sumR / sumW, sumG / sumW, sumB / sumW
-}
-
-func srgbToLinear(c float64) float64 {
-	if c <= 0.04045 {
-		return c / 12.92
+	if store != nil {
+		archive = newImageArchive(store)
+		sandboxArchive = newImageArchive(prefixStore{store, sandboxNamespace})
 	}
-	return math.Pow((c+0.055)/1.055, 2.4)
-}
 
-func linearToSrgb(c float64) float64 {
-	if c <= 0.0031308 {
-		return 12.92 * c
-	}
-	return 1.055*math.Pow(c, 1.0/2.4) - 0.055
-}
+	go warmUp()
 
-func to2Hex(c float64) string {
-	v := int(math.Round(c * 255))
-	if v < 0 {
-		v = 0
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           serverHandler(apiRoutes(), auth, cfg.CORS.AllowedOrigins),
+		ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout),
 	}
-	if v > 255 {
-		v = 255
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		log.Printf("listening on :%s", cfg.Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	var pprofSrv *http.Server
+	if cfg.Debug.PprofAddr != "" {
+		pprofSrv = newPprofServer(cfg.Debug.PprofAddr)
+		go func() {
+			log.Printf("pprof listening on %s", cfg.Debug.PprofAddr)
+			if err := pprofSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	var grpcSrv *grpc.Server
+	if cfg.GRPC.Port != "" {
+		lis, err := net.Listen("tcp", ":"+cfg.GRPC.Port)
+		if err != nil {
+			log.Fatalf("grpc listen: %v", err)
+		}
+		grpcSrv = newGRPCServer(cfg, auth)
+		go func() {
+			log.Printf("grpc listening on :%s", cfg.GRPC.Port)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if changed, err := reloadConfig(); err != nil {
+				log.Printf("config reload: %v", err)
+			} else {
+				log.Printf("config reloaded on SIGHUP; changed: %v", changed)
+			}
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("shutting down; draining in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+	grpcDone := make(chan struct{})
+	if grpcSrv != nil {
+		go func() {
+			<-shutdownCtx.Done()
+			grpcSrv.Stop()
+		}()
+		go func() {
+			grpcSrv.GracefulStop()
+			close(grpcDone)
+		}()
+	} else {
+		close(grpcDone)
+	}
+	if pprofSrv != nil {
+		pprofSrv.Close()
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	// Once nothing can record more, write out the open rounds and the
+	// images still being archived.
+	<-grpcDone
+	for _, a := range []*imageArchive{archive, sandboxArchive} {
+		if a != nil {
+			a.wait()
+		}
 	}
-	s := strconv.FormatInt(int64(v), 16)
-	if len(s) == 1 {
-		s = "0" + s
+	for _, s := range []*retroStore{retros, sandboxRetros} {
+		if err := s.flush(); err != nil {
+			log.Printf("retrospective: %v", err)
+		}
 	}
-	return strings.ToLower(s)
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"math"
+	"net/http"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+type MatchRequest struct {
+	ImageABase64   string `json:"image_a_base64" doc:"First player's image, encoded as ScoreRequest.image_base64."`
+	ImageBBase64   string `json:"image_b_base64" doc:"Second player's image."`
+	ThemeHex       string `json:"theme_hex,omitempty" doc:"Theme color, as in ScoreRequest; defaults to the active theme."`
+	Metric         string `json:"metric,omitempty" enum:"@metrics"`
+	Aggregation    string `json:"aggregation,omitempty" enum:"@aggregations"`
+	Normalization  string `json:"normalization,omitempty" enum:"gamut,global"`
+	Background     string `json:"background,omitempty" enum:"@backgrounds"`
+	Grayscale      string `json:"grayscale,omitempty" enum:"@grayscaleModes"`
+	Vision         string `json:"vision,omitempty" enum:"@visionModes" doc:"As in ScoreRequest."`
+	Lang           string `json:"lang,omitempty" enum:"@languages" doc:"As in ScoreRequest."`
+	ScoringVersion string `json:"scoring_version,omitempty" doc:"Scoring semantics, as in the /v1 and /v2 score routes; defaults to v1."`
+	Seed           uint32 `json:"seed,omitempty" doc:"As in ScoreRequest; both images are sampled with it."`
+}
+
+type MatchResponse struct {
+	A      ScoreResponse `json:"a"`
+	B      ScoreResponse `json:"b"`
+	Winner string        `json:"winner" enum:"a,b,tie" doc:"Side with the higher rounded score."`
+	Margin float64       `json:"margin" doc:"Difference between the rounded scores."`
+}
+
+// handleMatch scores two images against one theme with a single resolved
+// scorer, so both sides are judged by the same method and options even if
+// the configuration changes mid-request. Matches are not recorded as
+// submissions.
+func handleMatch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config().Limits.MaxBodyBytes)
+	var req MatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, r, bodyError(err))
+		return
+	}
+	req.Lang = cmp.Or(req.Lang, requestLang(r))
+	resp, err := match(r.Context(), req)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// match validates req, decoding both images, before scoring either. Scoring
+// stops when ctx is done or limits.score_timeout passes.
+func match(ctx context.Context, req MatchRequest) (MatchResponse, error) {
+	var errs fieldErrors
+	imgA, err := decodeUploadedImage(req.ImageABase64)
+	errs.add(withField(err, "image_a_base64"))
+	imgB, err := decodeUploadedImage(req.ImageBBase64)
+	errs.add(withField(err, "image_b_base64"))
+	if req.ThemeHex == "" {
+		req.ThemeHex = activeTheme.get().ThemeHex
+	}
+	tr, tg, tb, _, err := colormath.ParseColor(req.ThemeHex)
+	if err != nil {
+		errs.add(themeHexError(err))
+	}
+	sc, opts, err := resolveScorer(scoreParams{
+		Metric:        req.Metric,
+		Aggregation:   req.Aggregation,
+		Normalization: req.Normalization,
+		Background:    req.Background,
+		Grayscale:     req.Grayscale,
+		Vision:        req.Vision,
+		Version:       req.ScoringVersion,
+		Seed:          req.Seed,
+	})
+	errs.add(err)
+	lang, err := feedbackLang(req.Lang)
+	errs.add(err)
+	if err := errs.err(); err != nil {
+		return MatchResponse{}, err
+	}
+
+	ctx, cancel := scoreContext(ctx)
+	defer cancel()
+	side := func(field string, img image.Image) (ScoreResponse, error) {
+		resp, res, err := scoreImage(ctx, sc, img, nil, tr, tg, tb, opts)
+		if err != nil {
+			return ScoreResponse{}, withField(err, field)
+		}
+		resp.Feedback = scoreFeedback(res, tr, tg, tb, opts.Vision, lang)
+		return resp, nil
+	}
+	var out MatchResponse
+	if out.A, err = side("image_a_base64", imgA); err != nil {
+		return MatchResponse{}, err
+	}
+	if out.B, err = side("image_b_base64", imgB); err != nil {
+		return MatchResponse{}, err
+	}
+	switch {
+	case out.A.Score > out.B.Score:
+		out.Winner = "a"
+	case out.B.Score > out.A.Score:
+		out.Winner = "b"
+	default:
+		out.Winner = "tie"
+	}
+	out.Margin = math.Round(math.Abs(out.A.Score-out.B.Score)*10) / 10
+	return out, nil
+}
+
+// withField points a client error about an image at field.
+func withField(err error, field string) error {
+	var re *requestError
+	if errors.As(err, &re) && re.Field != "" {
+		re.Field = field
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errorText is the message catalog for API errors, by language and code.
+// English messages are written where each error is raised and say more
+// than a catalog entry could, so English has none. As in feedbackText, a
+// "+" form takes the name of the field at fault and is used when there is
+// one.
+var errorText = map[string]map[string]string{
+	"ja": {
+		codeInvalidJSON:            "リクエストの形式が正しくありません。",
+		codeInvalidJSON + "+":      "リクエストの %s の形式が正しくありません。",
+		codeInvalidBase64:          "画像データを読み取れませんでした。",
+		codeImageTooLarge:          "画像が大きすぎます。",
+		codeUnsupportedFormat:      "対応していない画像形式です。PNG、JPEG、GIF のいずれかを送ってください。",
+		codeCorruptImage:           "画像が壊れているため読み込めませんでした。",
+		codeMostlyTransparent:      "画像のほとんどが透明です。",
+		codeInvalidThemeHex:        "テーマの色の指定が正しくありません。#RRGGBB のように指定してください。",
+		codeUnknownMetric:          "指定された色差の計算方法はありません。",
+		codeUnknownAggregation:     "指定された集計方法はありません。",
+		codeUnknownNormalization:   "指定された正規化の方法はありません。",
+		codeUnknownVersion:         "指定された API のバージョンはありません。",
+		codeInvalidParameter:       "パラメータの値が正しくありません。",
+		codeInvalidParameter + "+": "%s の値が正しくありません。",
+		codeUnauthorized:           "認証に失敗しました。",
+		codeAdminDisabled:          "管理 API は無効になっています。",
+		codeForbidden:              "この操作は許可されていません。",
+		codeRateLimited:            "リクエストが多すぎます。しばらく待ってからもう一度お試しください。",
+		codeQuotaExceeded:          "今日の投稿数の上限に達しました。明日もう一度お試しください。",
+		codeOverloaded:             "サーバーが混み合っています。しばらく待ってからもう一度お試しください。",
+		codeScoreTimeout:           "採点に時間がかかりすぎたため中止しました。小さい画像でもう一度お試しください。",
+		codeCanceled:               "リクエストは取り消されました。",
+		codeNotFound:               "見つかりませんでした。",
+		codeMethodNotAllowed:       "このメソッドには対応していません。",
+		codeArchiveDisabled:        "画像の保存は無効になっています。",
+		codeNoSubmissions:          "まだ投稿がありません。",
+		codeIdempotencyKeyReused:   "この Idempotency-Key は別の投稿ですでに使われています。",
+		codeRoomClosed:             "このルームは締め切られました。",
+		codeRoomFull:               "このルームは満員です。",
+		codeEmptyMask:              "マスクで選ばれた部分がありません。",
+		codeInvalidConfig:          "設定が正しくありません。",
+		codeRestartRequired:        "この設定の変更には再起動が必要です。",
+		codeInternal:               "サーバーでエラーが発生しました。",
+	},
+}
+
+// localizedMessage returns re's message in lang, falling back to re.Msg.
+func localizedMessage(re *requestError, lang string) string {
+	text := errorText[lang]
+	if t, ok := text[re.Code+"+"]; ok && re.Field != "" {
+		return fmt.Sprintf(t, re.Field)
+	}
+	if t, ok := text[re.Code]; ok {
+		return t
+	}
+	return re.Msg
+}
+
+// requestLang returns the supported language r's Accept-Language header
+// prefers, or "" if it names none.
+func requestLang(r *http.Request) string {
+	return acceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// acceptLanguage picks the feedbackLanguages entry an Accept-Language
+// value prefers, matching primary subtags, so "ja-JP" is ja; it returns ""
+// if none is acceptable.
+func acceptLanguage(header string) string {
+	type pref struct {
+		lang string
+		q    float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if q > 0 && slices.Contains(feedbackLanguages, primary) {
+			prefs = append(prefs, pref{primary, q})
+		}
+	}
+	if len(prefs) == 0 {
+		return ""
+	}
+	// Ties keep the header's order.
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	return prefs[0].lang
+}
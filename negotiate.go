@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	iropicov1 "github.com/hiromuota166/iropico_color_calc/proto/iropico/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Response media types for /score. Protobuf responses use the
+// iropico.v1.ScoreResponse message from proto/.
+const (
+	mediaJSON     = "application/json"
+	mediaProtobuf = "application/x-protobuf"
+	mediaMsgpack  = "application/msgpack"
+	// mediaEventStream streams progress before the JSON result; see
+	// streamScore.
+	mediaEventStream = "text/event-stream"
+)
+
+// negotiateMedia picks the response encoding from an Accept header. JSON is
+// preferred on ties and whenever nothing else is acceptable.
+func negotiateMedia(accept string) string {
+	best, bestQ := mediaJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var m string
+		switch mt {
+		case mediaJSON, "*/*", "application/*":
+			m = mediaJSON
+		case mediaProtobuf, "application/protobuf", "application/vnd.google.protobuf":
+			m = mediaProtobuf
+		case mediaMsgpack, "application/x-msgpack", "application/vnd.msgpack":
+			m = mediaMsgpack
+		case mediaEventStream:
+			m = mediaEventStream
+		default:
+			continue
+		}
+		if q > bestQ || (q == bestQ && m == mediaJSON) {
+			best, bestQ = m, q
+		}
+	}
+	return best
+}
+
+func writeScoreResponse(w http.ResponseWriter, r *http.Request, resp ScoreResponse) {
+	w.Header().Add("Vary", "Accept")
+	switch media := negotiateMedia(r.Header.Get("Accept")); media {
+	case mediaProtobuf:
+		b, err := proto.Marshal(resp.proto())
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", media)
+		w.Write(b)
+	case mediaMsgpack:
+		w.Header().Set("Content-Type", media)
+		w.Write(resp.appendMsgpack(nil))
+	default:
+		w.Header().Set("Content-Type", mediaJSON)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func (r ScoreResponse) proto() *iropicov1.ScoreResponse {
+	clusters := make([]*iropicov1.ColorCluster, len(r.ClosestClusters))
+	for i, c := range r.ClosestClusters {
+		clusters[i] = &iropicov1.ColorCluster{Hex: c.Hex, Distance: c.Distance, Share: c.Share}
+	}
+	var all []*iropicov1.MethodScore
+	for _, m := range r.AllMethods {
+		all = append(all, &iropicov1.MethodScore{Method: m.Method, Score: m.Score})
+	}
+	return &iropicov1.ScoreResponse{
+		Score:           r.Score,
+		AvgColorHex:     r.AvgColorHex,
+		Method:          r.Method,
+		Feedback:        r.Feedback,
+		UserId:          r.UserID,
+		Sandbox:         r.Sandbox,
+		AvgColorHsl:     &iropicov1.HSL{H: r.AvgColorHSL.H, S: r.AvgColorHSL.S, L: r.AvgColorHSL.L},
+		AvgColorLab:     &iropicov1.Lab{L: r.AvgColorLab.L, A: r.AvgColorLab.A, B: r.AvgColorLab.B},
+		AvgColorOklch:   &iropicov1.OKLCH{L: r.AvgColorOKLCH.L, C: r.AvgColorOKLCH.C, H: r.AvgColorOKLCH.H},
+		AvgColorNames:   &iropicov1.ColorNames{Css: r.AvgColorNames.CSS.proto(), Japanese: r.AvgColorNames.Japanese.proto()},
+		ClosestClusters: clusters,
+		AllMethods:      all,
+		ImageId:         r.ImageID,
+		ImageUrl:        r.ImageURL,
+		Experiment:      r.Experiment,
+		Variant:         r.Variant,
+	}
+}
+
+func (c NamedColor) proto() *iropicov1.NamedColor {
+	return &iropicov1.NamedColor{Name: c.Name, Hex: c.Hex, Distance: c.Distance}
+}
+
+// appendMsgpack encodes r as a msgpack map with the same keys as the JSON
+// form.
+func (r ScoreResponse) appendMsgpack(b []byte) []byte {
+	n := 9
+	if r.UserID != "" {
+		n++
+	}
+	if r.Sandbox {
+		n++
+	}
+	if r.AllMethods != nil {
+		n++
+	}
+	if r.ImageID != "" {
+		n++
+	}
+	if r.ImageURL != "" {
+		n++
+	}
+	if r.Experiment != "" {
+		n += 2
+	}
+	b = appendMsgpackMapLen(b, n)
+	b = appendMsgpackString(b, "score")
+	b = appendMsgpackFloat(b, r.Score)
+	b = appendMsgpackString(b, "avg_color_hex")
+	b = appendMsgpackString(b, r.AvgColorHex)
+	b = appendMsgpackFloats(b, "avg_color_hsl", "h", r.AvgColorHSL.H, "s", r.AvgColorHSL.S, "l", r.AvgColorHSL.L)
+	b = appendMsgpackFloats(b, "avg_color_lab", "l", r.AvgColorLab.L, "a", r.AvgColorLab.A, "b", r.AvgColorLab.B)
+	b = appendMsgpackFloats(b, "avg_color_oklch", "l", r.AvgColorOKLCH.L, "c", r.AvgColorOKLCH.C, "h", r.AvgColorOKLCH.H)
+	b = appendMsgpackString(b, "avg_color_names")
+	b = append(b, 0x80|2) // fixmap
+	for _, kc := range []struct {
+		k string
+		c NamedColor
+	}{{"css", r.AvgColorNames.CSS}, {"japanese", r.AvgColorNames.Japanese}} {
+		b = append(appendMsgpackString(b, kc.k), 0x80|3) // fixmap
+		b = appendMsgpackString(appendMsgpackString(b, "name"), kc.c.Name)
+		b = appendMsgpackString(appendMsgpackString(b, "hex"), kc.c.Hex)
+		b = appendMsgpackFloat(appendMsgpackString(b, "distance"), kc.c.Distance)
+	}
+	b = appendMsgpackString(b, "method")
+	b = appendMsgpackString(b, r.Method)
+	b = appendMsgpackString(b, "feedback")
+	b = appendMsgpackString(b, r.Feedback)
+	b = appendMsgpackString(b, "closest_clusters")
+	b = append(b, 0x90|byte(len(r.ClosestClusters))) // fixarray
+	for _, c := range r.ClosestClusters {
+		b = append(b, 0x80|3) // fixmap
+		b = appendMsgpackString(appendMsgpackString(b, "hex"), c.Hex)
+		b = appendMsgpackFloat(appendMsgpackString(b, "distance"), c.Distance)
+		b = appendMsgpackFloat(appendMsgpackString(b, "share"), c.Share)
+	}
+	if r.AllMethods != nil {
+		b = appendMsgpackString(b, "all_methods")
+		b = appendMsgpackArrayLen(b, len(r.AllMethods))
+		for _, m := range r.AllMethods {
+			b = append(b, 0x80|2) // fixmap
+			b = appendMsgpackString(appendMsgpackString(b, "method"), m.Method)
+			b = appendMsgpackFloat(appendMsgpackString(b, "score"), m.Score)
+		}
+	}
+	if r.ImageID != "" {
+		b = appendMsgpackString(b, "image_id")
+		b = appendMsgpackString(b, r.ImageID)
+	}
+	if r.ImageURL != "" {
+		b = appendMsgpackString(b, "image_url")
+		b = appendMsgpackString(b, r.ImageURL)
+	}
+	if r.Experiment != "" {
+		b = appendMsgpackString(b, "experiment")
+		b = appendMsgpackString(b, r.Experiment)
+		b = appendMsgpackString(b, "variant")
+		b = appendMsgpackString(b, r.Variant)
+	}
+	if r.UserID != "" {
+		b = appendMsgpackString(b, "user_id")
+		b = appendMsgpackString(b, r.UserID)
+	}
+	if r.Sandbox {
+		b = appendMsgpackString(b, "sandbox")
+		b = append(b, 0xc3) // true
+	}
+	return b
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackMapLen(b []byte, n int) []byte {
+	if n < 16 {
+		return append(b, 0x80|byte(n)) // fixmap
+	}
+	return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n)) // map 16
+}
+
+func appendMsgpackArrayLen(b []byte, n int) []byte {
+	if n < 16 {
+		return append(b, 0x90|byte(n)) // fixarray
+	}
+	return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n)) // array 16
+}
+
+func appendMsgpackFloat(b []byte, v float64) []byte {
+	b = append(b, 0xcb) // float64
+	return binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+// appendMsgpackFloats appends key mapped to a three-entry map of floats.
+func appendMsgpackFloats(b []byte, key, k1 string, v1 float64, k2 string, v2 float64, k3 string, v3 float64) []byte {
+	b = appendMsgpackString(b, key)
+	b = append(b, 0x80|3) // fixmap
+	for _, kv := range []struct {
+		k string
+		v float64
+	}{{k1, v1}, {k2, v2}, {k3, v3}} {
+		b = appendMsgpackFloat(appendMsgpackString(b, kv.k), kv.v)
+	}
+	return b
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/scoring"
+)
+
+// apiRoute is one documented endpoint. main registers the handlers from
+// apiRoutes and /openapi.json is generated from the same table, so the two
+// cannot drift.
+//
+// Request and Response are zero values of the JSON body types; their schemas
+// come from the json tags plus optional `doc:"..."` (description) and
+// `enum:"..."` (comma-separated values, or @name for a set in apiEnums)
+// tags. A nil Response with Produces set documents a binary body; Consumes
+// likewise documents binary request bodies accepted besides Request.
+type apiRoute struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+	Summary string
+	// Params describes the {name} path wildcards, Query the query
+	// parameters and Headers the request headers.
+	Params   map[string]string
+	Query    map[string]string
+	Headers  map[string]string
+	Request  any
+	Response any
+	// Status is the success status; 0 means 200.
+	Status int
+	// Produces lists response media types besides application/json.
+	Produces   []string
+	Consumes   []string
+	Public     bool
+	Deprecated bool
+	// Heavy routes decode images; they share the workLimiter's slots.
+	Heavy bool
+}
+
+func apiRoutes() []apiRoute {
+	themeParam := map[string]string{"hex": "Theme color as RRGGBB, without #."}
+	imageParam := map[string]string{"id": "Lowercase hex SHA-256 of the image bytes."}
+	roomParam := map[string]string{"id": "Room ID, as returned on creation."}
+	return []apiRoute{
+		{
+			Method: "GET", Path: "/livez", Handler: handleLivez, Public: true,
+			Summary: "Liveness probe: the process is serving requests.",
+		},
+		{
+			Method: "GET", Path: "/readyz", Handler: handleReadyz, Public: true,
+			Summary:  "Readiness probe: warmed up, storage reachable and an embedded image scores correctly; 503 otherwise.",
+			Response: ReadyResp{},
+		},
+		{
+			Method: "GET", Path: "/healthz", Handler: handleLivez, Public: true, Deprecated: true,
+			Summary: "Liveness probe; use /livez.",
+		},
+		{
+			Method: "POST", Path: "/v1/score", Handler: scoreHandler("v1"), Heavy: true,
+			Summary: "Score how closely an image's average color matches a theme, by default in linear sRGB with global normalization, as originally.",
+			Request: ScoreRequest{}, Response: ScoreResponse{},
+			Produces: []string{mediaProtobuf, mediaMsgpack, mediaEventStream},
+			Consumes: rawImageTypes, Query: rawScoreQuery, Headers: scoreHeaders,
+		},
+		{
+			Method: "POST", Path: "/v2/score", Handler: scoreHandler("v2"), Heavy: true,
+			Summary: "Score an image against a theme, by default with the perceptual CIEDE2000 metric.",
+			Request: ScoreRequest{}, Response: ScoreResponse{},
+			Produces: []string{mediaProtobuf, mediaMsgpack, mediaEventStream},
+			Consumes: rawImageTypes, Query: rawScoreQuery, Headers: scoreHeaders,
+		},
+		{
+			Method: "POST", Path: "/v1/score/batch", Handler: batchScoreHandler("v1"), Heavy: true,
+			Summary: "Score up to 64 images in turn as /v1/score does; with Accept: text/event-stream, stream each one's progress and result.",
+			Request: BatchScoreRequest{}, Response: BatchScoreResponse{},
+			Produces: []string{mediaEventStream}, Headers: batchScoreHeaders,
+		},
+		{
+			Method: "POST", Path: "/v2/score/batch", Handler: batchScoreHandler("v2"), Heavy: true,
+			Summary: "Score up to 64 images in turn as /v2/score does.",
+			Request: BatchScoreRequest{}, Response: BatchScoreResponse{},
+			Produces: []string{mediaEventStream}, Headers: batchScoreHeaders,
+		},
+		{
+			Method: "POST", Path: "/score", Handler: scoreHandler("v1"), Deprecated: true, Heavy: true,
+			Summary: "Alias of /v1/score.",
+			Request: ScoreRequest{}, Response: ScoreResponse{},
+			Produces: []string{mediaProtobuf, mediaMsgpack, mediaEventStream},
+			Consumes: rawImageTypes, Query: rawScoreQuery, Headers: scoreHeaders,
+		},
+		{
+			Method: "POST", Path: "/match", Handler: handleMatch, Heavy: true,
+			Summary: "Score two images against one theme with the same method, for versus play.",
+			Request: MatchRequest{}, Response: MatchResponse{},
+		},
+		{
+			Method: "POST", Path: "/debug", Handler: handleDebug, Heavy: true,
+			Summary: "Inspect how an uploaded image decodes.",
+			Request: DebugReq{}, Response: DebugResp{},
+		},
+		{
+			Method: "POST", Path: "/palette", Handler: handlePalette, Heavy: true,
+			Summary: "Extract the dominant colors of an image by median cut, independent of any theme.",
+			Request: PaletteRequest{}, Response: PaletteResp{},
+		},
+		{
+			Method: "POST", Path: "/thumbnail", Handler: handleThumbnail, Heavy: true,
+			Summary: "Resize an image for display, turned upright per its EXIF orientation.",
+			Request: ThumbnailRequest{}, Consumes: rawImageTypes, Query: rawThumbnailQuery,
+			Produces: []string{"image/jpeg", "image/png"},
+		},
+		{
+			Method: "POST", Path: "/histogram", Handler: handleHistogram, Heavy: true,
+			Summary: "Bin an image's pixels by hue, and optionally by lightness and saturation.",
+			Request: HistogramRequest{}, Response: HistogramResp{},
+		},
+		{
+			Method: "GET", Path: "/themes/{hex}/retrospective", Handler: handleGetRetrospective,
+			Summary: "Statistics for the open round and past rounds of a theme.",
+			Params:  themeParam, Response: RetrospectiveResp{},
+		},
+		{
+			Method: "GET", Path: "/stats", Handler: handleStats,
+			Summary:  "Count, mean, median and percentiles of recorded scores, per theme, UTC day, method and experiment variant.",
+			Query:    statsQuery,
+			Response: StatsResp{},
+		},
+		{
+			Method: "POST", Path: "/rooms", Handler: handleCreateRoom,
+			Summary: "Open a game room with a theme, a fixed scoring method and a deadline.",
+			Request: CreateRoomRequest{}, Response: Room{}, Status: http.StatusCreated,
+		},
+		{
+			Method: "GET", Path: "/rooms/{id}", Handler: handleGetRoom,
+			Summary: "A room and its current rankings, final once it is closed.",
+			Params:  roomParam, Response: Room{},
+		},
+		{
+			Method: "POST", Path: "/rooms/{id}/submissions", Handler: handleRoomSubmission, Heavy: true,
+			Summary: "Score an image in a room, keeping each player's best score.",
+			Params:  roomParam, Request: RoomSubmissionRequest{}, Response: RoomSubmissionResp{},
+			Headers: map[string]string{"Idempotency-Key": "As for /score, scoped to the room and player."},
+		},
+		{
+			Method: "POST", Path: "/rooms/{id}/close", Handler: handleCloseRoom,
+			Summary: "Close a room before its deadline and return its final rankings. Only the client that created the room may.",
+			Params:  roomParam, Response: Room{},
+		},
+		{
+			Method: "GET", Path: "/theme", Handler: handleGetTheme,
+			Summary:  "The active theme, used by /score when theme_hex is omitted.",
+			Response: ActiveTheme{},
+		},
+		{
+			Method: "GET", Path: "/colors/nearest", Handler: handleNearestColors,
+			Summary:  "Nearest CSS and traditional Japanese color names to a color.",
+			Query:    map[string]string{"hex": "Color as hex (# optional), rgb(), hsl() or a CSS color name; defaults to the active theme."},
+			Response: NearestColorsResp{},
+		},
+		{
+			Method: "GET", Path: "/theme/related", Handler: handleRelatedThemes,
+			Summary:  "Complementary, analogous and triadic colors of a theme, rotated in Oklch.",
+			Query:    map[string]string{"hex": "Color as hex (# optional), rgb(), hsl() or a CSS color name; defaults to the active theme."},
+			Response: RelatedThemes{},
+		},
+		{
+			Method: "POST", Path: "/admin/theme", Handler: handleRotateTheme,
+			Summary: "Switch the active theme, closing the previous theme's round.",
+			Request: RotateThemeRequest{}, Response: RotateThemeResp{},
+		},
+		{
+			Method: "POST", Path: "/admin/rooms/{id}/close", Handler: handleCloseRoom,
+			Summary: "Close any room before its deadline and return its final rankings.",
+			Params:  roomParam, Response: Room{},
+		},
+		{
+			Method: "POST", Path: "/admin/rounds/{hex}/close", Handler: handleCloseRetrospective,
+			Summary: "Close the open round of a theme and store its retrospective.",
+			Params:  themeParam, Response: Retrospective{}, Status: http.StatusCreated,
+		},
+		{
+			Method: "POST", Path: "/admin/cache/flush", Handler: handleFlushCache,
+			Summary:  "Drop cached scoring data.",
+			Response: FlushCacheResp{},
+		},
+		{
+			Method: "POST", Path: "/admin/config", Handler: handleReloadConfig,
+			Summary:  "Reload tunables from CONFIG_FILE and the environment without restarting; same as SIGHUP.",
+			Response: ConfigReloadResp{},
+		},
+		{
+			Method: "GET", Path: "/admin/flagged", Handler: handleFlagged,
+			Summary: "Read the queue of submissions flagged for review, long-polling for new ones.",
+			Query: map[string]string{
+				"after": "Return items with a larger seq; pass the previous response's next.",
+				"wait":  "How long to wait for new items, e.g. 25s; at most 30s.",
+			},
+			Response: FlaggedResp{},
+		},
+		{
+			Method: "GET", Path: "/admin/latency", Handler: handleLatency,
+			Summary:  "Per-stage latency distribution of recent submissions.",
+			Response: LatencyReport{},
+		},
+		{
+			Method: "GET", Path: "/admin/consistency", Handler: handleConsistency,
+			Summary:  "Score the built-in test suite under every scorer.",
+			Response: ConsistencyReport{},
+		},
+		{
+			Method: "GET", Path: "/admin/archive/{id}", Handler: handleGetArchivedImage,
+			Summary: "Download an archived submission image.",
+			Params:  imageParam, Produces: []string{"image/*"},
+		},
+		{
+			Method: "DELETE", Path: "/admin/archive/{id}", Handler: handleReleaseArchivedImage,
+			Summary: "Release one reference to an archived image; the last release deletes it.",
+			Params:  imageParam, Response: ArchiveReleaseResp{},
+		},
+		{
+			Method: "POST", Path: "/admin/archive/{id}/score", Handler: handleRescore, Heavy: true,
+			Summary: "Score an archived image again without recording a submission.",
+			Params:  imageParam, Request: RescoreRequest{}, Response: ScoreResponse{},
+		},
+		{
+			Method: "POST", Path: "/admin/rescore", Handler: handleRescoreRooms, Heavy: true,
+			Summary: "Score the archived submissions of rooms again under another method, recomputing their leaderboards alongside the originals.",
+			Request: RoomRescoreRequest{}, Response: RoomRescoreResp{},
+		},
+	}
+}
+
+// apiEnums are the value sets that `enum:"@name"` tags refer to.
+var apiEnums = map[string]func() []string{
+	"metrics": func() []string {
+		var out []string
+		for _, m := range scoring.Metrics {
+			out = append(out, m.Name)
+		}
+		return out
+	},
+	"errorCodes":     func() []string { return errorCodes },
+	"backgrounds":    func() []string { return scoring.Backgrounds },
+	"grayscaleModes": func() []string { return scoring.GrayscaleModes },
+	"visionModes":    func() []string { return scoring.VisionModes },
+	"languages":      func() []string { return feedbackLanguages },
+	"aggregations": func() []string {
+		var out []string
+		for _, a := range scoring.Aggregations {
+			out = append(out, a.Name)
+		}
+		return out
+	},
+}
+
+// publicPaths are the paths served without credentials: the routes marked
+// Public, and the OpenAPI document and docs page newRouter serves beside
+// them.
+func publicPaths(routes []apiRoute) map[string]bool {
+	public := map[string]bool{"/openapi.json": true, "/docs": true}
+	for _, rt := range routes {
+		if rt.Public {
+			public[rt.Path] = true
+		}
+	}
+	return public
+}
+
+func buildOpenAPI(routes []apiRoute) map[string]any {
+	gen := &schemaGen{schemas: map[string]any{}}
+	errSchema := gen.schema(reflect.TypeOf(ErrorResponse{}))
+	paths := map[string]map[string]any{}
+	for _, rt := range routes {
+		op := map[string]any{
+			"summary":     rt.Summary,
+			"operationId": operationID(rt.Method, rt.Path),
+		}
+		if rt.Deprecated {
+			op["deprecated"] = true
+		}
+		if rt.Public {
+			op["security"] = []any{}
+		} else if strings.HasPrefix(rt.Path, "/admin/") {
+			op["description"] = "Requires an admin API key."
+		}
+		var params []any
+		for _, name := range pathParams(rt.Path) {
+			params = append(params, map[string]any{
+				"name": name, "in": "path", "required": true,
+				"description": rt.Params[name],
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		for _, name := range slices.Sorted(maps.Keys(rt.Query)) {
+			params = append(params, map[string]any{
+				"name": name, "in": "query",
+				"description": rt.Query[name],
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		for _, name := range slices.Sorted(maps.Keys(rt.Headers)) {
+			params = append(params, map[string]any{
+				"name": name, "in": "header",
+				"description": rt.Headers[name],
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if rt.Request != nil || rt.Consumes != nil {
+			body := map[string]any{}
+			if rt.Request != nil {
+				body[mediaJSON] = map[string]any{"schema": gen.schema(reflect.TypeOf(rt.Request))}
+			}
+			for _, mt := range rt.Consumes {
+				body[mt] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}
+			}
+			op["requestBody"] = map[string]any{"required": true, "content": body}
+		}
+		status := rt.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := map[string]any{"description": http.StatusText(status)}
+		content := map[string]any{}
+		if rt.Response != nil {
+			content[mediaJSON] = map[string]any{"schema": gen.schema(reflect.TypeOf(rt.Response))}
+		}
+		for _, mt := range rt.Produces {
+			content[mt] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}
+		}
+		if len(content) > 0 {
+			ok["content"] = content
+		}
+		op["responses"] = map[string]any{
+			strconv.Itoa(status): ok,
+			"default": map[string]any{
+				"description": "Error; switch on error.code rather than the message.",
+				"content":     map[string]any{mediaJSON: map[string]any{"schema": errSchema}},
+			},
+		}
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = map[string]any{}
+		}
+		paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "iropico color scoring API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": gen.schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "An API key or a Firebase ID token.",
+				},
+			},
+		},
+		"security": []any{map[string]any{"bearer": []any{}}},
+	}
+}
+
+var pathParamRe = regexp.MustCompile(`\{(\w+)\}`)
+
+func pathParams(path string) []string {
+	var out []string
+	for _, m := range pathParamRe.FindAllStringSubmatch(path, -1) {
+		out = append(out, m[1])
+	}
+	return out
+}
+
+// operationID turns "GET /themes/{hex}/retrospective" into
+// "getThemesHexRetrospective".
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '{' || r == '}' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// schemaGen converts Go types to OpenAPI schemas, collecting named structs
+// under components/schemas.
+type schemaGen struct {
+	schemas map[string]any
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(Duration(0))
+)
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return map[string]any{"type": "string", "description": `Go duration, e.g. "30s" or "10m".`}
+	case t.Kind() == reflect.Struct:
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = nil // guards recursion
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]any{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case t.Kind() == reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	}
+	return map[string]any{}
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := g.schema(f.Type)
+		if doc := f.Tag.Get("doc"); doc != "" || f.Tag.Get("enum") != "" {
+			// $ref siblings are ignored in OpenAPI 3.0, so wrap it.
+			if _, isRef := s["$ref"]; isRef {
+				s = map[string]any{"allOf": []any{s}}
+			}
+			if doc != "" {
+				s["description"] = doc
+			}
+		}
+		if e := f.Tag.Get("enum"); e != "" {
+			if fn, ok := apiEnums[strings.TrimPrefix(e, "@")]; ok && strings.HasPrefix(e, "@") {
+				s["enum"] = fn()
+			} else {
+				s["enum"] = strings.Split(e, ",")
+			}
+		}
+		props[name] = s
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	out := map[string]any{"type": "object", "properties": props}
+	if required != nil {
+		out["required"] = required
+	}
+	return out
+}
+
+func handleOpenAPI(routes []apiRoute) http.HandlerFunc {
+	b, err := json.MarshalIndent(buildOpenAPI(routes), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}
+}
+
+const swaggerUIPage = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>iropico API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+func handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
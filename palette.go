@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+)
+
+type PaletteRequest struct {
+	ImageBase64 string `json:"image_base64" doc:"PNG, JPEG or GIF as standard or URL-safe base64; a data: URL prefix is allowed."`
+	Count       int    `json:"count,omitempty" doc:"Number of colors to return, at most 16; defaults to 5."`
+}
+
+type PaletteResp struct {
+	Colors []imaging.PaletteColor `json:"colors" doc:"Ordered by descending proportion; fewer than count when the image has fewer distinct colors."`
+}
+
+// decodeUploadedImage decodes an image_base64 request field.
+func decodeUploadedImage(s string) (image.Image, error) {
+	buf := imaging.GetBuffer()
+	defer imaging.PutBuffer(buf)
+	if err := imaging.DecodeBase64Into(buf, s); err != nil {
+		return nil, &requestError{Status: http.StatusBadRequest, Code: codeInvalidBase64, Field: "image_base64", Msg: "bad image: " + err.Error()}
+	}
+	data := buf.Bytes()
+	img, _, err := imaging.DecodeWithin(data, config().imageLimits())
+	if err != nil {
+		return nil, imageDecodeError(err, data)
+	}
+	return img, nil
+}
+
+// palette extracts up to n colors of img, with proportions rounded for
+// clients.
+func palette(img image.Image, n int) []imaging.PaletteColor {
+	colors := imaging.ExtractPalette(img, n, config().Scoring.MaxSamples)
+	for i := range colors {
+		colors[i].Proportion = math.Round(colors[i].Proportion*1000) / 1000
+	}
+	return colors
+}
+
+// handlePalette serves theme-independent palette extraction, e.g. for
+// building a theme from a photo.
+func handlePalette(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config().Limits.MaxBodyBytes)
+	var req PaletteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, r, bodyError(err))
+		return
+	}
+	if req.Count < 0 || req.Count > imaging.MaxPaletteSize {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "count",
+			Msg: fmt.Sprintf("count must be between 1 and %d, or 0 for the default", imaging.MaxPaletteSize)})
+		return
+	}
+	img, err := decodeUploadedImage(req.ImageBase64)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PaletteResp{Colors: palette(img, req.Count)})
+}
@@ -0,0 +1,353 @@
+// Package colormath converts between sRGB, linear sRGB, CIELAB and Oklab and
+// measures color differences. Channels are floats in [0, 1] unless noted.
+package colormath
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Vec3 is a color in some three-component space.
+type Vec3 [3]float64
+
+// SRGBToLinear decodes one gamma-encoded sRGB channel.
+func SRGBToLinear(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// The transfer function dominates pixel scans, and decoded pixels only take
+// 256 or 65536 values, so those are precomputed. The tables hold exactly
+// what SRGBToLinear returns for them.
+var (
+	srgb8Linear  = srgbTable(256)
+	srgb16Linear = srgbTable(65536)
+)
+
+func srgbTable(n int) []float64 {
+	t := make([]float64, n)
+	for i := range t {
+		t[i] = SRGBToLinear(float64(i) / float64(n-1))
+	}
+	return t
+}
+
+// SRGB8ToLinear is SRGBToLinear of the 8-bit channel v / 255.
+func SRGB8ToLinear(v uint8) float64 { return srgb8Linear[v] }
+
+// SRGB16ToLinear is SRGBToLinear of the 16-bit channel v / 65535.
+func SRGB16ToLinear(v uint16) float64 { return srgb16Linear[v] }
+
+// LinearToSRGB gamma-encodes one linear sRGB channel.
+func LinearToSRGB(c float64) float64 {
+	if c <= 0.0031308 {
+		return 12.92 * c
+	}
+	return 1.055*math.Pow(c, 1.0/2.4) - 0.055
+}
+
+// ParseHex parses #RRGGBB; the # is optional.
+func ParseHex(h string) (r, g, b uint8, err error) {
+	if strings.HasPrefix(h, "#") {
+		h = h[1:]
+	}
+	if len(h) != 6 {
+		return 0, 0, 0, errors.New("want #RRGGBB")
+	}
+	ri, err := strconv.ParseUint(h[0:2], 16, 8)
+	if err != nil {
+		return
+	}
+	gi, err := strconv.ParseUint(h[2:4], 16, 8)
+	if err != nil {
+		return
+	}
+	bi, err := strconv.ParseUint(h[4:6], 16, 8)
+	if err != nil {
+		return
+	}
+	return uint8(ri), uint8(gi), uint8(bi), nil
+}
+
+// HexByte formats an sRGB channel as two lowercase hex digits, clamping to
+// [0, 1].
+func HexByte(c float64) string {
+	v := int(math.Round(c * 255))
+	if v < 0 {
+		v = 0
+	}
+	if v > 255 {
+		v = 255
+	}
+	s := strconv.FormatInt(int64(v), 16)
+	if len(s) == 1 {
+		s = "0" + s
+	}
+	return strings.ToLower(s)
+}
+
+// LinearHex formats a linear sRGB color as #rrggbb.
+func LinearHex(lr, lg, lb float64) string {
+	return "#" + HexByte(LinearToSRGB(lr)) + HexByte(LinearToSRGB(lg)) + HexByte(LinearToSRGB(lb))
+}
+
+// LinearRGB returns linear sRGB unchanged, for metrics that work in it.
+func LinearRGB(lr, lg, lb float64) Vec3 { return Vec3{lr, lg, lb} }
+
+// Euclid is the straight-line distance between a and b.
+func Euclid(a, b Vec3) float64 {
+	d0, d1, d2 := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return math.Sqrt(d0*d0 + d1*d1 + d2*d2)
+}
+
+// LinearToLab converts linear sRGB to CIELAB with a D65 white point.
+func LinearToLab(lr, lg, lb float64) Vec3 {
+	x := (0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / 0.95047
+	y := 0.2126729*lr + 0.7151522*lg + 0.0721750*lb
+	z := (0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / 1.08883
+	f := func(t float64) float64 {
+		const d = 6.0 / 29.0
+		if t > d*d*d {
+			return math.Cbrt(t)
+		}
+		return t/(3*d*d) + 4.0/29.0
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return Vec3{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// LinearToOklab converts linear sRGB to Oklab.
+func LinearToOklab(lr, lg, lb float64) Vec3 {
+	l := math.Cbrt(0.4122214708*lr + 0.5363325363*lg + 0.0514459929*lb)
+	m := math.Cbrt(0.2119034982*lr + 0.6806995451*lg + 0.1073969566*lb)
+	s := math.Cbrt(0.0883024619*lr + 0.2817188376*lg + 0.6299787005*lb)
+	return Vec3{
+		0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+// HueChroma is the Oklab chroma at which LinearToHue treats a color as fully
+// saturated; grayer colors are pulled toward the neutral origin.
+const HueChroma = 0.05
+
+// LinearToHue maps linear sRGB to its Oklch hue direction, as a point on
+// the unit circle in the last two components: lightness and chroma are
+// dropped, except that colors below HueChroma move toward the center in
+// proportion, so a gray is equally far from every hue.
+func LinearToHue(lr, lg, lb float64) Vec3 {
+	lab := LinearToOklab(lr, lg, lb)
+	c := math.Hypot(lab[1], lab[2])
+	if c < 1e-9 {
+		return Vec3{}
+	}
+	k := math.Min(c, HueChroma) / HueChroma / c
+	return Vec3{0, lab[1] * k, lab[2] * k}
+}
+
+// LinearToChromaticity is Oklab without its lightness: hue and chroma only.
+func LinearToChromaticity(lr, lg, lb float64) Vec3 {
+	lab := LinearToOklab(lr, lg, lb)
+	return Vec3{0, lab[1], lab[2]}
+}
+
+// DeltaE94 is CIE94 with the graphic arts weights with ref as the reference color.
+func DeltaE94(ref, sample Vec3) float64 {
+	dL := ref[0] - sample[0]
+	c1 := math.Hypot(ref[1], ref[2])
+	c2 := math.Hypot(sample[1], sample[2])
+	dC := c1 - c2
+	da, db := ref[1]-sample[1], ref[2]-sample[2]
+	dH2 := math.Max(0, da*da+db*db-dC*dC)
+	sC := 1 + 0.045*c1
+	sH := 1 + 0.015*c1
+	return math.Sqrt(dL*dL + (dC/sC)*(dC/sC) + dH2/(sH*sH))
+}
+
+// DeltaE2000 is CIEDE2000, following Sharma, Wu & Dalal (2005) with kL = kC = kH = 1.
+func DeltaE2000(ref, sample Vec3) float64 {
+	L1, a1, b1 := ref[0], ref[1], ref[2]
+	L2, a2, b2 := sample[0], sample[1], sample[2]
+	const deg = math.Pi / 180
+
+	cBar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	c7 := math.Pow(cBar, 7)
+	g := 0.5 * (1 - math.Sqrt(c7/(c7+math.Pow(25, 7))))
+	a1p, a2p := (1+g)*a1, (1+g)*a2
+	c1p, c2p := math.Hypot(a1p, b1), math.Hypot(a2p, b2)
+	hue := func(b, a float64) float64 {
+		if a == 0 && b == 0 {
+			return 0
+		}
+		h := math.Atan2(b, a) / deg
+		if h < 0 {
+			h += 360
+		}
+		return h
+	}
+	h1p, h2p := hue(b1, a1p), hue(b2, a2p)
+
+	dLp := L2 - L1
+	dCp := c2p - c1p
+	var dhp float64
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(dhp/2*deg)
+
+	lBarp := (L1 + L2) / 2
+	cBarp := (c1p + c2p) / 2
+	hBarp := h1p + h2p
+	if c1p*c2p != 0 {
+		if math.Abs(h1p-h2p) <= 180 {
+			hBarp /= 2
+		} else if h1p+h2p < 360 {
+			hBarp = (hBarp + 360) / 2
+		} else {
+			hBarp = (hBarp - 360) / 2
+		}
+	}
+	t := 1 - 0.17*math.Cos((hBarp-30)*deg) + 0.24*math.Cos(2*hBarp*deg) +
+		0.32*math.Cos((3*hBarp+6)*deg) - 0.20*math.Cos((4*hBarp-63)*deg)
+	dTheta := 30 * math.Exp(-((hBarp-275)/25)*((hBarp-275)/25))
+	cBarp7 := math.Pow(cBarp, 7)
+	rC := 2 * math.Sqrt(cBarp7/(cBarp7+math.Pow(25, 7)))
+	l50 := (lBarp - 50) * (lBarp - 50)
+	sL := 1 + 0.015*l50/math.Sqrt(20+l50)
+	sC := 1 + 0.045*cBarp
+	sH := 1 + 0.015*cBarp*t
+	rT := -math.Sin(2*dTheta*deg) * rC
+
+	fL, fC, fH := dLp/sL, dCp/sC, dHp/sH
+	return math.Sqrt(fL*fL + fC*fC + fH*fH + rT*fC*fH)
+}
+
+// DeltaLightness compares two CIELAB colors mostly by lightness: chroma
+// differences count a quarter as much and hue not at all, so any object of
+// the right lightness comes close to a gray reference.
+func DeltaLightness(a, b Vec3) float64 {
+	dL := a[0] - b[0]
+	dC := (math.Hypot(a[1], a[2]) - math.Hypot(b[1], b[2])) / 4
+	return math.Sqrt(dL*dL + dC*dC)
+}
+
+// OklabToOklch converts Oklab to its polar form: lightness, chroma and hue
+// in degrees. Hue is 0 for achromatic colors.
+func OklabToOklch(lab Vec3) Vec3 {
+	c := math.Hypot(lab[1], lab[2])
+	if c < 1e-9 {
+		return Vec3{lab[0], 0, 0}
+	}
+	h := math.Atan2(lab[2], lab[1]) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return Vec3{lab[0], c, h}
+}
+
+// OklabToLinear converts Oklab to linear sRGB, which may fall outside
+// [0, 1] for colors beyond the sRGB gamut.
+func OklabToLinear(lab Vec3) (lr, lg, lb float64) {
+	l := lab[0] + 0.3963377774*lab[1] + 0.2158037573*lab[2]
+	m := lab[0] - 0.1055613458*lab[1] - 0.0638541728*lab[2]
+	s := lab[0] - 0.0894841775*lab[1] - 1.2914855480*lab[2]
+	l, m, s = l*l*l, m*m*m, s*s*s
+	return 4.0767416621*l - 3.3077115913*m + 0.2309699292*s,
+		-1.2684380046*l + 2.6097574011*m - 0.3413193965*s,
+		-0.0041960863*l - 0.7034186147*m + 1.7076147010*s
+}
+
+// OklchToLinear converts Oklch, with hue in degrees, to linear sRGB. Colors
+// beyond the sRGB gamut keep their lightness and hue and lose chroma until
+// they fit.
+func OklchToLinear(lch Vec3) (lr, lg, lb float64) {
+	h := lch[2] * math.Pi / 180
+	at := func(c float64) (float64, float64, float64, bool) {
+		r, g, b := OklabToLinear(Vec3{lch[0], c * math.Cos(h), c * math.Sin(h)})
+		const eps = 1e-9
+		return r, g, b, min(r, g, b) >= -eps && max(r, g, b) <= 1+eps
+	}
+	if r, g, b, ok := at(lch[1]); ok {
+		return r, g, b
+	}
+	lo, hi := 0.0, lch[1]
+	for range 32 {
+		if _, _, _, ok := at((lo + hi) / 2); ok {
+			lo = (lo + hi) / 2
+		} else {
+			hi = (lo + hi) / 2
+		}
+	}
+	r, g, b, _ := at(lo)
+	return min(max(r, 0), 1), min(max(g, 0), 1), min(max(b, 0), 1)
+}
+
+// HSLToSRGB converts hue in degrees and saturation and lightness in [0, 1]
+// to gamma-encoded sRGB.
+func HSLToSRGB(h, s, l float64) (r, g, b float64) {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	f := func(n float64) float64 {
+		k := math.Mod(n+h/30, 12)
+		return l - s*math.Min(l, 1-l)*math.Max(-1, math.Min(k-3, math.Min(9-k, 1)))
+	}
+	return f(0), f(8), f(4)
+}
+
+// SRGBToHSL converts gamma-encoded sRGB to hue in degrees and saturation
+// and lightness in [0, 1]. Hue is 0 for grays.
+func SRGBToHSL(r, g, b float64) (h, s, l float64) {
+	hi, lo := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	l = (hi + lo) / 2
+	d := hi - lo
+	if d == 0 {
+		return 0, 0, l
+	}
+	s = d / (1 - math.Abs(2*l-1))
+	switch hi {
+	case r:
+		h = math.Mod((g-b)/d, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, s, l
+}
+
+// CMYKToSRGB converts print CMYK ink coverage in [0, 1] to gamma-encoded
+// sRGB. It is a quadratic fit to the U.S. Web Coated (SWOP) profile, the
+// usual assumption for CMYK files without an embedded profile; embedded
+// profiles are not applied.
+func CMYKToSRGB(c, m, y, k float64) (r, g, b float64) {
+	r = 255 + c*(-4.387332384609988*c+54.48615194189176*m+18.82290502165302*y+212.25662451639585*k-285.2331026137004) +
+		m*(1.7149763477362134*m-5.6096736904047315*y-17.873870861415444*k-5.497006427196366) +
+		y*(-2.5217340131683033*y-21.248923337353073*k+17.5119270841813) +
+		k*(-21.86122147463605*k-189.48180835922747)
+	g = 255 + c*(8.841041422036149*c+60.118027045597366*m+6.871425592049007*y+31.159100130055922*k-79.2970844816548) +
+		m*(-15.310361306967817*m+17.575251261109482*y+131.35250912493976*k-190.9453302588951) +
+		y*(4.444339102852739*y+9.8632861493405*k-24.86741582555878) +
+		k*(-20.737325471181034*k-187.80453709719578)
+	b = 255 + c*(0.8842522430003296*c+8.078677503112928*m+30.89978309703729*y-0.23883238689178934*k-14.183576799673286) +
+		m*(10.49593273432072*m+63.02378494754052*y+50.606957656360734*k-112.23884253719248) +
+		y*(0.03296041114873217*y+115.60384449646641*k-193.58209356861505) +
+		k*(-22.33816807309886*k-180.12613974708367)
+	clamp := func(v float64) float64 { return math.Min(1, math.Max(0, v/255)) }
+	return clamp(r), clamp(g), clamp(b)
+}
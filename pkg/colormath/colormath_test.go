@@ -0,0 +1,46 @@
+package colormath
+
+import "testing"
+
+func TestSRGBTables(t *testing.T) {
+	for v := range 256 {
+		if got, want := SRGB8ToLinear(uint8(v)), SRGBToLinear(float64(v)/255); got != want {
+			t.Errorf("SRGB8ToLinear(%d) = %v, want %v", v, got, want)
+		}
+	}
+	for v := range 65536 {
+		if got, want := SRGB16ToLinear(uint16(v)), SRGBToLinear(float64(v)/65535); got != want {
+			t.Fatalf("SRGB16ToLinear(%d) = %v, want %v", v, got, want)
+		}
+	}
+}
+
+// sink keeps the benchmarks' calls from being optimized away.
+var sink float64
+
+// The transfer benchmarks feed varying inputs, so table reads are not all
+// cache hits; BenchmarkSRGBToLinear is the computation the tables replace.
+
+func BenchmarkSRGBToLinear(b *testing.B) {
+	var sum float64
+	for i := range b.N {
+		sum += SRGBToLinear(float64(uint16(i*40503)) / 65535)
+	}
+	sink = sum
+}
+
+func BenchmarkSRGB8ToLinear(b *testing.B) {
+	var sum float64
+	for i := range b.N {
+		sum += SRGB8ToLinear(uint8(i * 40503))
+	}
+	sink = sum
+}
+
+func BenchmarkSRGB16ToLinear(b *testing.B) {
+	var sum float64
+	for i := range b.N {
+		sum += SRGB16ToLinear(uint16(i * 40503))
+	}
+	sink = sum
+}
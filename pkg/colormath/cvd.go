@@ -0,0 +1,46 @@
+package colormath
+
+// Dichromacies SimulateDichromacy can simulate: the absence of the long
+// (red), medium (green) or short (blue) wavelength cones.
+const (
+	Protanopia   = "protanopia"
+	Deuteranopia = "deuteranopia"
+	Tritanopia   = "tritanopia"
+)
+
+// dichromacyMatrices are Machado, Oliveira and Fernandes' (2009)
+// simulation matrices at full severity, which map linear sRGB to the
+// linear sRGB a dichromat perceives alike.
+var dichromacyMatrices = map[string][3][3]float64{
+	Protanopia: {
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	},
+	Deuteranopia: {
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	},
+	Tritanopia: {
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	},
+}
+
+// SimulateDichromacy returns the linear sRGB color a viewer with kind, one
+// of Protanopia, Deuteranopia or Tritanopia, sees for lr, lg, lb, clamped
+// to the gamut. Any other kind is normal vision and returns the color
+// unchanged.
+func SimulateDichromacy(kind string, lr, lg, lb float64) (float64, float64, float64) {
+	m, ok := dichromacyMatrices[kind]
+	if !ok {
+		return lr, lg, lb
+	}
+	var out [3]float64
+	for i, row := range m {
+		out[i] = min(1, max(0, row[0]*lr+row[1]*lg+row[2]*lb))
+	}
+	return out[0], out[1], out[2]
+}
@@ -0,0 +1,146 @@
+package colormath
+
+import (
+	"math"
+	"sort"
+)
+
+// NamedColor is an sRGB color with a display name.
+type NamedColor struct {
+	Name    string
+	R, G, B uint8
+}
+
+// CSSColors are the CSS named colors in name order, without transparent.
+// Aliases such as gray and grey both appear; the first wins ties.
+var CSSColors = cssColors()
+
+func cssColors() []NamedColor {
+	var out []NamedColor
+	for name, c := range namedColors {
+		if c[3] == 255 {
+			out = append(out, NamedColor{name, c[0], c[1], c[2]})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// JapaneseColors are traditional Japanese colors (和色) under their common
+// names, with the values most color dictionaries give.
+var JapaneseColors = []NamedColor{
+	{"茜色", 0xb7, 0x28, 0x2e},
+	{"紅色", 0xd7, 0x00, 0x3a},
+	{"真紅", 0xa2, 0x20, 0x41},
+	{"緋色", 0xd3, 0x38, 0x1c},
+	{"朱色", 0xeb, 0x61, 0x01},
+	{"臙脂色", 0xb9, 0x40, 0x47},
+	{"韓紅", 0xe9, 0x54, 0x64},
+	{"薔薇色", 0xe9, 0x54, 0x6b},
+	{"桜色", 0xfe, 0xf4, 0xf4},
+	{"桃色", 0xf0, 0x91, 0x99},
+	{"撫子色", 0xee, 0xbb, 0xcb},
+	{"紅梅色", 0xf2, 0xa0, 0xa1},
+	{"薄紅", 0xf0, 0x90, 0x8d},
+	{"珊瑚色", 0xf5, 0xb1, 0x99},
+	{"灰桜", 0xe8, 0xd3, 0xd1},
+	{"桜鼠", 0xe9, 0xdf, 0xe5},
+	{"梅鼠", 0xc0, 0x99, 0xa0},
+	{"鳩羽鼠", 0x9e, 0x8b, 0x8e},
+	{"蘇芳", 0x9e, 0x3d, 0x3f},
+	{"弁柄色", 0x8f, 0x2e, 0x14},
+	{"煉瓦色", 0xb5, 0x52, 0x33},
+	{"樺色", 0xcd, 0x5e, 0x3c},
+	{"柿色", 0xed, 0x6d, 0x3d},
+	{"橙色", 0xee, 0x78, 0x00},
+	{"蜜柑色", 0xf0, 0x83, 0x00},
+	{"杏色", 0xf7, 0xb9, 0x77},
+	{"山吹色", 0xf8, 0xb5, 0x00},
+	{"鬱金色", 0xfa, 0xbf, 0x14},
+	{"卵色", 0xfc, 0xd5, 0x75},
+	{"黄色", 0xff, 0xd9, 0x00},
+	{"菜の花色", 0xff, 0xec, 0x47},
+	{"刈安色", 0xf5, 0xe5, 0x6b},
+	{"芥子色", 0xd0, 0xaf, 0x4c},
+	{"黄土色", 0xc3, 0x91, 0x43},
+	{"若草色", 0xc3, 0xd8, 0x25},
+	{"萌黄色", 0xaa, 0xcf, 0x53},
+	{"柳色", 0xa8, 0xc9, 0x7f},
+	{"抹茶色", 0xc5, 0xc5, 0x6a},
+	{"鶯色", 0x92, 0x8c, 0x36},
+	{"苔色", 0x69, 0x82, 0x1b},
+	{"松葉色", 0x42, 0x60, 0x2d},
+	{"若竹色", 0x68, 0xbe, 0x8d},
+	{"常磐色", 0x00, 0x7b, 0x43},
+	{"萌葱色", 0x00, 0x6e, 0x54},
+	{"青竹色", 0x7e, 0xbe, 0xa5},
+	{"青磁色", 0x7e, 0xbe, 0xab},
+	{"浅葱色", 0x00, 0xa3, 0xaf},
+	{"新橋色", 0x59, 0xb9, 0xc6},
+	{"白群", 0x83, 0xcc, 0xd2},
+	{"水色", 0xbc, 0xe2, 0xe8},
+	{"空色", 0xa0, 0xd8, 0xef},
+	{"勿忘草色", 0x89, 0xc3, 0xeb},
+	{"露草色", 0x38, 0xa1, 0xdb},
+	{"縹色", 0x27, 0x92, 0xc3},
+	{"紺碧", 0x00, 0x7b, 0xbb},
+	{"群青色", 0x4c, 0x6c, 0xb3},
+	{"瑠璃色", 0x1e, 0x50, 0xa2},
+	{"藍色", 0x16, 0x5e, 0x83},
+	{"紺色", 0x22, 0x3a, 0x70},
+	{"藍鼠", 0x6c, 0x84, 0x8d},
+	{"藤色", 0xbb, 0xbc, 0xde},
+	{"桔梗色", 0x56, 0x54, 0xa2},
+	{"菫色", 0x70, 0x58, 0xa3},
+	{"江戸紫", 0x74, 0x53, 0x99},
+	{"紫", 0x88, 0x48, 0x98},
+	{"菖蒲色", 0xcc, 0x7e, 0xb1},
+	{"牡丹色", 0xe7, 0x60, 0x9e},
+	{"小豆色", 0x96, 0x51, 0x4d},
+	{"鳶色", 0x95, 0x48, 0x3f},
+	{"茶色", 0x96, 0x50, 0x42},
+	{"栗色", 0x76, 0x2f, 0x07},
+	{"焦茶", 0x6f, 0x4b, 0x3e},
+	{"錆色", 0x6c, 0x35, 0x24},
+	{"赤銅色", 0x75, 0x21, 0x00},
+	{"檜皮色", 0x96, 0x50, 0x36},
+	{"胡桃色", 0xa8, 0x6f, 0x4c},
+	{"枯茶", 0x8d, 0x64, 0x49},
+	{"煤竹色", 0x6f, 0x51, 0x4c},
+	{"駱駝色", 0xbf, 0x79, 0x4e},
+	{"土色", 0xbc, 0x76, 0x3c},
+	{"狐色", 0xc3, 0x87, 0x43},
+	{"伽羅色", 0xd8, 0xa3, 0x73},
+	{"黄橡", 0xb6, 0x8d, 0x4c},
+	{"朽葉色", 0x91, 0x73, 0x47},
+	{"利休色", 0x8f, 0x86, 0x67},
+	{"丁子色", 0xef, 0xcd, 0x9a},
+	{"砂色", 0xdc, 0xd3, 0xb2},
+	{"枯草色", 0xe4, 0xdc, 0x8a},
+	{"葡萄色", 0x52, 0x2f, 0x60},
+	{"亜麻色", 0xd6, 0xc6, 0xaf},
+	{"象牙色", 0xf8, 0xf4, 0xe6},
+	{"生成り色", 0xfb, 0xfa, 0xf5},
+	{"白", 0xff, 0xff, 0xff},
+	{"銀鼠", 0xaf, 0xaf, 0xb0},
+	{"鼠色", 0x94, 0x94, 0x95},
+	{"利休鼠", 0x88, 0x8e, 0x7e},
+	{"鉛色", 0x7b, 0x7c, 0x7d},
+	{"灰色", 0x7d, 0x7d, 0x7d},
+	{"墨", 0x59, 0x58, 0x57},
+	{"黒", 0x2b, 0x2b, 0x2b},
+	{"漆黒", 0x0d, 0x00, 0x15},
+}
+
+// NearestNamed returns the color in list closest to the linear sRGB color
+// by CIEDE2000, and that distance. list must not be empty.
+func NearestNamed(list []NamedColor, lr, lg, lb float64) (NamedColor, float64) {
+	lab := LinearToLab(lr, lg, lb)
+	best, bestD := list[0], math.Inf(1)
+	for _, c := range list {
+		if d := DeltaE2000(LinearToLab(SRGB8ToLinear(c.R), SRGB8ToLinear(c.G), SRGB8ToLinear(c.B)), lab); d < bestD {
+			best, bestD = c, d
+		}
+	}
+	return best, bestD
+}
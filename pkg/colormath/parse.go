@@ -0,0 +1,325 @@
+package colormath
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseColor parses a CSS color as users type it: hex with 3, 4, 6 or 8
+// digits (the # is optional), rgb()/rgba(), hsl()/hsla() in comma or space
+// syntax, and the CSS named colors. Alpha defaults to 255.
+func ParseColor(s string) (r, g, b, a uint8, err error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := namedColors[s]; ok {
+		return c[0], c[1], c[2], c[3], nil
+	}
+	if fn, args, ok := cssFunc(s); ok {
+		switch fn {
+		case "rgb", "rgba":
+			return parseRGBFunc(s, args)
+		case "hsl", "hsla":
+			return parseHSLFunc(s, args)
+		}
+		return 0, 0, 0, 0, fmt.Errorf("unsupported color function %s()", fn)
+	}
+	if r, g, b, a, ok := parseHexDigits(strings.TrimPrefix(s, "#")); ok {
+		return r, g, b, a, nil
+	}
+	return 0, 0, 0, 0, fmt.Errorf("unrecognized color %q; want hex, rgb(), hsl() or a CSS color name", s)
+}
+
+func parseHexDigits(h string) (r, g, b, a uint8, ok bool) {
+	if _, err := strconv.ParseUint(h, 16, 64); err != nil {
+		return 0, 0, 0, 0, false
+	}
+	digit := func(i int) uint8 {
+		v, _ := strconv.ParseUint(h[i:i+1], 16, 8)
+		return uint8(v) * 0x11
+	}
+	byteAt := func(i int) uint8 {
+		v, _ := strconv.ParseUint(h[i:i+2], 16, 8)
+		return uint8(v)
+	}
+	switch len(h) {
+	case 3:
+		return digit(0), digit(1), digit(2), 255, true
+	case 4:
+		return digit(0), digit(1), digit(2), digit(3), true
+	case 6:
+		return byteAt(0), byteAt(2), byteAt(4), 255, true
+	case 8:
+		return byteAt(0), byteAt(2), byteAt(4), byteAt(6), true
+	}
+	return 0, 0, 0, 0, false
+}
+
+// cssFunc splits "name(a, b, c / d)" into name and its arguments, with the
+// alpha after "/" as the last argument.
+func cssFunc(s string) (name string, args []string, ok bool) {
+	open := strings.IndexByte(s, '(')
+	if open <= 0 || !strings.HasSuffix(s, ")") {
+		return "", nil, false
+	}
+	name, body := strings.TrimSpace(s[:open]), s[open+1:len(s)-1]
+	main, alpha, slash := strings.Cut(body, "/")
+	if strings.Contains(main, ",") {
+		for _, f := range strings.Split(main, ",") {
+			args = append(args, strings.TrimSpace(f))
+		}
+	} else {
+		args = strings.Fields(main)
+	}
+	if slash {
+		args = append(args, strings.TrimSpace(alpha))
+	}
+	return name, args, true
+}
+
+func parseRGBFunc(s string, args []string) (r, g, b, a uint8, err error) {
+	if len(args) != 3 && len(args) != 4 {
+		return 0, 0, 0, 0, fmt.Errorf("bad color %q: want 3 channels and an optional alpha", s)
+	}
+	var ch [3]float64
+	for i := range ch {
+		if ch[i], err = cssNumber(args[i], 255); err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("bad color %q: %w", s, err)
+		}
+	}
+	a = 255
+	if len(args) == 4 {
+		if a, err = cssAlpha(args[3]); err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("bad color %q: %w", s, err)
+		}
+	}
+	return clampByte(ch[0]), clampByte(ch[1]), clampByte(ch[2]), a, nil
+}
+
+func parseHSLFunc(s string, args []string) (r, g, b, a uint8, err error) {
+	if len(args) != 3 && len(args) != 4 {
+		return 0, 0, 0, 0, fmt.Errorf("bad color %q: want hue, saturation, lightness and an optional alpha", s)
+	}
+	h, err := cssHue(args[0])
+	if err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("bad color %q: %w", s, err)
+	}
+	sat, err := cssNumber(args[1], 100)
+	if err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("bad color %q: %w", s, err)
+	}
+	light, err := cssNumber(args[2], 100)
+	if err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("bad color %q: %w", s, err)
+	}
+	a = 255
+	if len(args) == 4 {
+		if a, err = cssAlpha(args[3]); err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("bad color %q: %w", s, err)
+		}
+	}
+	sr, sg, sb := HSLToSRGB(h, math.Min(1, math.Max(0, sat/100)), math.Min(1, math.Max(0, light/100)))
+	return clampByte(sr * 255), clampByte(sg * 255), clampByte(sb * 255), a, nil
+}
+
+// cssNumber parses a plain number or a percentage of full.
+func cssNumber(s string, full float64) (float64, error) {
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad percentage %q", s)
+		}
+		return v / 100 * full, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", s)
+	}
+	return v, nil
+}
+
+// cssAlpha parses an alpha in [0, 1] or a percentage.
+func cssAlpha(s string) (uint8, error) {
+	v, err := cssNumber(s, 1)
+	if err != nil {
+		return 0, err
+	}
+	return clampByte(v * 255), nil
+}
+
+// cssHue parses a hue in degrees, with an optional deg, rad, grad or turn
+// unit.
+func cssHue(s string) (float64, error) {
+	scale := 1.0
+	for _, u := range []struct {
+		suffix string
+		deg    float64
+	}{{"deg", 1}, {"grad", 0.9}, {"rad", 180 / math.Pi}, {"turn", 360}} {
+		if v, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, scale = v, u.deg
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad hue %q", s)
+	}
+	return v * scale, nil
+}
+
+func clampByte(v float64) uint8 {
+	return uint8(math.Round(math.Min(255, math.Max(0, v))))
+}
+
+// namedColors are the CSS Color Module Level 4 keywords.
+var namedColors = map[string][4]uint8{
+	"transparent":          {0, 0, 0, 0},
+	"aliceblue":            {240, 248, 255, 255},
+	"antiquewhite":         {250, 235, 215, 255},
+	"aqua":                 {0, 255, 255, 255},
+	"aquamarine":           {127, 255, 212, 255},
+	"azure":                {240, 255, 255, 255},
+	"beige":                {245, 245, 220, 255},
+	"bisque":               {255, 228, 196, 255},
+	"black":                {0, 0, 0, 255},
+	"blanchedalmond":       {255, 235, 205, 255},
+	"blue":                 {0, 0, 255, 255},
+	"blueviolet":           {138, 43, 226, 255},
+	"brown":                {165, 42, 42, 255},
+	"burlywood":            {222, 184, 135, 255},
+	"cadetblue":            {95, 158, 160, 255},
+	"chartreuse":           {127, 255, 0, 255},
+	"chocolate":            {210, 105, 30, 255},
+	"coral":                {255, 127, 80, 255},
+	"cornflowerblue":       {100, 149, 237, 255},
+	"cornsilk":             {255, 248, 220, 255},
+	"crimson":              {220, 20, 60, 255},
+	"cyan":                 {0, 255, 255, 255},
+	"darkblue":             {0, 0, 139, 255},
+	"darkcyan":             {0, 139, 139, 255},
+	"darkgoldenrod":        {184, 134, 11, 255},
+	"darkgray":             {169, 169, 169, 255},
+	"darkgreen":            {0, 100, 0, 255},
+	"darkgrey":             {169, 169, 169, 255},
+	"darkkhaki":            {189, 183, 107, 255},
+	"darkmagenta":          {139, 0, 139, 255},
+	"darkolivegreen":       {85, 107, 47, 255},
+	"darkorange":           {255, 140, 0, 255},
+	"darkorchid":           {153, 50, 204, 255},
+	"darkred":              {139, 0, 0, 255},
+	"darksalmon":           {233, 150, 122, 255},
+	"darkseagreen":         {143, 188, 143, 255},
+	"darkslateblue":        {72, 61, 139, 255},
+	"darkslategray":        {47, 79, 79, 255},
+	"darkslategrey":        {47, 79, 79, 255},
+	"darkturquoise":        {0, 206, 209, 255},
+	"darkviolet":           {148, 0, 211, 255},
+	"deeppink":             {255, 20, 147, 255},
+	"deepskyblue":          {0, 191, 255, 255},
+	"dimgray":              {105, 105, 105, 255},
+	"dimgrey":              {105, 105, 105, 255},
+	"dodgerblue":           {30, 144, 255, 255},
+	"firebrick":            {178, 34, 34, 255},
+	"floralwhite":          {255, 250, 240, 255},
+	"forestgreen":          {34, 139, 34, 255},
+	"fuchsia":              {255, 0, 255, 255},
+	"gainsboro":            {220, 220, 220, 255},
+	"ghostwhite":           {248, 248, 255, 255},
+	"gold":                 {255, 215, 0, 255},
+	"goldenrod":            {218, 165, 32, 255},
+	"gray":                 {128, 128, 128, 255},
+	"green":                {0, 128, 0, 255},
+	"greenyellow":          {173, 255, 47, 255},
+	"grey":                 {128, 128, 128, 255},
+	"honeydew":             {240, 255, 240, 255},
+	"hotpink":              {255, 105, 180, 255},
+	"indianred":            {205, 92, 92, 255},
+	"indigo":               {75, 0, 130, 255},
+	"ivory":                {255, 255, 240, 255},
+	"khaki":                {240, 230, 140, 255},
+	"lavender":             {230, 230, 250, 255},
+	"lavenderblush":        {255, 240, 245, 255},
+	"lawngreen":            {124, 252, 0, 255},
+	"lemonchiffon":         {255, 250, 205, 255},
+	"lightblue":            {173, 216, 230, 255},
+	"lightcoral":           {240, 128, 128, 255},
+	"lightcyan":            {224, 255, 255, 255},
+	"lightgoldenrodyellow": {250, 250, 210, 255},
+	"lightgray":            {211, 211, 211, 255},
+	"lightgreen":           {144, 238, 144, 255},
+	"lightgrey":            {211, 211, 211, 255},
+	"lightpink":            {255, 182, 193, 255},
+	"lightsalmon":          {255, 160, 122, 255},
+	"lightseagreen":        {32, 178, 170, 255},
+	"lightskyblue":         {135, 206, 250, 255},
+	"lightslategray":       {119, 136, 153, 255},
+	"lightslategrey":       {119, 136, 153, 255},
+	"lightsteelblue":       {176, 196, 222, 255},
+	"lightyellow":          {255, 255, 224, 255},
+	"lime":                 {0, 255, 0, 255},
+	"limegreen":            {50, 205, 50, 255},
+	"linen":                {250, 240, 230, 255},
+	"magenta":              {255, 0, 255, 255},
+	"maroon":               {128, 0, 0, 255},
+	"mediumaquamarine":     {102, 205, 170, 255},
+	"mediumblue":           {0, 0, 205, 255},
+	"mediumorchid":         {186, 85, 211, 255},
+	"mediumpurple":         {147, 112, 219, 255},
+	"mediumseagreen":       {60, 179, 113, 255},
+	"mediumslateblue":      {123, 104, 238, 255},
+	"mediumspringgreen":    {0, 250, 154, 255},
+	"mediumturquoise":      {72, 209, 204, 255},
+	"mediumvioletred":      {199, 21, 133, 255},
+	"midnightblue":         {25, 25, 112, 255},
+	"mintcream":            {245, 255, 250, 255},
+	"mistyrose":            {255, 228, 225, 255},
+	"moccasin":             {255, 228, 181, 255},
+	"navajowhite":          {255, 222, 173, 255},
+	"navy":                 {0, 0, 128, 255},
+	"oldlace":              {253, 245, 230, 255},
+	"olive":                {128, 128, 0, 255},
+	"olivedrab":            {107, 142, 35, 255},
+	"orange":               {255, 165, 0, 255},
+	"orangered":            {255, 69, 0, 255},
+	"orchid":               {218, 112, 214, 255},
+	"palegoldenrod":        {238, 232, 170, 255},
+	"palegreen":            {152, 251, 152, 255},
+	"paleturquoise":        {175, 238, 238, 255},
+	"palevioletred":        {219, 112, 147, 255},
+	"papayawhip":           {255, 239, 213, 255},
+	"peachpuff":            {255, 218, 185, 255},
+	"peru":                 {205, 133, 63, 255},
+	"pink":                 {255, 192, 203, 255},
+	"plum":                 {221, 160, 221, 255},
+	"powderblue":           {176, 224, 230, 255},
+	"purple":               {128, 0, 128, 255},
+	"rebeccapurple":        {102, 51, 153, 255},
+	"red":                  {255, 0, 0, 255},
+	"rosybrown":            {188, 143, 143, 255},
+	"royalblue":            {65, 105, 225, 255},
+	"saddlebrown":          {139, 69, 19, 255},
+	"salmon":               {250, 128, 114, 255},
+	"sandybrown":           {244, 164, 96, 255},
+	"seagreen":             {46, 139, 87, 255},
+	"seashell":             {255, 245, 238, 255},
+	"sienna":               {160, 82, 45, 255},
+	"silver":               {192, 192, 192, 255},
+	"skyblue":              {135, 206, 235, 255},
+	"slateblue":            {106, 90, 205, 255},
+	"slategray":            {112, 128, 144, 255},
+	"slategrey":            {112, 128, 144, 255},
+	"snow":                 {255, 250, 250, 255},
+	"springgreen":          {0, 255, 127, 255},
+	"steelblue":            {70, 130, 180, 255},
+	"tan":                  {210, 180, 140, 255},
+	"teal":                 {0, 128, 128, 255},
+	"thistle":              {216, 191, 216, 255},
+	"tomato":               {255, 99, 71, 255},
+	"turquoise":            {64, 224, 208, 255},
+	"violet":               {238, 130, 238, 255},
+	"wheat":                {245, 222, 179, 255},
+	"white":                {255, 255, 255, 255},
+	"whitesmoke":           {245, 245, 245, 255},
+	"yellow":               {255, 255, 0, 255},
+	"yellowgreen":          {154, 205, 50, 255},
+}
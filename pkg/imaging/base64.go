@@ -0,0 +1,93 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// maxPooledBuffer keeps the occasional huge upload from pinning its buffer
+// in the pool.
+const maxPooledBuffer = 16 << 20
+
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// GetBuffer returns an empty buffer from a pool shared by request handlers;
+// return it with PutBuffer once nothing refers to its bytes.
+func GetBuffer() *bytes.Buffer { return bufferPool.Get().(*bytes.Buffer) }
+
+// PutBuffer returns b to the pool.
+func PutBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+// DecodeBase64 decodes standard or URL-safe base64, padded or not, with an
+// optional data: URL prefix and embedded whitespace, as browsers and mobile
+// clients send it.
+func DecodeBase64(s string) ([]byte, error) {
+	var b bytes.Buffer
+	if err := DecodeBase64Into(&b, s); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// DecodeBase64Into is DecodeBase64 appending to buf, typically one from
+// GetBuffer. It streams s through the decoder instead of building cleaned
+// copies of it.
+func DecodeBase64Into(buf *bytes.Buffer, s string) error {
+	s = strings.TrimSpace(s)
+	if len(s) >= 5 && strings.EqualFold(s[:5], "data:") {
+		if i := strings.IndexByte(s, ','); i != -1 {
+			s = s[i+1:]
+		}
+	}
+	buf.Grow(base64.RawStdEncoding.DecodedLen(len(s)))
+	if _, err := buf.ReadFrom(base64.NewDecoder(base64.RawStdEncoding, &base64Filter{s: s})); err != nil {
+		return fmt.Errorf("base64 decode failed: %w", err)
+	}
+	return nil
+}
+
+// base64Filter reads s with whitespace dropped, the URL-safe alphabet
+// mapped to the standard one and trailing padding removed.
+type base64Filter struct {
+	s      string
+	i      int
+	padded bool
+}
+
+func (f *base64Filter) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && f.i < len(f.s) {
+		c := f.s[f.i]
+		f.i++
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '=':
+			f.padded = true
+			continue
+		case '-':
+			c = '+'
+		case '_':
+			c = '/'
+		}
+		if f.padded {
+			return n, fmt.Errorf("data after padding at offset %d", f.i-1)
+		}
+		p[n] = c
+		n++
+	}
+	if n == 0 && f.i >= len(f.s) {
+		return 0, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,293 @@
+// Package imaging decodes submitted images and samples their pixels in
+// linear sRGB. Importing it registers the PNG, JPEG and GIF decoders.
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"io"
+	"math"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// Limits bound the dimensions DecodeWithin accepts; zero fields do not
+// limit.
+type Limits struct {
+	MaxWidth, MaxHeight int
+	MaxPixels           int
+}
+
+// DimensionError reports an image whose header declares dimensions beyond
+// Limits.
+type DimensionError struct {
+	Width, Height int
+	Limits        Limits
+}
+
+func (e *DimensionError) Error() string {
+	return fmt.Sprintf("image is %d×%d; at most %d×%d and %d pixels are accepted",
+		e.Width, e.Height, e.Limits.MaxWidth, e.Limits.MaxHeight, e.Limits.MaxPixels)
+}
+
+// Check reads only the image header, so a small file declaring a huge
+// canvas is rejected before any pixel memory is allocated.
+func (l Limits) Check(data []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if (l.MaxWidth > 0 && cfg.Width > l.MaxWidth) ||
+		(l.MaxHeight > 0 && cfg.Height > l.MaxHeight) ||
+		(l.MaxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > int64(l.MaxPixels)) {
+		return &DimensionError{Width: cfg.Width, Height: cfg.Height, Limits: l}
+	}
+	return nil
+}
+
+// DecodeWithin is Decode for untrusted input: it fails with a
+// *DimensionError for images beyond l.
+func DecodeWithin(data []byte, l Limits) (image.Image, string, error) {
+	return DecodeWithinContext(context.Background(), data, l)
+}
+
+// DecodeWithinContext is DecodeWithin that gives up with ctx's error once
+// ctx is done, rather than decoding a large image nobody waits for.
+func DecodeWithinContext(ctx context.Context, data []byte, l Limits) (image.Image, string, error) {
+	if err := l.Check(data); err != nil {
+		return nil, "", err
+	}
+	return decode(ctx, data)
+}
+
+// Decode decodes a PNG, JPEG or GIF image and reports its format name.
+// JPEGs go through DecodeJPEG and PNGs through DecodePNG.
+func Decode(data []byte) (image.Image, string, error) {
+	return decode(context.Background(), data)
+}
+
+func decode(ctx context.Context, data []byte) (image.Image, string, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegSOI):
+		img, _, err := decodeJPEG(ctx, data)
+		return img, "jpeg", err
+	case bytes.HasPrefix(data, pngSignature):
+		img, _, err := decodePNG(ctx, data)
+		return img, "png", err
+	}
+	return image.Decode(newReader(ctx, data))
+}
+
+// ctxReader reads from memory until its context is done. The decoders
+// read their input a few kilobytes at a time as they go, so it stops them
+// soon after.
+type ctxReader struct {
+	ctx context.Context
+	r   *bytes.Reader
+}
+
+func (r ctxReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
+
+// newReader reads data, stopping once ctx is done; a context that is never
+// done gets a plain reader.
+func newReader(ctx context.Context, data []byte) io.Reader {
+	if ctx.Done() == nil {
+		return bytes.NewReader(data)
+	}
+	return ctxReader{ctx, bytes.NewReader(data)}
+}
+
+// Sample is one sampled pixel in linear sRGB. W weighs it in averages; A
+// is the pixel's alpha.
+type Sample struct {
+	R, G, B, W float64
+	A          float64
+}
+
+// DefaultMaxSamples is the sampling budget used when none is given.
+const DefaultMaxSamples = 4096
+
+// SampleLinearRGB samples img on a regular grid of about maxSamples pixels;
+// maxSamples <= 0 means DefaultMaxSamples. Colors are premultiplied by alpha
+// and weighted by it, so transparent pixels count for nothing.
+//
+// seed places the grid: 0 starts it at the top-left pixel and any other
+// value at a pixel of the first grid cell derived from it. The same seed
+// always reads the same pixels, while different seeds read different ones,
+// so scores averaged over several seeds do not hinge on one grid.
+func SampleLinearRGB(img image.Image, maxSamples int, seed uint64) []Sample {
+	s, _ := SampleLinearRGBContext(context.Background(), img, maxSamples, seed)
+	return s
+}
+
+// SampleLinearRGBContext is SampleLinearRGB that stops with ctx's error
+// once ctx is done, as do the other Context variants; large sampling
+// budgets read millions of pixels.
+func SampleLinearRGBContext(ctx context.Context, img image.Image, maxSamples int, seed uint64) ([]Sample, error) {
+	return sampleGrid(ctx, img, maxSamples, seed, func(r, g, b, a uint32) Sample {
+		wa := unit16(a)
+		return Sample{R: lin16(r), G: lin16(g), B: lin16(b), W: wa, A: wa}
+	})
+}
+
+// SampleOver is SampleLinearRGB with img composited over the sRGB color
+// bg, as a browser would display it; every sample has weight 1.
+func SampleOver(img image.Image, maxSamples int, seed uint64, bg colormath.Vec3) []Sample {
+	s, _ := SampleOverContext(context.Background(), img, maxSamples, seed, bg)
+	return s
+}
+
+// SampleOverContext is SampleOver that stops once ctx is done.
+func SampleOverContext(ctx context.Context, img image.Image, maxSamples int, seed uint64, bg colormath.Vec3) ([]Sample, error) {
+	return sampleGrid(ctx, img, maxSamples, seed, func(r, g, b, a16 uint32) Sample {
+		if a16 == 0xffff {
+			return Sample{R: lin16(r), G: lin16(g), B: lin16(b), W: 1, A: 1}
+		}
+		sr, sg, sb, a := unit16(r), unit16(g), unit16(b), unit16(a16)
+		return Sample{
+			R: colormath.SRGBToLinear(sr + bg[0]*(1-a)),
+			G: colormath.SRGBToLinear(sg + bg[1]*(1-a)),
+			B: colormath.SRGBToLinear(sb + bg[2]*(1-a)),
+			W: 1,
+			A: a,
+		}
+	})
+}
+
+// SampleOpaque is SampleLinearRGB with colors un-premultiplied and pixels
+// less than minAlpha opaque left out (weight 0); the rest have weight 1.
+func SampleOpaque(img image.Image, maxSamples int, seed uint64, minAlpha float64) []Sample {
+	s, _ := SampleOpaqueContext(context.Background(), img, maxSamples, seed, minAlpha)
+	return s
+}
+
+// SampleOpaqueContext is SampleOpaque that stops once ctx is done.
+func SampleOpaqueContext(ctx context.Context, img image.Image, maxSamples int, seed uint64, minAlpha float64) ([]Sample, error) {
+	return sampleGrid(ctx, img, maxSamples, seed, func(r, g, b, a16 uint32) Sample {
+		a := unit16(a16)
+		if a == 0 || a < minAlpha {
+			return Sample{A: a}
+		}
+		if a16 == 0xffff {
+			return Sample{R: lin16(r), G: lin16(g), B: lin16(b), W: 1, A: 1}
+		}
+		sr, sg, sb := unit16(r), unit16(g), unit16(b)
+		return Sample{R: colormath.SRGBToLinear(sr / a), G: colormath.SRGBToLinear(sg / a), B: colormath.SRGBToLinear(sb / a), W: 1, A: a}
+	})
+}
+
+// GridSize is the shape of the grid the Sample functions use for an image
+// with bounds b: they return cols×rows samples in row-major order.
+func GridSize(b image.Rectangle, maxSamples int, seed uint64) (cols, rows int) {
+	g := newGrid(b, maxSamples, seed)
+	return g.cols, g.rows
+}
+
+// grid is every step-th pixel of every step-th row, from (x0, y0) relative
+// to the image's origin.
+type grid struct {
+	step, x0, y0 int
+	cols, rows   int
+}
+
+func newGrid(b image.Rectangle, maxSamples int, seed uint64) grid {
+	if maxSamples <= 0 {
+		maxSamples = DefaultMaxSamples
+	}
+	g := grid{step: int(math.Max(1, math.Sqrt(float64(b.Dx()*b.Dy()/maxSamples))))}
+	if seed != 0 && b.Dx() > 0 && b.Dy() > 0 {
+		h := splitmix64(seed)
+		g.x0 = int(h % uint64(min(g.step, b.Dx())))
+		g.y0 = int((h >> 32) % uint64(min(g.step, b.Dy())))
+	}
+	g.cols = (b.Dx() - g.x0 + g.step - 1) / g.step
+	g.rows = (b.Dy() - g.y0 + g.step - 1) / g.step
+	return g
+}
+
+// splitmix64 scrambles a seed, so nearby seeds give unrelated offsets.
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// sampleGrid calls f with the premultiplied 16-bit sRGB color and alpha of
+// each grid pixel. Opaque pixels can then be linearized by table lookup.
+// ctx is checked once per grid row.
+func sampleGrid(ctx context.Context, img image.Image, maxSamples int, seed uint64, f func(r, g, b, a uint32) Sample) ([]Sample, error) {
+	b := img.Bounds()
+	g := newGrid(b, maxSamples, seed)
+	out := make([]Sample, 0, g.cols*g.rows)
+
+	at := PixelReader(img)
+	for y := b.Min.Y + g.y0; y < b.Max.Y; y += g.step {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for x := b.Min.X + g.x0; x < b.Max.X; x += g.step {
+			out = append(out, f(at(x, y)))
+		}
+	}
+	return out, nil
+}
+
+// SampleMask samples mask on the grid the Sample functions use for an image
+// with bounds b and the same seed, as weights in [0, 1]: the mask's gray
+// level, so white selects a pixel and black or transparent leaves it out.
+// mask must have b's size.
+func SampleMask(mask image.Image, b image.Rectangle, maxSamples int, seed uint64) []float64 {
+	w, _ := SampleMaskContext(context.Background(), mask, b, maxSamples, seed)
+	return w
+}
+
+// SampleMaskContext is SampleMask that stops once ctx is done.
+func SampleMaskContext(ctx context.Context, mask image.Image, b image.Rectangle, maxSamples int, seed uint64) ([]float64, error) {
+	g := newGrid(b, maxSamples, seed)
+	out := make([]float64, 0, g.cols*g.rows)
+
+	mb := mask.Bounds()
+	at := PixelReader(mask)
+	for y := g.y0; y < b.Dy(); y += g.step {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for x := g.x0; x < b.Dx(); x += g.step {
+			cr, cg, cb, _ := at(mb.Min.X+x, mb.Min.Y+y)
+			out = append(out, 0.299*unit16(cr)+0.587*unit16(cg)+0.114*unit16(cb))
+		}
+	}
+	return out, nil
+}
+
+// unit16 scales a 16-bit channel to [0, 1].
+func unit16(v uint32) float64 { return float64(v) / 65535.0 }
+
+// lin16 linearizes a 16-bit sRGB channel.
+func lin16(v uint32) float64 { return colormath.SRGB16ToLinear(uint16(v)) }
+
+// AverageLinearRGB is the alpha-weighted mean of samples; black when all are
+// transparent.
+func AverageLinearRGB(samples []Sample) colormath.Vec3 {
+	var sumR, sumG, sumB, sumW float64
+	for _, s := range samples {
+		sumR += s.R * s.W
+		sumG += s.G * s.W
+		sumB += s.B * s.W
+		sumW += s.W
+	}
+	if sumW == 0 {
+		return colormath.Vec3{}
+	}
+	return colormath.Vec3{sumR / sumW, sumG / sumW, sumB / sumW}
+}
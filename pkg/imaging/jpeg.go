@@ -0,0 +1,228 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// JPEGInfo describes a JPEG as read from its markers, and what DecodeJPEG
+// had to do to decode it.
+type JPEGInfo struct {
+	Process       string `json:"process" doc:"baseline, extended, progressive, lossless, hierarchical or arithmetic."`
+	Precision     int    `json:"precision" doc:"Bits per sample."`
+	Components    int    `json:"components"`
+	ColorModel    string `json:"color_model" doc:"gray, ycbcr, rgb, cmyk or ycck."`
+	Adobe         bool   `json:"adobe" doc:"Whether the file has an Adobe APP14 segment."`
+	Scans         int    `json:"scans"`
+	ScansUsed     int    `json:"scans_used" doc:"Fewer than scans when incomplete trailing scans of a progressive JPEG were dropped."`
+	AssumedCMYK   bool   `json:"assumed_cmyk,omitempty" doc:"Four components without an Adobe segment, decoded as uninverted CMYK."`
+	ConvertedCMYK bool   `json:"converted_cmyk,omitempty" doc:"CMYK or YCCK pixels converted to sRGB."`
+	Orientation   int    `json:"orientation,omitempty" doc:"EXIF orientation, 1 to 8, when the file has one; images are stored unrotated."`
+}
+
+var jpegSOI = []byte{0xff, 0xd8}
+
+// adobeAPP14 is an Adobe segment with transform 0 (no color transform).
+var adobeAPP14 = []byte{0xff, 0xee, 0x00, 0x0e, 'A', 'd', 'o', 'b', 'e', 0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+// maxJPEGScans is the most scans a JPEG may have, well past the dozen or so
+// encoders write. The standard decoder redoes its progressive work for
+// every scan, so a file of many tiny scans costs far more than its size.
+const maxJPEGScans = 100
+
+var errTooManyScans = fmt.Errorf("jpeg: more than %d scans", maxJPEGScans)
+
+// InspectJPEG reads the markers of a JPEG without decoding its pixels. It
+// fails if data does not start like a JPEG, has no frame header or has
+// more than maxJPEGScans scans.
+func InspectJPEG(data []byte) (JPEGInfo, error) {
+	info, _, err := inspectJPEG(data)
+	return info, err
+}
+
+// inspectJPEG also returns the offset of each scan's SOS marker. It stops
+// reading at the scan past maxJPEGScans.
+func inspectJPEG(data []byte) (JPEGInfo, []int, error) {
+	var info JPEGInfo
+	var scans []int
+	if !bytes.HasPrefix(data, jpegSOI) {
+		return info, nil, errors.New("not a JPEG")
+	}
+	transform := -1
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			i++ // entropy-coded data, or garbage between segments
+			continue
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff, marker == 0x00, marker >= 0xd0 && marker <= 0xd7:
+			i++ // fill byte, stuffed zero or restart marker
+			continue
+		case marker == 0xd9:
+			i = len(data)
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 {
+			break
+		}
+		seg := data[i+4 : min(len(data), i+2+n)]
+		switch marker {
+		case 0xc0, 0xc1, 0xc2, 0xc3, 0xc5, 0xc6, 0xc7, 0xc9, 0xca, 0xcb, 0xcd, 0xce, 0xcf:
+			if len(seg) >= 6 {
+				info.Precision, info.Components = int(seg[0]), int(seg[5])
+			}
+			switch marker {
+			case 0xc0:
+				info.Process = "baseline"
+			case 0xc1:
+				info.Process = "extended"
+			case 0xc2:
+				info.Process = "progressive"
+			case 0xc3:
+				info.Process = "lossless"
+			case 0xc5, 0xc6, 0xc7:
+				info.Process = "hierarchical"
+			default:
+				info.Process = "arithmetic"
+			}
+		case 0xe1:
+			if o := exifOrientation(seg); o != 0 && info.Orientation == 0 {
+				info.Orientation = o
+			}
+		case 0xee:
+			if len(seg) >= 12 && bytes.HasPrefix(seg, []byte("Adobe")) {
+				info.Adobe, transform = true, int(seg[11])
+			}
+		case 0xda:
+			scans = append(scans, i)
+		}
+		if len(scans) > maxJPEGScans {
+			break
+		}
+		i += 2 + n
+	}
+	if info.Process == "" {
+		return info, nil, errors.New("jpeg: no frame header")
+	}
+	info.Scans = len(scans)
+	if len(scans) > maxJPEGScans {
+		return info, nil, errTooManyScans
+	}
+
+	switch info.Components {
+	case 1:
+		info.ColorModel = "gray"
+	case 3:
+		info.ColorModel = "ycbcr"
+		if transform == 0 {
+			info.ColorModel = "rgb"
+		}
+	case 4:
+		info.ColorModel = "cmyk"
+		if transform == 2 {
+			info.ColorModel = "ycck"
+		}
+	}
+	return info, scans, nil
+}
+
+// DecodeJPEG decodes a JPEG, working around two kinds of files the
+// standard decoder rejects or gets wrong:
+//
+//   - CMYK without an Adobe segment, which it refuses; these are decoded
+//     as uninverted CMYK, as libjpeg does.
+//   - Progressive JPEGs cut off mid-scan, as failed uploads often are;
+//     incomplete trailing scans are dropped, leaving a blurrier image whose
+//     colors are still right.
+//
+// CMYK and YCCK pixels are converted to sRGB with colormath.CMYKToSRGB
+// rather than the standard library's naive conversion, which renders
+// Photoshop's CMYK exports too dark.
+func DecodeJPEG(data []byte) (image.Image, JPEGInfo, error) {
+	return decodeJPEG(context.Background(), data)
+}
+
+func decodeJPEG(ctx context.Context, data []byte) (image.Image, JPEGInfo, error) {
+	info, scans, err := inspectJPEG(data)
+	if errors.Is(err, errTooManyScans) {
+		return nil, info, err
+	}
+	if err != nil {
+		img, err := jpeg.Decode(newReader(ctx, data))
+		return img, info, err
+	}
+	input := data
+	if info.Components == 4 && !info.Adobe {
+		input = append(append(append([]byte{}, jpegSOI...), adobeAPP14...), data[2:]...)
+		info.AssumedCMYK = true
+		for i := range scans {
+			scans[i] += len(adobeAPP14)
+		}
+	}
+	img, err := jpeg.Decode(newReader(ctx, input))
+	info.ScansUsed = info.Scans
+	if err != nil && info.Process == "progressive" {
+		// A cut-off file breaks only its last scan, so all but that are
+		// tried first; failing that, the most scans that decode are found
+		// by binary search, each decode costing as much as the scans it
+		// keeps. The first scan is kept even if it is the broken one, so
+		// the original error is reported.
+		for lo, hi, n := 0, len(scans)-1, len(scans)-1; lo < hi && ctx.Err() == nil; n = (lo + hi + 1) / 2 {
+			cut := append(input[:scans[n]:scans[n]], 0xff, 0xd9)
+			if m, err2 := jpeg.Decode(newReader(ctx, cut)); err2 == nil {
+				img, err, info.ScansUsed = m, nil, n
+				lo = n
+			} else {
+				hi = n - 1
+			}
+		}
+	}
+	if err != nil {
+		return nil, info, err
+	}
+	if c, ok := img.(*image.CMYK); ok {
+		if info.AssumedCMYK {
+			// The decoder inverted the inks as Adobe CMYK; undo it.
+			for i := range c.Pix {
+				c.Pix[i] = 255 - c.Pix[i]
+			}
+		}
+		img, info.ConvertedCMYK = swopImage{c}, true
+	}
+	return img, info, nil
+}
+
+// swopImage presents a CMYK image as sRGB, converting pixels as they are
+// read since scoring samples only a few thousand of them. It holds the
+// image rather than embedding it, so none of image.CMYK's naive
+// conversions, such as RGBA64At, leak through.
+type swopImage struct {
+	c *image.CMYK
+}
+
+func (swopImage) ColorModel() color.Model { return color.RGBAModel }
+
+func (s swopImage) Bounds() image.Rectangle { return s.c.Bounds() }
+
+func (s swopImage) At(x, y int) color.Color { return s.rgba(x, y) }
+
+func (s swopImage) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := s.rgba(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+func (s swopImage) rgba(x, y int) color.RGBA {
+	c := s.c.CMYKAt(x, y)
+	r, g, b := colormath.CMYKToSRGB(float64(c.C)/255, float64(c.M)/255, float64(c.Y)/255, float64(c.K)/255)
+	return color.RGBA{uint8(r*255 + 0.5), uint8(g*255 + 0.5), uint8(b*255 + 0.5), 255}
+}
@@ -0,0 +1,223 @@
+package imaging
+
+import (
+	"cmp"
+	"image"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// Palette sizes for ExtractPalette.
+const (
+	DefaultPaletteSize = 5
+	MaxPaletteSize     = 16
+)
+
+// PaletteColor is one palette entry; Proportion is its share of the
+// image by alpha-weighted sample count.
+type PaletteColor struct {
+	Hex        string  `json:"hex"`
+	Proportion float64 `json:"proportion"`
+}
+
+// paletteBox is a set of points with its weight and widest channel, which
+// every split round looks at, computed once.
+type paletteBox struct {
+	pts    []palettePoint
+	w      float64
+	axis   int
+	spread float64
+}
+
+type palettePoint struct {
+	srgb colormath.Vec3 // gamma-encoded, where box splits are more perceptually even
+	lin  colormath.Vec3
+	w    float64
+}
+
+func newPaletteBox(pts []palettePoint) *paletteBox {
+	b := &paletteBox{pts: pts}
+	lo, hi := colormath.Vec3{1, 1, 1}, colormath.Vec3{}
+	for _, p := range pts {
+		b.w += p.w
+		for c := 0; c < 3; c++ {
+			lo[c] = min(lo[c], p.srgb[c])
+			hi[c] = max(hi[c], p.srgb[c])
+		}
+	}
+	for c := 1; c < 3; c++ {
+		if hi[c]-lo[c] > hi[b.axis]-lo[b.axis] {
+			b.axis = c
+		}
+	}
+	b.spread = hi[b.axis] - lo[b.axis]
+	return b
+}
+
+func (b *paletteBox) weight() float64 { return b.w }
+
+// widest returns the channel with the largest spread and that spread.
+func (b *paletteBox) widest() (int, float64) { return b.axis, b.spread }
+
+// ExtractPalette returns up to n representative colors of img by median cut
+// over the sampled pixels, ordered by descending proportion. Colors are
+// averaged in linear light.
+func ExtractPalette(img image.Image, n, maxSamples int) []PaletteColor {
+	if n <= 0 {
+		n = DefaultPaletteSize
+	}
+	clusters := Cluster(SampleLinearRGB(img, maxSamples, 0), min(n, MaxPaletteSize))
+	out := make([]PaletteColor, len(clusters))
+	for i, c := range clusters {
+		out[i] = PaletteColor{Hex: colormath.LinearHex(c.Mean[0], c.Mean[1], c.Mean[2]), Proportion: c.Share}
+	}
+	return out
+}
+
+// A SampleCluster is a group of similar samples: their weighted mean in
+// linear sRGB and their share of the total weight.
+type SampleCluster struct {
+	Mean  colormath.Vec3
+	Share float64
+}
+
+// Cluster groups samples into at most n clusters by median cut, ordered by
+// descending share. Samples with weight 0 are left out.
+func Cluster(samples []Sample, n int) []SampleCluster {
+	var pts []palettePoint
+	for _, s := range samples {
+		if s.W == 0 {
+			continue
+		}
+		pts = append(pts, palettePoint{
+			srgb: colormath.Vec3{colormath.LinearToSRGB(s.R), colormath.LinearToSRGB(s.G), colormath.LinearToSRGB(s.B)},
+			lin:  colormath.Vec3{s.R, s.G, s.B},
+			w:    s.W,
+		})
+	}
+	if len(pts) == 0 {
+		return []SampleCluster{}
+	}
+
+	boxes := []*paletteBox{newPaletteBox(pts)}
+	for len(boxes) < n {
+		// Split the box whose widest channel spread, scaled by its weight,
+		// is largest, so big uniform regions are not split needlessly.
+		best, bestScore := -1, 0.0
+		for i, b := range boxes {
+			if len(b.pts) < 2 {
+				continue
+			}
+			_, spread := b.widest()
+			if s := spread * b.weight(); s > bestScore {
+				best, bestScore = i, s
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b := boxes[best]
+		axis, _ := b.widest()
+		slices.SortFunc(b.pts, func(p, q palettePoint) int { return cmp.Compare(p.srgb[axis], q.srgb[axis]) })
+		half, acc, cut := b.weight()/2, 0.0, 1
+		for i, p := range b.pts {
+			acc += p.w
+			if acc >= half {
+				cut = min(max(i+1, 1), len(b.pts)-1)
+				break
+			}
+		}
+		// Move the cut off runs of equal values so one color never ends up
+		// in both halves; the spread check above guarantees a boundary.
+		at := func(i int) float64 { return b.pts[i].srgb[axis] }
+		lo, hi := cut, cut
+		for lo > 0 && at(lo) == at(lo-1) {
+			lo--
+		}
+		for hi < len(b.pts) && at(hi) == at(hi-1) {
+			hi++
+		}
+		if lo > 0 && (hi == len(b.pts) || cut-lo <= hi-cut) {
+			cut = lo
+		} else {
+			cut = hi
+		}
+		boxes[best] = newPaletteBox(b.pts[:cut])
+		boxes = append(boxes, newPaletteBox(b.pts[cut:]))
+	}
+
+	var total float64
+	for _, b := range boxes {
+		total += b.weight()
+	}
+	out := make([]SampleCluster, 0, len(boxes))
+	for _, b := range boxes {
+		var sum colormath.Vec3
+		w := b.weight()
+		for _, p := range b.pts {
+			for c := 0; c < 3; c++ {
+				sum[c] += p.lin[c] * p.w
+			}
+		}
+		out = append(out, SampleCluster{
+			Mean:  colormath.Vec3{sum[0] / w, sum[1] / w, sum[2] / w},
+			Share: w / total,
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Share > out[j].Share })
+	return out
+}
+
+// Reassign refines clusters over rounds of moving each sample with weight
+// to the cluster whose mean is nearest in Oklab, since median cut splits
+// boxes at the weighted median whatever the colors, and recomputing the
+// means and shares. Clusters left empty are dropped; the rest are ordered
+// by descending share.
+func Reassign(samples []Sample, clusters []SampleCluster, rounds int) []SampleCluster {
+	type point struct {
+		lab, lin colormath.Vec3
+		w        float64
+	}
+	var pts []point
+	for _, s := range samples {
+		if s.W > 0 {
+			pts = append(pts, point{colormath.LinearToOklab(s.R, s.G, s.B), colormath.Vec3{s.R, s.G, s.B}, s.W})
+		}
+	}
+	for range rounds {
+		centers := make([]colormath.Vec3, len(clusters))
+		for i, c := range clusters {
+			centers[i] = colormath.LinearToOklab(c.Mean[0], c.Mean[1], c.Mean[2])
+		}
+		sums := make([]colormath.Vec3, len(clusters))
+		weights := make([]float64, len(clusters))
+		var total float64
+		for _, p := range pts {
+			best, bestD := 0, math.Inf(1)
+			for i, c := range centers {
+				if d := colormath.Euclid(p.lab, c); d < bestD {
+					best, bestD = i, d
+				}
+			}
+			for c := 0; c < 3; c++ {
+				sums[best][c] += p.lin[c] * p.w
+			}
+			weights[best] += p.w
+			total += p.w
+		}
+		clusters = make([]SampleCluster, 0, len(weights))
+		for i, w := range weights {
+			if w > 0 {
+				clusters = append(clusters, SampleCluster{
+					Mean:  colormath.Vec3{sums[i][0] / w, sums[i][1] / w, sums[i][2] / w},
+					Share: w / total,
+				})
+			}
+		}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].Share > clusters[j].Share })
+	return clusters
+}
@@ -0,0 +1,51 @@
+package imaging
+
+import (
+	"image"
+	"math/bits"
+)
+
+// dhashSamples is how many pixels along each side of a hash cell are
+// averaged, enough to smooth noise without reading the whole image.
+const dhashSamples = 8
+
+// DHash returns img's 64-bit difference hash: the image is shrunk to 9×8
+// cells of luma and each bit records whether a cell is brighter than its
+// right neighbour, row by row. Re-encoding, resizing and light edits flip
+// few bits, so copies of one photo are within a small HashDistance of each
+// other while unrelated photos differ in about half the bits.
+func DHash(img image.Image) uint64 {
+	b := img.Bounds()
+	if b.Empty() {
+		return 0
+	}
+	px := PixelReader(img)
+	var cells [8][9]float64
+	for cy := range 8 {
+		for cx := range 9 {
+			var sum float64
+			for sy := range dhashSamples {
+				y := b.Min.Y + (2*(cy*dhashSamples+sy)+1)*b.Dy()/(2*8*dhashSamples)
+				for sx := range dhashSamples {
+					x := b.Min.X + (2*(cx*dhashSamples+sx)+1)*b.Dx()/(2*9*dhashSamples)
+					r, g, bl, _ := px(x, y)
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+				}
+			}
+			cells[cy][cx] = sum
+		}
+	}
+	var h uint64
+	for cy := range 8 {
+		for cx := range 8 {
+			h <<= 1
+			if cells[cy][cx] > cells[cy][cx+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// HashDistance is the number of bits in which two DHash values differ.
+func HashDistance(a, b uint64) int { return bits.OnesCount64(a ^ b) }
@@ -0,0 +1,57 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+// PixelReader returns a reader of img's premultiplied 16-bit RGBA, the same
+// values as img.At(x, y).RGBA(). The common decoder outputs are read
+// straight from their pixel buffers, skipping At's interface call and
+// color.Color allocation per pixel; the 16-bit ones keep every bit.
+func PixelReader(img image.Image) func(x, y int) (r, g, b, a uint32) {
+	switch m := img.(type) {
+	case *image.RGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			s := m.Pix[m.PixOffset(x, y):]
+			return uint32(s[0]) * 0x101, uint32(s[1]) * 0x101, uint32(s[2]) * 0x101, uint32(s[3]) * 0x101
+		}
+	case *image.NRGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			s := m.Pix[m.PixOffset(x, y):]
+			a = uint32(s[3]) * 0x101
+			return uint32(s[0]) * 0x101 * a / 0xffff, uint32(s[1]) * 0x101 * a / 0xffff, uint32(s[2]) * 0x101 * a / 0xffff, a
+		}
+	case *image.RGBA64:
+		return func(x, y int) (r, g, b, a uint32) {
+			s := m.Pix[m.PixOffset(x, y):]
+			return be16(s[0:]), be16(s[2:]), be16(s[4:]), be16(s[6:])
+		}
+	case *image.NRGBA64:
+		return func(x, y int) (r, g, b, a uint32) {
+			s := m.Pix[m.PixOffset(x, y):]
+			a = be16(s[6:])
+			return be16(s[0:]) * a / 0xffff, be16(s[2:]) * a / 0xffff, be16(s[4:]) * a / 0xffff, a
+		}
+	case *image.Gray:
+		return func(x, y int) (r, g, b, a uint32) {
+			v := uint32(m.Pix[m.PixOffset(x, y)]) * 0x101
+			return v, v, v, 0xffff
+		}
+	case *image.Gray16:
+		return func(x, y int) (r, g, b, a uint32) {
+			v := be16(m.Pix[m.PixOffset(x, y):])
+			return v, v, v, 0xffff
+		}
+	case *image.YCbCr:
+		return func(x, y int) (r, g, b, a uint32) {
+			yi, ci := m.YOffset(x, y), m.COffset(x, y)
+			return color.YCbCr{Y: m.Y[yi], Cb: m.Cb[ci], Cr: m.Cr[ci]}.RGBA()
+		}
+	}
+	return func(x, y int) (r, g, b, a uint32) { return img.At(x, y).RGBA() }
+}
+
+// be16 reads a big-endian 16-bit channel, as the 16-bit image types store
+// them.
+func be16(s []byte) uint32 { return uint32(s[0])<<8 | uint32(s[1]) }
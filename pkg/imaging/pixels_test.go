@@ -0,0 +1,101 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// atOnly hides an image's concrete type, forcing the generic At path.
+type atOnly struct{ image.Image }
+
+// testImages returns a noise image of each type the decoders produce.
+func testImages(w, h int) []struct {
+	name string
+	img  image.Image
+} {
+	rect := image.Rect(0, 0, w, h)
+	rng := rand.New(rand.NewSource(1))
+	nrgba := image.NewNRGBA(rect)
+	rng.Read(nrgba.Pix)
+	rgba := image.NewRGBA(rect)
+	draw.Draw(rgba, rect, nrgba, image.Point{}, draw.Src)
+	nrgba64 := image.NewNRGBA64(rect)
+	rng.Read(nrgba64.Pix)
+	rgba64 := image.NewRGBA64(rect)
+	draw.Draw(rgba64, rect, nrgba64, image.Point{}, draw.Src)
+	gray := image.NewGray(rect)
+	rng.Read(gray.Pix)
+	gray16 := image.NewGray16(rect)
+	rng.Read(gray16.Pix)
+	ycc := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	rng.Read(ycc.Y)
+	rng.Read(ycc.Cb)
+	rng.Read(ycc.Cr)
+	return []struct {
+		name string
+		img  image.Image
+	}{
+		{"RGBA", rgba}, {"NRGBA", nrgba}, {"RGBA64", rgba64}, {"NRGBA64", nrgba64},
+		{"Gray", gray}, {"Gray16", gray16}, {"YCbCr", ycc},
+	}
+}
+
+func TestPixelReader(t *testing.T) {
+	for _, c := range testImages(33, 17) {
+		at := PixelReader(c.img)
+		b := c.img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, a := at(x, y)
+				wr, wg, wb, wa := c.img.At(x, y).RGBA()
+				if r != wr || g != wg || bl != wb || a != wa {
+					t.Fatalf("%s at (%d, %d) = %d %d %d %d, At gives %d %d %d %d", c.name, x, y, r, g, bl, a, wr, wg, wb, wa)
+				}
+			}
+		}
+	}
+}
+
+// sink keeps the benchmarks' loops from being optimized away.
+var sink uint32
+
+// readPixels reads every pixel of img and nothing more.
+func readPixels(img image.Image) {
+	at := PixelReader(img)
+	b := img.Bounds()
+	var sum uint32
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := at(x, y)
+			sum += r + g + bl + a
+		}
+	}
+	sink = sum
+}
+
+// benchmarkScan runs scan over a full HD image of each type, once through
+// its direct pixel path and once behind atOnly.
+func benchmarkScan(b *testing.B, scan func(image.Image)) {
+	for _, c := range testImages(1920, 1080) {
+		for _, path := range []struct {
+			name string
+			img  image.Image
+		}{{"direct", c.img}, {"At", atOnly{c.img}}} {
+			b.Run(c.name+"/"+path.name, func(b *testing.B) {
+				for range b.N {
+					scan(path.img)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkPixelReader times the pixel reads alone.
+func BenchmarkPixelReader(b *testing.B) { benchmarkScan(b, readPixels) }
+
+// BenchmarkSampleLinearRGB times a full-resolution sample of every pixel.
+func BenchmarkSampleLinearRGB(b *testing.B) {
+	benchmarkScan(b, func(img image.Image) { SampleLinearRGB(img, 1920*1080, 0) })
+}
@@ -0,0 +1,148 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// PNGInfo describes a PNG as read from its chunks, and what DecodePNG did
+// to its pixels.
+type PNGInfo struct {
+	BitDepth       int     `json:"bit_depth" doc:"Bits per sample: 1, 2, 4, 8 or 16."`
+	ColorType      string  `json:"color_type" doc:"gray, rgb, palette, gray_alpha or rgba."`
+	Interlaced     bool    `json:"interlaced"`
+	Gamma          float64 `json:"gamma,omitempty" doc:"File gamma from the gAMA chunk, such as 0.45455; 0 without one."`
+	SRGB           bool    `json:"srgb,omitempty" doc:"Whether the file has an sRGB chunk, which overrides gAMA."`
+	ICCProfile     bool    `json:"icc_profile,omitempty" doc:"Whether the file has an iCCP chunk; profiles are not applied."`
+	GammaCorrected bool    `json:"gamma_corrected,omitempty" doc:"Pixels converted from the gAMA gamma to sRGB."`
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var pngColorTypes = map[byte]string{0: "gray", 2: "rgb", 3: "palette", 4: "gray_alpha", 6: "rgba"}
+
+// InspectPNG reads the chunks of a PNG before its image data, without
+// decoding its pixels. It fails only if data does not start like a PNG or
+// has no header chunk.
+func InspectPNG(data []byte) (PNGInfo, error) {
+	var info PNGInfo
+	if !bytes.HasPrefix(data, pngSignature) {
+		return info, errors.New("not a PNG")
+	}
+	header := false
+	for i := len(pngSignature); i+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		kind := string(data[i+4 : i+8])
+		body := data[i+8:]
+		if n > len(body) {
+			break // truncated; the decoder reports it
+		}
+		body = body[:n]
+		switch kind {
+		case "IHDR":
+			if n < 13 {
+				return info, errors.New("short IHDR chunk")
+			}
+			header = true
+			info.BitDepth = int(body[8])
+			info.ColorType = pngColorTypes[body[9]]
+			info.Interlaced = body[12] == 1
+		case "gAMA":
+			if n == 4 {
+				info.Gamma = float64(binary.BigEndian.Uint32(body)) / 100000
+			}
+		case "sRGB":
+			info.SRGB = true
+		case "iCCP":
+			info.ICCProfile = true
+		case "IDAT", "IEND":
+			i = len(data)
+			continue
+		}
+		i += 12 + n
+	}
+	if !header {
+		return info, errors.New("no IHDR chunk")
+	}
+	return info, nil
+}
+
+// sRGBGamma is the gAMA value of a plain sRGB file. Encoders write it
+// alongside an sRGB chunk or on its own; either way the pixels are sRGB,
+// which a pure power curve of this gamma only approximates.
+const sRGBGamma = 0.45455
+
+// needsGamma reports whether pixels must be converted from info's gamma:
+// only a gAMA chunk other than sRGB's, with no sRGB chunk overriding it.
+// An ICC profile does not stop it, as the spec has decoders that cannot
+// apply profiles fall back to gAMA.
+func (info PNGInfo) needsGamma() bool {
+	return info.Gamma > 0 && !info.SRGB && math.Abs(info.Gamma-sRGBGamma) > 0.005
+}
+
+// DecodePNG decodes a PNG. Bit depths up to 16 are kept: 16-bit files
+// decode to 16 bits per channel, and the Sample functions linearize those
+// without rounding to 8 bits. A file whose gAMA chunk declares a gamma
+// other than sRGB's, as linear renders and some scanners write, is
+// converted to 16-bit sRGB, so it is scored as a browser displays it.
+func DecodePNG(data []byte) (image.Image, PNGInfo, error) {
+	return decodePNG(context.Background(), data)
+}
+
+func decodePNG(ctx context.Context, data []byte) (image.Image, PNGInfo, error) {
+	info, err := InspectPNG(data)
+	if err != nil {
+		img, err := png.Decode(newReader(ctx, data))
+		return img, info, err
+	}
+	img, err := png.Decode(newReader(ctx, data))
+	if err != nil {
+		return nil, info, err
+	}
+	if info.needsGamma() {
+		if img, err = convertGamma(ctx, img, info.Gamma); err != nil {
+			return nil, info, err
+		}
+		info.GammaCorrected = true
+	}
+	return img, info, nil
+}
+
+// gammaTable maps 16-bit channels encoded with the file gamma g to 16-bit
+// sRGB. It is built per image rather than cached, as gAMA values are up to
+// the sender.
+func gammaTable(g float64) *[65536]uint16 {
+	t := new([65536]uint16)
+	for v := range t {
+		lin := math.Pow(float64(v)/65535, 1/g)
+		t[v] = uint16(math.Round(colormath.LinearToSRGB(lin) * 65535))
+	}
+	return t
+}
+
+// convertGamma re-encodes img's colors from the file gamma g to sRGB as an
+// *image.NRGBA64. Alpha is not gamma encoded and is kept. ctx is checked
+// once per row.
+func convertGamma(ctx context.Context, img image.Image, g float64) (*image.NRGBA64, error) {
+	t := gammaTable(g)
+	b := img.Bounds()
+	out := image.NewNRGBA64(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			out.SetNRGBA64(x, y, color.NRGBA64{R: t[c.R], G: t[c.G], B: t[c.B], A: c.A})
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,141 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// Orientation returns the EXIF orientation of a JPEG, 1 (upright) through
+// 8, or 1 when data is not a JPEG or has no orientation tag. Phones store
+// photos as the sensor read them and set this tag instead of rotating.
+func Orientation(data []byte) int {
+	if !bytes.HasPrefix(data, jpegSOI) {
+		return 1
+	}
+	info, _, err := inspectJPEG(data)
+	if err != nil || info.Orientation == 0 {
+		return 1
+	}
+	return info.Orientation
+}
+
+// exifOrientation reads the orientation tag from an APP1 segment's
+// payload, returning 0 if it is not Exif or has no valid tag.
+func exifOrientation(seg []byte) int {
+	tiff, ok := bytes.CutPrefix(seg, []byte("Exif\x00\x00"))
+	if !ok || len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(tiff) {
+			break
+		}
+		// Tag 0x0112 is a SHORT stored in the first bytes of the value.
+		if order.Uint16(tiff[e:]) == 0x0112 && order.Uint16(tiff[e+2:]) == 3 {
+			if o := int(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// Thumbnail turns img upright for the given EXIF orientation and scales it
+// to fit in maxDim×maxDim, never enlarging it. Each output pixel is the
+// alpha-weighted mean of the input pixels it covers, averaged in linear
+// light so fine detail does not darken.
+func Thumbnail(img image.Image, orientation, maxDim int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	ow, oh := w, h // upright size
+	if orientation >= 5 && orientation <= 8 {
+		ow, oh = h, w
+	}
+	dw, dh := ow, oh
+	if maxDim > 0 && max(ow, oh) > maxDim {
+		if ow >= oh {
+			dw, dh = maxDim, max(1, (oh*maxDim+ow/2)/ow)
+		} else {
+			dw, dh = max(1, (ow*maxDim+oh/2)/oh), maxDim
+		}
+	}
+	if w == 0 || h == 0 {
+		return image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	}
+
+	// upright maps an input pixel to its position in the upright image.
+	upright := func(x, y int) (int, int) {
+		switch orientation {
+		case 2:
+			return w - 1 - x, y
+		case 3:
+			return w - 1 - x, h - 1 - y
+		case 4:
+			return x, h - 1 - y
+		case 5:
+			return y, x
+		case 6:
+			return h - 1 - y, x
+		case 7:
+			return h - 1 - y, w - 1 - x
+		case 8:
+			return y, w - 1 - x
+		}
+		return x, y
+	}
+	toX := func(ux int) int { return ux * dw / ow }
+	toY := func(uy int) int { return uy * dh / oh }
+
+	// Per output pixel: alpha-weighted linear R, G, B, then alpha and count.
+	acc := make([]float64, dw*dh*5)
+	px := PixelReader(img)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, a := px(b.Min.X+x, b.Min.Y+y)
+			ux, uy := upright(x, y)
+			s := acc[(toY(uy)*dw+toX(ux))*5:]
+			s[4]++
+			if a == 0 {
+				continue
+			}
+			al := unit16(a)
+			// Unpremultiply before linearizing, then weight by alpha.
+			s[0] += lin16(r*0xffff/a) * al
+			s[1] += lin16(g*0xffff/a) * al
+			s[2] += lin16(bl*0xffff/a) * al
+			s[3] += al
+		}
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for i := 0; i < dw*dh; i++ {
+		s, p := acc[i*5:], out.Pix[i*4:]
+		if s[3] == 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			p[c] = uint8(255*min(1, max(0, colormath.LinearToSRGB(s[c]/s[3]))) + 0.5)
+		}
+		p[3] = uint8(255*s[3]/s[4] + 0.5)
+	}
+	return out
+}
@@ -0,0 +1,18 @@
+// Package scoring scores how close an image's colors are to a theme color.
+//
+// A Scorer pairs a Metric (a color difference formula and its space) with an
+// Aggregation (how per-pixel distances combine). Scores are in [0, 100] and
+// match what the iropico server reports before rounding:
+//
+//	img, _, err := imaging.Decode(data)
+//	if err != nil {
+//		return err
+//	}
+//	sc, err := scoring.LookupScorer("ciede2000", "")
+//	if err != nil {
+//		return err
+//	}
+//	r, g, b, _, _ := colormath.ParseColor("dodgerblue")
+//	res := sc.Score(img, r, g, b, scoring.Options{})
+//	fmt.Printf("%.1f %s\n", res.Score, res.AvgHex())
+package scoring
@@ -0,0 +1,114 @@
+package scoring_test
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// Random synthetic images and colors for the property tests.
+
+type synthImage struct {
+	Name string
+	Img  image.Image
+}
+
+func randColor(rng *rand.Rand) color.NRGBA {
+	return color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+}
+
+func hexOf(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func hex64(c color.NRGBA64) string {
+	return fmt.Sprintf("#%04x%04x%04x", c.R, c.G, c.B)
+}
+
+func solidImage(c color.NRGBA, w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func solidImage64(c color.NRGBA64, w, h int) *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA64(x, y, c)
+		}
+	}
+	return img
+}
+
+func gradientImage(a, b color.NRGBA, w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	lerp := func(x, y uint8, t float64) uint8 { return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t)) }
+	for x := 0; x < w; x++ {
+		t := 0.0
+		if w > 1 {
+			t = float64(x) / float64(w-1)
+		}
+		c := color.NRGBA{lerp(a.R, b.R, t), lerp(a.G, b.G, t), lerp(a.B, b.B, t), 255}
+		for y := 0; y < h; y++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func noiseImage(rng *rand.Rand, w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	return img
+}
+
+func checkerImage(a, b color.NRGBA, cell, w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x/cell+y/cell)%2 == 0 {
+				img.SetNRGBA(x, y, a)
+			} else {
+				img.SetNRGBA(x, y, b)
+			}
+		}
+	}
+	return img
+}
+
+func randomSynthImage(rng *rand.Rand) synthImage {
+	w, h := 1+rng.Intn(128), 1+rng.Intn(128)
+	a, b := randColor(rng), randColor(rng)
+	switch rng.Intn(4) {
+	case 0:
+		return synthImage{fmt.Sprintf("solid %s %dx%d", hexOf(a), w, h), solidImage(a, w, h)}
+	case 1:
+		return synthImage{fmt.Sprintf("gradient %s..%s %dx%d", hexOf(a), hexOf(b), w, h), gradientImage(a, b, w, h)}
+	case 2:
+		return synthImage{fmt.Sprintf("noise %dx%d", w, h), noiseImage(rng, w, h)}
+	default:
+		cell := 1 + rng.Intn(8)
+		return synthImage{fmt.Sprintf("checker %s/%s cell=%d %dx%d", hexOf(a), hexOf(b), cell, w, h), checkerImage(a, b, cell, w, h)}
+	}
+}
+
+// lerpLinear moves from theme toward c by t in linear light, so larger t is
+// strictly farther from the theme for any distance that grows along the ray.
+// The result is 16-bit: rounded to 8 bits, nearby points can swap order.
+func lerpLinear(theme, c color.NRGBA, t float64) color.NRGBA64 {
+	ch := func(a, b uint8) uint16 {
+		la, lb := colormath.SRGB8ToLinear(a), colormath.SRGB8ToLinear(b)
+		return uint16(math.Round(colormath.LinearToSRGB(la+(lb-la)*t) * 0xffff))
+	}
+	return color.NRGBA64{ch(theme.R, c.R), ch(theme.G, c.G), ch(theme.B, c.B), 0xffff}
+}
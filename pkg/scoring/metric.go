@@ -0,0 +1,160 @@
+package scoring
+
+import (
+	"math"
+	"sync"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// Metric is a color difference formula together with the color space it
+// operates in. Aggregations only ever see From and Dist.
+type Metric struct {
+	Name  string // value accepted in requests
+	Label string // used to build method names
+	// From converts linear sRGB into the metric's working space.
+	From func(lr, lg, lb float64) colormath.Vec3
+	// Dist measures sample against ref; ref is always the theme.
+	Dist func(ref, sample colormath.Vec3) float64
+	// Symmetric is false when Dist(a, b) != Dist(b, a), as with CIE94.
+	Symmetric bool
+	// NoLightness is set when From drops lightness, and NoChroma when it
+	// also drops chroma above colormath.HueChroma, so brightness or
+	// vividness does not affect the score.
+	NoLightness, NoChroma bool
+
+	maxOnce sync.Once
+	max     float64
+
+	fromMu  sync.Mutex
+	fromMax map[colormath.Vec3]float64
+}
+
+// Metrics are the registered metrics; the first is the default.
+var Metrics = []*Metric{
+	{Name: "rgb", Label: "linear-srgb-euclidean", From: colormath.LinearRGB, Dist: colormath.Euclid, Symmetric: true},
+	{Name: "lab76", Label: "cielab-de76", From: colormath.LinearToLab, Dist: colormath.Euclid, Symmetric: true},
+	{Name: "cie94", Label: "cie94", From: colormath.LinearToLab, Dist: colormath.DeltaE94},
+	{Name: "ciede2000", Label: "ciede2000", From: colormath.LinearToLab, Dist: colormath.DeltaE2000, Symmetric: true},
+	{Name: "oklab", Label: "oklab-euclidean", From: colormath.LinearToOklab, Dist: colormath.Euclid, Symmetric: true},
+	// Lightness-invariant: the same hue scores alike in sun or shade.
+	{Name: "hue", Label: "oklch-hue", From: colormath.LinearToHue, Dist: colormath.Euclid, Symmetric: true, NoLightness: true, NoChroma: true},
+	{Name: "hue-chroma", Label: "oklab-chromaticity", From: colormath.LinearToChromaticity, Dist: colormath.Euclid, Symmetric: true, NoLightness: true},
+}
+
+// Lightness is the metric grayscale mode switches to. It is not in Metrics:
+// on colorful themes it rates a wrong hue of the right lightness highly.
+var Lightness = &Metric{Name: "lightness", Label: "cielab-lightness", From: colormath.LinearToLab, Dist: colormath.DeltaLightness, Symmetric: true}
+
+// LookupMetric finds a metric by request name; "" is the default.
+func LookupMetric(name string) (*Metric, bool) {
+	if name == "" {
+		return Metrics[0], true
+	}
+	for _, m := range Metrics {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// MaxDist is the largest distance between any two sRGB colors under m. The
+// extremes lie on the edges of the RGB cube, so only those are searched.
+func (m *Metric) MaxDist() float64 {
+	m.maxOnce.Do(func() {
+		const steps = 16
+		var pts []colormath.Vec3
+		for i := 0; i < 8; i++ {
+			corner := colormath.Vec3{float64(i & 1), float64(i >> 1 & 1), float64(i >> 2 & 1)}
+			for axis := 0; axis < 3; axis++ {
+				if corner[axis] != 0 {
+					continue
+				}
+				for s := 0; s <= steps; s++ {
+					p := corner
+					p[axis] = float64(s) / steps
+					pts = append(pts, m.From(p[0], p[1], p[2]))
+				}
+			}
+		}
+		for i := range pts {
+			for j := range pts {
+				m.max = math.Max(m.max, m.Dist(pts[i], pts[j]))
+			}
+		}
+	})
+	return m.max
+}
+
+// maxDistFromCacheSize bounds the per-metric cache of gamut maxima; themes
+// change rarely, so simply resetting it when full is good enough.
+const maxDistFromCacheSize = 4096
+
+// MaxDistFrom is the largest distance from theme (already in the metric's
+// space) to any sRGB color. It is searched on the surface of the RGB cube:
+// a coarse grid on every face, then a local refinement around the best hit.
+func (m *Metric) MaxDistFrom(theme colormath.Vec3) float64 {
+	m.fromMu.Lock()
+	d, ok := m.fromMax[theme]
+	m.fromMu.Unlock()
+	if ok {
+		return d
+	}
+
+	const grid = 16
+	best, bestPt := 0.0, colormath.Vec3{}
+	eval := func(p colormath.Vec3) float64 {
+		for i := range p {
+			p[i] = math.Min(1, math.Max(0, p[i]))
+		}
+		return m.Dist(theme, m.From(p[0], p[1], p[2]))
+	}
+	for axis := 0; axis < 3; axis++ {
+		u, v := (axis+1)%3, (axis+2)%3
+		for _, side := range []float64{0, 1} {
+			for i := 0; i <= grid; i++ {
+				for j := 0; j <= grid; j++ {
+					var p colormath.Vec3
+					p[axis], p[u], p[v] = side, float64(i)/grid, float64(j)/grid
+					if d := eval(p); d > best {
+						best, bestPt = d, p
+					}
+				}
+			}
+		}
+	}
+	for step := 0.5 / grid; step > 1e-4; step /= 2 {
+		for improved := true; improved; {
+			improved = false
+			for axis := 0; axis < 3; axis++ {
+				for _, dir := range []float64{-step, step} {
+					p := bestPt
+					p[axis] = math.Min(1, math.Max(0, p[axis]+dir))
+					if d := eval(p); d > best {
+						best, bestPt, improved = d, p, true
+					}
+				}
+			}
+		}
+	}
+	best = math.Max(best, 1e-9)
+
+	m.fromMu.Lock()
+	if m.fromMax == nil || len(m.fromMax) >= maxDistFromCacheSize {
+		m.fromMax = map[colormath.Vec3]float64{}
+	}
+	m.fromMax[theme] = best
+	m.fromMu.Unlock()
+	return best
+}
+
+// FlushCache empties the MaxDistFrom cache and returns how many
+// entries it held.
+func (m *Metric) FlushCache() int {
+	m.fromMu.Lock()
+	defer m.fromMu.Unlock()
+	n := len(m.fromMax)
+	m.fromMax = nil
+	return n
+}
@@ -0,0 +1,192 @@
+package scoring_test
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+	"github.com/hiromuota166/iropico_color_calc/pkg/scoring"
+)
+
+// Property checks that every registered scorer must satisfy under every
+// option variant. A failure means some scorer broke a basic fairness
+// guarantee; rerun with the printed -seed to reproduce it. The default
+// case count keeps go test quick; before changing a scorer, run the full
+// check with
+//
+//	go test ./pkg/scoring -run TestProperties -args -cases=200
+
+var (
+	seed  = flag.Int64("seed", 1, "random seed for the property tests")
+	cases = flag.Int("cases", 20, "cases per property per scorer and option variant")
+)
+
+const scoreEps = 1e-6
+
+// optionVariant is a set of options the properties are checked under.
+// Symmetric and Monotone are false for options that break those
+// properties by design; Rays is false for those under which a color
+// farther along a ray in linear light need not be farther in the metric.
+type optionVariant struct {
+	Name      string
+	Opts      scoring.Options
+	Symmetric bool
+	Monotone  bool
+	Rays      bool
+}
+
+// optionVariants covers each option that changes the score of an opaque
+// image. The sampling budget is small: the images are small and mostly
+// uniform, and every scorer is checked under every variant.
+var optionVariants = []optionVariant{
+	{Name: "default", Symmetric: true, Monotone: true, Rays: true},
+	{Name: "global", Opts: scoring.Options{Normalization: scoring.NormalizeGlobal}, Symmetric: true, Monotone: true, Rays: true},
+	{Name: "curve", Opts: scoring.Options{CurveExponent: 2}, Symmetric: true, Monotone: true, Rays: true},
+	// Vividness compares the matched color's chroma with the theme's, so a
+	// more saturated color farther from the theme can gain more than it
+	// loses.
+	{Name: "vividness", Opts: scoring.Options{VividnessBonus: 0.5}},
+	{Name: "blob", Opts: scoring.Options{BlobBonus: 0.5}, Symmetric: true, Monotone: true, Rays: true},
+	{Name: "grayscale-on", Opts: scoring.Options{Grayscale: scoring.GrayscaleOn}, Symmetric: true, Monotone: true, Rays: true},
+	// Auto switches metric by the theme's chroma alone.
+	{Name: "grayscale-auto", Opts: scoring.Options{Grayscale: scoring.GrayscaleAuto}, Monotone: true, Rays: true},
+	{Name: "background-theme", Opts: scoring.Options{Background: scoring.BackgroundTheme}, Symmetric: true, Monotone: true, Rays: true},
+	{Name: "background-ignore", Opts: scoring.Options{Background: scoring.BackgroundIgnore}, Symmetric: true, Monotone: true, Rays: true},
+	// The simulations clamp to the gamut, which bends rays leaving it.
+	{Name: "protanopia", Opts: scoring.Options{Vision: scoring.VisionProtanopia}, Symmetric: true, Monotone: true},
+	{Name: "deuteranopia", Opts: scoring.Options{Vision: scoring.VisionDeuteranopia}, Symmetric: true, Monotone: true},
+	{Name: "tritanopia", Opts: scoring.Options{Vision: scoring.VisionTritanopia}, Symmetric: true, Monotone: true},
+}
+
+// notRayMonotone lists the metrics whose distance from the theme does not
+// grow along every line in linear light: CIEDE2000's hue rotation term
+// makes it non-monotone, Oklch hue and Oklab chromaticity follow such a
+// line along a curve through Oklab's cube root, and grayscale mode's
+// lightness metric compares chroma but not hue, so a line through gray
+// regains the theme's chroma on the far side.
+var notRayMonotone = map[string]bool{"ciede2000": true, "hue": true, "hue-chroma": true, "lightness": true}
+
+func TestProperties(t *testing.T) {
+	n := *cases
+	if testing.Short() {
+		n = max(1, n/10)
+	}
+	for _, sc := range scoring.Scorers {
+		for _, v := range optionVariants {
+			t.Run(sc.Name+"/"+v.Name, func(t *testing.T) {
+				t.Parallel()
+				v.Opts.MaxSamples = 256
+				checkProperties(t, sc, v, rand.New(rand.NewSource(*seed)), n)
+			})
+		}
+	}
+}
+
+// checkProperties runs n random cases per property against sc under v.
+func checkProperties(t *testing.T, sc scoring.Scorer, v optionVariant, rng *rand.Rand, n int) {
+	fail := func(prop, format string, args ...any) {
+		t.Helper()
+		t.Errorf("%s: %s (seed %d)", prop, fmt.Sprintf(format, args...), *seed)
+	}
+	score := func(img image.Image, th color.NRGBA) float64 {
+		return sc.Score(img, th.R, th.G, th.B, v.Opts).Score
+	}
+
+	for range n {
+		si := randomSynthImage(rng)
+		theme := randColor(rng)
+		s := score(si.Img, theme)
+		if math.IsNaN(s) || s < 0 || s > 100 {
+			fail("bounds", "%s vs %s scored %v", si.Name, hexOf(theme), s)
+		}
+		if again := score(si.Img, theme); again != s {
+			fail("determinism", "%s vs %s scored %v then %v", si.Name, hexOf(theme), s, again)
+		}
+	}
+
+	for range n {
+		theme := randColor(rng)
+		if s := score(solidImage(theme, 8, 8), theme); s < 100-scoreEps {
+			fail("identity", "solid %s vs itself scored %v", hexOf(theme), s)
+		}
+	}
+
+	// Gamut normalization scales by a per-theme maximum, so swapping image and
+	// theme is only expected to be symmetric under the global scale. CIE94
+	// weighs by the reference's chroma and is asymmetric by definition, so
+	// only metrics declaring themselves Symmetric are held to it.
+	global := v.Opts
+	global.Normalization = scoring.NormalizeGlobal
+	for i := 0; i < n && v.Symmetric && sc.Metric.Symmetric; i++ {
+		a, b := randColor(rng), randColor(rng)
+		sab := sc.Score(solidImage(a, 8, 8), b.R, b.G, b.B, global).Score
+		sba := sc.Score(solidImage(b, 8, 8), a.R, a.G, a.B, global).Score
+		if math.Abs(sab-sba) > scoreEps {
+			fail("symmetry", "solid %s vs %s = %v, reversed = %v", hexOf(a), hexOf(b), sab, sba)
+		}
+	}
+
+	// A color farther from the theme along a ray in linear light never
+	// scores higher. Where rays do not follow the metric, that is checked
+	// with the metric's own distance instead, as the player sees it.
+	for i := 0; i < n && v.Monotone; i++ {
+		theme := randColor(rng)
+		if m := seenMetric(sc, v.Opts, theme); !v.Rays || notRayMonotone[m.Name] {
+			near, far := randColor(rng), randColor(rng)
+			if seenDistance(m, v.Opts, theme, near) > seenDistance(m, v.Opts, theme, far) {
+				near, far = far, near
+			}
+			sNear, sFar := score(solidImage(near, 8, 8), theme), score(solidImage(far, 8, 8), theme)
+			if sFar > sNear+scoreEps {
+				fail("monotonicity", "theme %s: %s scored %v but farther %s scored %v", hexOf(theme), hexOf(near), sNear, hexOf(far), sFar)
+			}
+			continue
+		}
+		far := randColor(rng)
+		t1 := rng.Float64()
+		t2 := t1 + (1-t1)*rng.Float64()
+		near, farther := lerpLinear(theme, far, t1), lerpLinear(theme, far, t2)
+		sNear, sFar := score(solidImage64(near, 8, 8), theme), score(solidImage64(farther, 8, 8), theme)
+		if sFar > sNear+scoreEps {
+			fail("monotonicity", "theme %s: %s (t=%.3f) scored %v but farther %s (t=%.3f) scored %v",
+				hexOf(theme), hex64(near), t1, sNear, hex64(farther), t2, sFar)
+		}
+	}
+
+	for range n {
+		c, theme := randColor(rng), randColor(rng)
+		small, large := score(solidImage(c, 1, 1), theme), score(solidImage(c, 1+rng.Intn(512), 1+rng.Intn(512)), theme)
+		if math.Abs(small-large) > scoreEps {
+			fail("size invariance", "solid %s vs %s: 1x1 scored %v, larger scored %v", hexOf(c), hexOf(theme), small, large)
+		}
+	}
+}
+
+// seen returns c in linear light as opts' vision mode sees it.
+func seen(opts scoring.Options, c color.NRGBA) (float64, float64, float64) {
+	lin := colormath.SRGB8ToLinear
+	lr, lg, lb := lin(c.R), lin(c.G), lin(c.B)
+	if opts.Vision != "" && opts.Vision != scoring.VisionNormal {
+		return colormath.SimulateDichromacy(opts.Vision, lr, lg, lb)
+	}
+	return lr, lg, lb
+}
+
+// seenMetric is the metric sc scores theme with under opts' grayscale mode.
+func seenMetric(sc scoring.Scorer, opts scoring.Options, theme color.NRGBA) *scoring.Metric {
+	if lr, lg, lb := seen(opts, theme); scoring.Grayscale(opts.Grayscale, lr, lg, lb) {
+		return scoring.Lightness
+	}
+	return sc.Metric
+}
+
+// seenDistance is the distance m finds between theme and c as opts' vision
+// mode sees them, the one a score of solid c rests on.
+func seenDistance(m *scoring.Metric, opts scoring.Options, theme, c color.NRGBA) float64 {
+	return m.Dist(m.From(seen(opts, theme)), m.From(seen(opts, c)))
+}
@@ -0,0 +1,698 @@
+package scoring
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+)
+
+// CoverageMinScore is the score a single sample needs to count toward
+// Result.Coverage.
+const CoverageMinScore = 80.0
+
+// Normalization names accepted in requests.
+const (
+	// NormalizeGamut divides by the farthest sRGB color from the theme, so
+	// every theme spans the full 0–100 range.
+	NormalizeGamut = "gamut"
+	// NormalizeGlobal divides by the largest distance between any two sRGB
+	// colors (√3 for linear RGB), which favors themes near the gamut center.
+	NormalizeGlobal = "global"
+)
+
+// Background names: how transparent pixels are treated.
+const (
+	// BackgroundAlpha weighs each pixel by its alpha; a fully transparent
+	// image averages to black.
+	BackgroundAlpha = "alpha"
+	// BackgroundWhite composites the image over white.
+	BackgroundWhite = "white"
+	// BackgroundTheme composites the image over the theme color.
+	BackgroundTheme = "theme"
+	// BackgroundIgnore scores only pixels at least half opaque.
+	BackgroundIgnore = "ignore"
+)
+
+// Backgrounds lists the accepted background names.
+var Backgrounds = []string{BackgroundAlpha, BackgroundWhite, BackgroundTheme, BackgroundIgnore}
+
+// Grayscale mode names: when to score on lightness instead of the
+// requested metric.
+const (
+	GrayscaleOff = "off"
+	// GrayscaleAuto switches for themes with a CIELAB chroma below
+	// NeutralChroma, where hue is noise and chroma differences would make
+	// a matching gray object hard to find.
+	GrayscaleAuto = "auto"
+	GrayscaleOn   = "on"
+)
+
+// GrayscaleModes lists the accepted grayscale mode names.
+var GrayscaleModes = []string{GrayscaleOff, GrayscaleAuto, GrayscaleOn}
+
+// Vision mode names: whose color vision to score as. Under a dichromacy
+// both the image and the theme are seen through its
+// colormath.SimulateDichromacy before scoring, so a photo scores as well
+// as it matches the theme for a color-blind player.
+const (
+	VisionNormal       = "normal"
+	VisionProtanopia   = colormath.Protanopia
+	VisionDeuteranopia = colormath.Deuteranopia
+	VisionTritanopia   = colormath.Tritanopia
+)
+
+// VisionModes lists the accepted vision mode names.
+var VisionModes = []string{VisionNormal, VisionProtanopia, VisionDeuteranopia, VisionTritanopia}
+
+// NeutralChroma is the CIELAB chroma under which GrayscaleAuto treats a
+// theme as gray; #808080 is 0 and #8a8580, a warm gray, about 3.
+const NeutralChroma = 6.0
+
+// Options tune a Scorer; the zero value is gamut normalization with the
+// default sampling budget and grid, a linear curve, alpha weighting,
+// grayscale mode off and normal vision.
+type Options struct {
+	Normalization string
+	// Background is one of Backgrounds; "" means BackgroundAlpha.
+	Background string
+	// Grayscale is one of GrayscaleModes; "" means GrayscaleOff.
+	Grayscale string
+	// Vision is one of VisionModes; "" means VisionNormal. The result's
+	// average color and clusters are then as the viewer sees them.
+	Vision string
+	// MaxSamples is the pixel sampling budget; 0 means
+	// imaging.DefaultMaxSamples.
+	MaxSamples int
+	// Seed places the sampling grid, as in imaging.SampleLinearRGB; 0 is
+	// the grid from the top-left pixel. A score is reproducible for its
+	// seed.
+	Seed uint64
+	// CurveExponent shapes the final score; 0 or 1 is linear.
+	CurveExponent float64
+	// VividnessBonus, in [0, 1], is the share of the score that depends on
+	// the aggregation's Color being as vivid as the theme; 0 disables it.
+	// See Vividness.
+	VividnessBonus float64
+	// BlobBonus, in [0, 1], is the share that depends on the matching
+	// pixels forming one region of BlobFullArea or more, so a few stray
+	// pixels cannot carry a score; 0 disables it.
+	BlobBonus float64
+}
+
+// Clustering for Result.Closest and the dominant aggregation: up to
+// ClusterSamples of the samples are split into ClusterCount clusters, of
+// which the ClosestClusters nearest the theme are kept.
+const (
+	ClusterSamples  = 1024
+	ClusterCount    = 8
+	ClusterRounds   = 3
+	ClosestClusters = 3
+)
+
+// Cluster is a group of similar samples: its mean in linear sRGB, its share
+// of the alpha-weighted samples and its distance to the theme in the
+// metric's units.
+type Cluster struct {
+	R, G, B float64
+	Share   float64
+	Dist    float64
+}
+
+// BlobFullArea is the share of the image the largest matching region needs
+// to earn the whole blob bonus.
+const BlobFullArea = 0.1
+
+// Validate reports an unknown normalization, background, grayscale mode
+// or vision mode.
+func (o Options) Validate() error {
+	switch o.Normalization {
+	case "", NormalizeGamut, NormalizeGlobal:
+	default:
+		return fmt.Errorf("unknown normalization %q", o.Normalization)
+	}
+	if o.Background != "" && !slices.Contains(Backgrounds, o.Background) {
+		return fmt.Errorf("unknown background %q", o.Background)
+	}
+	if o.Grayscale != "" && !slices.Contains(GrayscaleModes, o.Grayscale) {
+		return fmt.Errorf("unknown grayscale mode %q", o.Grayscale)
+	}
+	if o.Vision != "" && !slices.Contains(VisionModes, o.Vision) {
+		return fmt.Errorf("unknown vision mode %q", o.Vision)
+	}
+	return nil
+}
+
+// Result is the outcome of scoring one image.
+type Result struct {
+	// Score is in [0, 100], unrounded.
+	Score float64
+	// AvgR, AvgG, AvgB are the weighted average color in linear sRGB.
+	AvgR, AvgG, AvgB float64
+	// Coverage is the alpha-weighted share of samples that alone would
+	// score at least CoverageMinScore.
+	Coverage float64
+	// StdDev is the largest per-channel standard deviation of the samples
+	// in linear sRGB; near 0 for a flat image.
+	StdDev float64
+	// Opaque is the share of selected samples at least half opaque,
+	// whatever the background.
+	Opaque float64
+	// Selected is the share of samples the mask selects, weighted by its
+	// gray level; 1 without a mask.
+	Selected float64
+	// LargestBlob is the alpha-weighted share of samples in the largest
+	// 4-connected region of samples that count toward Coverage.
+	LargestBlob float64
+	// Closest holds the ClosestClusters color clusters of the image nearest
+	// the theme, nearest first.
+	Closest []Cluster
+	// Method names the scorer that produced Score: the Scorer's own
+	// name, or its Lightness variant under grayscale mode.
+	Method string
+}
+
+// Input is everything an Aggregation needs, prepared once per image.
+type Input struct {
+	Metric  *Metric
+	Theme   colormath.Vec3 // in the metric's space
+	Samples []imaging.Sample
+	// Cols is the width of the sampling grid Samples fill row by row.
+	Cols    int
+	Mean    colormath.Vec3 // linear sRGB
+	MaxDist float64
+
+	match    []bool                  // see matches
+	clusters []imaging.SampleCluster // see sampleClusters
+}
+
+// DistScore maps a distance onto [0, 100] against in.MaxDist.
+func (in *Input) DistScore(d float64) float64 {
+	score := 100.0 * (1.0 - d/in.MaxDist)
+	if score < 0 {
+		score = 0
+	}
+	if score > 100 {
+		score = 100
+	}
+	return score
+}
+
+// Aggregation turns per-sample distances into one score in [0, 100].
+type Aggregation struct {
+	Name  string
+	Score func(in *Input) float64
+	// Color is the linear sRGB color the score rests on: the mean, the
+	// nearest sample, the mean of those matching, or the mean of the
+	// largest cluster. Options.VividnessBonus
+	// judges its vividness.
+	Color func(in *Input) colormath.Vec3
+}
+
+// Aggregations are the registered aggregations; the first is the default.
+var Aggregations = []*Aggregation{
+	{Name: "mean", Score: aggregateMean, Color: meanColor},
+	{Name: "nearest", Score: aggregateNearest, Color: nearestColor},
+	{Name: "coverage", Score: aggregateCoverage, Color: matchingColor},
+	{Name: "dominant", Score: aggregateDominant, Color: dominantColor},
+}
+
+// LookupAggregation finds an aggregation by request name; "" is the
+// default.
+func LookupAggregation(name string) (*Aggregation, bool) {
+	if name == "" {
+		return Aggregations[0], true
+	}
+	for _, a := range Aggregations {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// Scorer is one metric × aggregation combination. Name identifies it in
+// responses, e.g. "ciede2000-nearest(sampled)".
+type Scorer struct {
+	Name        string
+	Metric      *Metric
+	Aggregation *Aggregation
+}
+
+// Scorers holds every metric × aggregation combination; the first entry is
+// the default.
+var Scorers = buildScorers()
+
+func buildScorers() []Scorer {
+	var out []Scorer
+	for _, a := range Aggregations {
+		for _, m := range Metrics {
+			out = append(out, NewScorer(m, a))
+		}
+	}
+	return out
+}
+
+// NewScorer combines m and a.
+func NewScorer(m *Metric, a *Aggregation) Scorer {
+	name := m.Label + "(sampled)"
+	if a.Name != "mean" {
+		name = m.Label + "-" + a.Name + "(sampled)"
+	}
+	return Scorer{Name: name, Metric: m, Aggregation: a}
+}
+
+// Score scores img against the sRGB theme color tr, tg, tb.
+func (sc Scorer) Score(img image.Image, tr, tg, tb uint8, opts Options) Result {
+	return sc.ScoreMasked(img, nil, tr, tg, tb, opts)
+}
+
+// ScoreMasked is Score over only the pixels mask selects, weighted as
+// imaging.SampleMask does. mask must have img's size; nil selects every
+// pixel.
+func (sc Scorer) ScoreMasked(img, mask image.Image, tr, tg, tb uint8, opts Options) Result {
+	res, _ := sc.ScoreContext(context.Background(), img, mask, tr, tg, tb, opts)
+	return res
+}
+
+// ScoreContext is ScoreMasked that gives up with ctx's error once ctx is
+// done. Sampling checks ctx as it reads the image, and the aggregation
+// steps, which are as long as the samples, are each checked before.
+func (sc Scorer) ScoreContext(ctx context.Context, img, mask image.Image, tr, tg, tb uint8, opts Options) (Result, error) {
+	lin := colormath.SRGB8ToLinear
+	// The theme as the viewer sees it; theme backgrounds composite the
+	// theme as it is, and the composite is simulated with the rest.
+	lr, lg, lb := lin(tr), lin(tg), lin(tb)
+	if opts.Vision != "" && opts.Vision != VisionNormal {
+		lr, lg, lb = colormath.SimulateDichromacy(opts.Vision, lr, lg, lb)
+	}
+	if sc.Metric != Lightness && Grayscale(opts.Grayscale, lr, lg, lb) {
+		sc = NewScorer(Lightness, sc.Aggregation)
+	}
+	m := sc.Metric
+	var samples []imaging.Sample
+	var err error
+	switch opts.Background {
+	case BackgroundWhite:
+		samples, err = imaging.SampleOverContext(ctx, img, opts.MaxSamples, opts.Seed, colormath.Vec3{1, 1, 1})
+	case BackgroundTheme:
+		samples, err = imaging.SampleOverContext(ctx, img, opts.MaxSamples, opts.Seed, colormath.Vec3{float64(tr) / 255, float64(tg) / 255, float64(tb) / 255})
+	case BackgroundIgnore:
+		samples, err = imaging.SampleOpaqueContext(ctx, img, opts.MaxSamples, opts.Seed, 0.5)
+	default:
+		samples, err = imaging.SampleLinearRGBContext(ctx, img, opts.MaxSamples, opts.Seed)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	if opts.Vision != "" && opts.Vision != VisionNormal {
+		for i := range samples {
+			s := &samples[i]
+			s.R, s.G, s.B = colormath.SimulateDichromacy(opts.Vision, s.R, s.G, s.B)
+		}
+	}
+	selected := 1.0
+	var maskW []float64
+	if mask != nil {
+		if maskW, err = imaging.SampleMaskContext(ctx, mask, img.Bounds(), opts.MaxSamples, opts.Seed); err != nil {
+			return Result{}, err
+		}
+		var sum float64
+		for i, w := range maskW {
+			samples[i].W *= w
+			sum += w
+		}
+		selected = sum / float64(max(1, len(maskW)))
+	}
+	cols, _ := imaging.GridSize(img.Bounds(), opts.MaxSamples, opts.Seed)
+	in := &Input{
+		Metric:  m,
+		Theme:   m.From(lr, lg, lb),
+		Samples: samples,
+		Cols:    cols,
+		Mean:    imaging.AverageLinearRGB(samples),
+	}
+	if opts.Normalization == NormalizeGlobal {
+		in.MaxDist = m.MaxDist()
+	} else {
+		in.MaxDist = m.MaxDistFrom(in.Theme)
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	score := sc.Aggregation.Score(in)
+	if w := opts.VividnessBonus; w > 0 && m != Lightness {
+		score *= 1 - w + w*Vividness(sc.Aggregation.Color(in), lr, lg, lb)
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	blob := largestBlob(in)
+	if w := opts.BlobBonus; w > 0 {
+		score *= 1 - w + w*math.Min(1, blob/BlobFullArea)
+	}
+	if opts.CurveExponent > 0 && opts.CurveExponent != 1 {
+		score = 100 * math.Pow(score/100, opts.CurveExponent)
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Score:       score,
+		AvgR:        in.Mean[0],
+		AvgG:        in.Mean[1],
+		AvgB:        in.Mean[2],
+		Coverage:    themeCoverage(in),
+		StdDev:      sampleStdDev(samples, in.Mean),
+		Opaque:      opaqueShare(samples, maskW),
+		Selected:    selected,
+		LargestBlob: blob,
+		Closest:     closestClusters(in),
+		Method:      sc.Name,
+	}, nil
+}
+
+// Vividness is the Oklch chroma of the linear sRGB color c relative to
+// that of the linear sRGB theme, at most 1: how far a match is from a
+// grayish approximation of the theme. It is 1 for themes with less chroma
+// than colormath.HueChroma, which have no vividness to live up to.
+func Vividness(c colormath.Vec3, lr, lg, lb float64) float64 {
+	theme := colormath.OklabToOklch(colormath.LinearToOklab(lr, lg, lb))[1]
+	if theme < colormath.HueChroma {
+		return 1
+	}
+	return math.Min(1, colormath.OklabToOklch(colormath.LinearToOklab(c[0], c[1], c[2]))[1]/theme)
+}
+
+// VisionTheme returns the sRGB theme tr, tg, tb as seen under vision mode
+// mode.
+func VisionTheme(mode string, tr, tg, tb uint8) (uint8, uint8, uint8) {
+	if mode == "" || mode == VisionNormal {
+		return tr, tg, tb
+	}
+	lin := colormath.SRGB8ToLinear
+	lr, lg, lb := colormath.SimulateDichromacy(mode, lin(tr), lin(tg), lin(tb))
+	enc := func(c float64) uint8 { return uint8(math.Round(colormath.LinearToSRGB(c) * 255)) }
+	return enc(lr), enc(lg), enc(lb)
+}
+
+// Grayscale reports whether mode scores the linear sRGB theme on
+// lightness.
+func Grayscale(mode string, lr, lg, lb float64) bool {
+	switch mode {
+	case GrayscaleOn:
+		return true
+	case GrayscaleAuto:
+		lab := colormath.LinearToLab(lr, lg, lb)
+		return math.Hypot(lab[1], lab[2]) < NeutralChroma
+	}
+	return false
+}
+
+// opaqueShare is the share of samples at least half opaque, weighted by
+// maskW when it is not nil.
+func opaqueShare(samples []imaging.Sample, maskW []float64) float64 {
+	var n, sum float64
+	for i, s := range samples {
+		w := 1.0
+		if maskW != nil {
+			w = maskW[i]
+		}
+		if s.A >= 0.5 {
+			n += w
+		}
+		sum += w
+	}
+	if sum == 0 {
+		return 0
+	}
+	return n / sum
+}
+
+// AvgHex is the average color as #rrggbb.
+func (r Result) AvgHex() string { return colormath.LinearHex(r.AvgR, r.AvgG, r.AvgB) }
+
+// Version pins the method behind a versioned score route, so games in
+// progress keep comparable scores while newer algorithms ship under a new
+// version. Explicit request fields still override them. A published
+// version's fields must never change.
+type Version struct {
+	Name          string
+	Metric        string
+	Aggregation   string
+	Normalization string
+}
+
+// Versions are the published scoring versions, oldest first. v1 is the
+// original average-color score.
+var Versions = []Version{
+	{Name: "v1", Metric: "rgb", Aggregation: "mean", Normalization: NormalizeGlobal},
+	{Name: "v2", Metric: "ciede2000", Aggregation: "mean", Normalization: NormalizeGamut},
+}
+
+// LookupVersion resolves a version name; "" is v1.
+func LookupVersion(name string) (Version, bool) {
+	if name == "" {
+		return Versions[0], true
+	}
+	for _, v := range Versions {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Version{}, false
+}
+
+// LookupScorer resolves metric and aggregation names to a Scorer; empty
+// names select the defaults.
+func LookupScorer(metricName, aggName string) (Scorer, error) {
+	m, ok := LookupMetric(metricName)
+	if !ok {
+		return Scorer{}, fmt.Errorf("unknown metric %q", metricName)
+	}
+	a, ok := LookupAggregation(aggName)
+	if !ok {
+		return Scorer{}, fmt.Errorf("unknown aggregation %q", aggName)
+	}
+	for _, sc := range Scorers {
+		if sc.Metric == m && sc.Aggregation == a {
+			return sc, nil
+		}
+	}
+	return NewScorer(m, a), nil
+}
+
+func aggregateMean(in *Input) float64 {
+	return in.DistScore(in.Metric.Dist(in.Theme, in.Metric.From(in.Mean[0], in.Mean[1], in.Mean[2])))
+}
+
+func aggregateNearest(in *Input) float64 {
+	best := math.Inf(1)
+	for _, s := range in.Samples {
+		if s.W == 0 {
+			continue
+		}
+		best = math.Min(best, in.Metric.Dist(in.Theme, in.Metric.From(s.R, s.G, s.B)))
+	}
+	if math.IsInf(best, 1) {
+		return 0
+	}
+	return in.DistScore(best)
+}
+
+func aggregateCoverage(in *Input) float64 {
+	return 100 * themeCoverage(in)
+}
+
+// aggregateDominant scores the mean of the largest color cluster, so an
+// image is judged by the color most of it is rather than by an average of
+// colors that may appear nowhere in it.
+func aggregateDominant(in *Input) float64 {
+	clusters := in.sampleClusters()
+	if len(clusters) == 0 {
+		return 0
+	}
+	c := clusters[0].Mean
+	return in.DistScore(in.Metric.Dist(in.Theme, in.Metric.From(c[0], c[1], c[2])))
+}
+
+func meanColor(in *Input) colormath.Vec3 { return in.Mean }
+
+// nearestColor is the sample aggregateNearest scores, or black if no
+// sample has weight.
+func nearestColor(in *Input) colormath.Vec3 {
+	var c colormath.Vec3
+	best := math.Inf(1)
+	for _, s := range in.Samples {
+		if s.W == 0 {
+			continue
+		}
+		if d := in.Metric.Dist(in.Theme, in.Metric.From(s.R, s.G, s.B)); d < best {
+			best, c = d, colormath.Vec3{s.R, s.G, s.B}
+		}
+	}
+	return c
+}
+
+// matchingColor is the weighted mean of the samples aggregateCoverage
+// counts, or black if none match.
+func matchingColor(in *Input) colormath.Vec3 {
+	match := in.matches()
+	var c colormath.Vec3
+	var sumW float64
+	for i, s := range in.Samples {
+		if match[i] {
+			c[0], c[1], c[2] = c[0]+s.R*s.W, c[1]+s.G*s.W, c[2]+s.B*s.W
+			sumW += s.W
+		}
+	}
+	if sumW == 0 {
+		return c
+	}
+	return colormath.Vec3{c[0] / sumW, c[1] / sumW, c[2] / sumW}
+}
+
+// dominantColor is the mean of the largest cluster, or black if no sample
+// has weight.
+func dominantColor(in *Input) colormath.Vec3 {
+	if clusters := in.sampleClusters(); len(clusters) > 0 {
+		return clusters[0].Mean
+	}
+	return colormath.Vec3{}
+}
+
+func sampleStdDev(samples []imaging.Sample, mean colormath.Vec3) float64 {
+	var sq colormath.Vec3
+	var w float64
+	for _, s := range samples {
+		for c, v := range [3]float64{s.R, s.G, s.B} {
+			d := v - mean[c]
+			sq[c] += d * d * s.W
+		}
+		w += s.W
+	}
+	if w == 0 {
+		return 0
+	}
+	return math.Sqrt(max(sq[0], sq[1], sq[2]) / w)
+}
+
+// matches reports which samples would on their own score at least
+// CoverageMinScore against the theme; it is computed once per Input.
+func (in *Input) matches() []bool {
+	if in.match != nil {
+		return in.match
+	}
+	limit := in.MaxDist * (1 - CoverageMinScore/100.0)
+	in.match = make([]bool, len(in.Samples))
+	for i, s := range in.Samples {
+		in.match[i] = in.Metric.Dist(in.Theme, in.Metric.From(s.R, s.G, s.B)) <= limit
+	}
+	return in.match
+}
+
+// themeCoverage is the alpha-weighted share of matching samples.
+func themeCoverage(in *Input) float64 {
+	match := in.matches()
+	var hit, sumW float64
+	for i, s := range in.Samples {
+		if match[i] {
+			hit += s.W
+		}
+		sumW += s.W
+	}
+	if sumW == 0 {
+		return 0
+	}
+	return hit / sumW
+}
+
+// largestBlob is the alpha-weighted share of samples in the largest
+// 4-connected region of matching samples with weight, found by flood fill
+// over the sampling grid.
+func largestBlob(in *Input) float64 {
+	match, cols := in.matches(), in.Cols
+	var sumW, best float64
+	for _, s := range in.Samples {
+		sumW += s.W
+	}
+	if sumW == 0 || cols == 0 {
+		return 0
+	}
+	seen := make([]bool, len(match))
+	var stack []int
+	for start := range match {
+		if seen[start] || !match[start] || in.Samples[start].W == 0 {
+			continue
+		}
+		var area float64
+		seen[start] = true
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			area += in.Samples[i].W
+			// Above, below, left and right; -1 is off the grid. Edges are
+			// checked by column, not by index, which in a one-column grid
+			// would match the samples above and below.
+			next := [4]int{i - cols, i + cols, -1, -1}
+			x := i % cols
+			if x > 0 {
+				next[2] = i - 1
+			}
+			if x < cols-1 {
+				next[3] = i + 1
+			}
+			for _, j := range next {
+				if j < 0 || j >= len(match) {
+					continue
+				}
+				if !seen[j] && match[j] && in.Samples[j].W > 0 {
+					seen[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+		best = math.Max(best, area)
+	}
+	return best / sumW
+}
+
+// sampleClusters splits up to ClusterSamples of in's samples into
+// ClusterCount clusters, ordered by descending share; it is computed once
+// per Input.
+func (in *Input) sampleClusters() []imaging.SampleCluster {
+	if in.clusters != nil {
+		return in.clusters
+	}
+	samples := in.Samples
+	if step := len(samples) / ClusterSamples; step > 1 {
+		samples = make([]imaging.Sample, 0, ClusterSamples+1)
+		for i := 0; i < len(in.Samples); i += step {
+			samples = append(samples, in.Samples[i])
+		}
+	}
+	in.clusters = imaging.Reassign(samples, imaging.Cluster(samples, ClusterCount), ClusterRounds)
+	return in.clusters
+}
+
+// closestClusters returns the ClosestClusters clusters of in's samples
+// nearest the theme, nearest first.
+func closestClusters(in *Input) []Cluster {
+	clusters := in.sampleClusters()
+	var out []Cluster
+	for _, c := range clusters {
+		out = append(out, Cluster{
+			R: c.Mean[0], G: c.Mean[1], B: c.Mean[2],
+			Share: c.Share,
+			Dist:  in.Metric.Dist(in.Theme, in.Metric.From(c.Mean[0], c.Mean[1], c.Mean[2])),
+		})
+	}
+	slices.SortStableFunc(out, func(a, b Cluster) int { return cmp.Compare(a.Dist, b.Dist) })
+	return out[:min(len(out), ClosestClusters)]
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// newPprofServer serves the net/http/pprof handlers on addr, apart from the
+// API so profiles are never reachable through its port, auth or rate limits:
+//
+//	go tool pprof http://localhost:6060/debug/pprof/profile?seconds=30
+//	go tool pprof http://localhost:6060/debug/pprof/heap
+func newPprofServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// No write timeout: CPU profiles and traces stream for as long as
+	// their seconds parameter asks.
+	return &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+}
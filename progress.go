@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+)
+
+// Scoring a full-resolution photo, or a batch of them, takes seconds on a
+// phone connection. Clients that send Accept: text/event-stream get
+// Server-Sent Events instead of waiting on one response: progress events
+// as the work advances, with the score as soon as it is known, then a
+// result event with the response, or an error event. Requests that fail
+// before any progress is reported, such as those failing validation, get
+// the usual JSON error with its status instead.
+
+// Stages of ScoreProgress.
+const (
+	progressDecoded = "decoded"
+	progressScored  = "scored"
+	progressMethod  = "method"
+)
+
+type ScoreProgress struct {
+	Index   int          `json:"index" doc:"Position of the request in a batch; 0 outside batches."`
+	Stage   string       `json:"stage" enum:"decoded,scored,method" doc:"decoded once the image is decoded; scored once it is scored by the request's method; method after each further method with include_all_methods."`
+	Percent int          `json:"percent" doc:"Share of the work done, 0 to 100; in batches, of the whole batch."`
+	Partial *MethodScore `json:"partial,omitempty" doc:"The score just computed: the request's with stage scored, one of all_methods with stage method."`
+}
+
+var batchScoreHeaders = map[string]string{
+	"Accept-Language": scoreHeaders["Accept-Language"],
+	"X-Session-ID":    scoreHeaders["X-Session-ID"],
+}
+
+type BatchScoreRequest struct {
+	Requests []ScoreRequest `json:"requests" doc:"At most 64 requests, scored in order; the whole batch must fit in limits.max_body_bytes."`
+}
+
+type BatchScoreResponse struct {
+	Results []BatchScoreResult `json:"results" doc:"In the order of requests."`
+}
+
+type BatchScoreResult struct {
+	Index    int            `json:"index" doc:"Position of the request in requests."`
+	Response *ScoreResponse `json:"response,omitempty"`
+	Error    *APIError      `json:"error,omitempty" doc:"Set instead of response when this request failed."`
+}
+
+// eventStream writes Server-Sent Events. The response is committed with
+// the first event, so errors before it can still be written as usual.
+type eventStream struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	started bool
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	return &eventStream{w: w, rc: http.NewResponseController(w)}
+}
+
+// send writes v as a JSON event and flushes it. Each event extends the
+// write deadline by server.write_timeout, so a stream that keeps making
+// progress is not cut off.
+func (s *eventStream) send(event string, v any) {
+	if !s.started {
+		s.started = true
+		h := s.w.Header()
+		h.Set("Content-Type", mediaEventStream)
+		h.Set("Cache-Control", "no-store")
+		h.Set("X-Accel-Buffering", "no") // or nginx buffers the events
+		s.w.WriteHeader(http.StatusOK)
+	}
+	if d := time.Duration(config().Server.WriteTimeout); d > 0 {
+		s.rc.SetWriteDeadline(time.Now().Add(d))
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(ErrorResponse{APIError{Code: codeInternal, Message: err.Error()}})
+		event = "error"
+	}
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, b)
+	s.rc.Flush()
+}
+
+// fail reports err as an error event, or as a plain error response if
+// nothing was streamed yet.
+func (s *eventStream) fail(r *http.Request, err error) {
+	if !s.started {
+		writeRequestError(s.w, r, err)
+		return
+	}
+	_, apiErr := apiError(r, err)
+	s.send("error", ErrorResponse{apiErr})
+}
+
+// streamScore serves a score request as an event stream; the result event
+// carries the ScoreResponse.
+func streamScore(w http.ResponseWriter, r *http.Request, p scoreParams) {
+	s := newEventStream(w)
+	p.Progress = func(pr ScoreProgress) { s.send("progress", pr) }
+	resp, _, err := scoreSubmission(r.Context(), p)
+	if err != nil {
+		s.fail(r, err)
+		return
+	}
+	s.send("result", resp)
+	observeDone(p.ReceivedAt, p.CapturedAt)
+}
+
+// batchScoreHandler serves the batch score route of the named scoring
+// version.
+func batchScoreHandler(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) { handleBatchScore(w, r, version) }
+}
+
+// handleBatchScore scores each request of a batch in turn, as /score
+// would but without idempotency keys, which the batch's requests would
+// otherwise share. One request failing does not fail the others. Streamed,
+// every request's progress and result are sent as they come, followed by
+// a done event; a client that disconnects stops the batch.
+func handleBatchScore(w http.ResponseWriter, r *http.Request, version string) {
+	r.Body = http.MaxBytesReader(w, r.Body, config().Limits.MaxBodyBytes)
+	var req BatchScoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, r, bodyError(err))
+		return
+	}
+	n := len(req.Requests)
+	if n == 0 || n > maxBatchSize {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "requests",
+			Msg: fmt.Sprintf("batch of %d requests; want 1 to %d", n, maxBatchSize), Details: map[string]any{"max": maxBatchSize}})
+		return
+	}
+	var s *eventStream
+	if negotiateMedia(r.Header.Get("Accept")) == mediaEventStream {
+		s = newEventStream(w)
+	}
+
+	resp := BatchScoreResponse{Results: make([]BatchScoreResult, 0, n)}
+	for i, item := range req.Requests {
+		if r.Context().Err() != nil {
+			return
+		}
+		res := BatchScoreResult{Index: i}
+		if sr, err := batchScoreItem(r, item, version, func(pr ScoreProgress) {
+			if s != nil {
+				pr.Index, pr.Percent = i, (100*i+pr.Percent)/n
+				s.send("progress", pr)
+			}
+		}); err != nil {
+			_, apiErr := apiError(r, err)
+			res.Error = &apiErr
+		} else {
+			res.Response = &sr
+		}
+		if s != nil {
+			s.send("result", res)
+		}
+		resp.Results = append(resp.Results, res)
+	}
+	if s != nil {
+		s.send("done", struct{}{})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// batchScoreItem scores one request of a batch.
+func batchScoreItem(r *http.Request, req ScoreRequest, version string, progress func(ScoreProgress)) (ScoreResponse, error) {
+	buf := imaging.GetBuffer()
+	defer imaging.PutBuffer(buf)
+	var errs fieldErrors
+	var img []byte
+	if err := imaging.DecodeBase64Into(buf, req.ImageBase64); err != nil {
+		errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidBase64, Field: "image_base64", Msg: "bad image: " + err.Error()})
+	} else {
+		img = buf.Bytes()
+	}
+	maskBuf := imaging.GetBuffer()
+	defer imaging.PutBuffer(maskBuf)
+	mask, err := readMaskBase64(maskBuf, req.MaskBase64)
+	errs.add(err)
+
+	p := scoreRequestParams(r, req, img, mask, version)
+	p.IdempotencyKey, p.Progress = "", progress
+	if len(errs) > 0 {
+		errs.add(p.validate())
+		return ScoreResponse{}, errs.err()
+	}
+	resp, _, err := scoreSubmission(r.Context(), p)
+	return resp, err
+}
@@ -0,0 +1,4 @@
+// Package iropicov1 holds the generated gRPC bindings for the scoring API.
+package iropicov1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative iropico/v1/iropico.proto
@@ -0,0 +1,1257 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v28.3.0
+// source: iropico/v1/iropico.proto
+
+package iropicov1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ScoreRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Encoded PNG, JPEG or GIF.
+	Image []byte `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	// "#RRGGBB", or any CSS color: #RGB, rgb(), hsl() or a color name.
+	// Alpha is ignored.
+	ThemeHex string `protobuf:"bytes,2,opt,name=theme_hex,json=themeHex,proto3" json:"theme_hex,omitempty"`
+	// Empty fields use the server defaults.
+	Metric        string `protobuf:"bytes,3,opt,name=metric,proto3" json:"metric,omitempty"`
+	Aggregation   string `protobuf:"bytes,4,opt,name=aggregation,proto3" json:"aggregation,omitempty"`
+	Normalization string `protobuf:"bytes,5,opt,name=normalization,proto3" json:"normalization,omitempty"`
+	// When the client captured the image, in Unix milliseconds; optional,
+	// used for latency reporting.
+	CapturedAtMs int64 `protobuf:"varint,6,opt,name=captured_at_ms,json=capturedAtMs,proto3" json:"captured_at_ms,omitempty"`
+	// Scoring semantics, as in the HTTP /v1 and /v2 routes; empty is "v1".
+	ScoringVersion string `protobuf:"bytes,7,opt,name=scoring_version,json=scoringVersion,proto3" json:"scoring_version,omitempty"`
+	// Treatment of transparent pixels: "alpha", "white", "theme" or
+	// "ignore"; empty uses the server default.
+	Background string `protobuf:"bytes,8,opt,name=background,proto3" json:"background,omitempty"`
+	// "on" scores by lightness, "auto" does so for near-gray themes, "off"
+	// never; empty uses the server default.
+	Grayscale string `protobuf:"bytes,9,opt,name=grayscale,proto3" json:"grayscale,omitempty"`
+	// Language of ScoreResponse.feedback: "ja" or "en"; empty is "ja".
+	Lang string `protobuf:"bytes,10,opt,name=lang,proto3" json:"lang,omitempty"`
+	// Optional encoded image of image's size: white pixels are scored, black
+	// or transparent ones ignored, and grays weigh in between.
+	Mask []byte `protobuf:"bytes,11,opt,name=mask,proto3" json:"mask,omitempty"`
+	// Also score under every registered metric and aggregation, in
+	// ScoreResponse.all_methods.
+	IncludeAllMethods bool `protobuf:"varint,12,opt,name=include_all_methods,json=includeAllMethods,proto3" json:"include_all_methods,omitempty"`
+	// Places the grid of pixels sampled, as in the HTTP API; 0 is the
+	// default grid.
+	Seed uint32 `protobuf:"varint,13,opt,name=seed,proto3" json:"seed,omitempty"`
+	// Whose color vision to score as: "normal", "protanopia",
+	// "deuteranopia" or "tritanopia"; empty is "normal".
+	Vision        string `protobuf:"bytes,14,opt,name=vision,proto3" json:"vision,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScoreRequest) Reset() {
+	*x = ScoreRequest{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScoreRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScoreRequest) ProtoMessage() {}
+
+func (x *ScoreRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScoreRequest.ProtoReflect.Descriptor instead.
+func (*ScoreRequest) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ScoreRequest) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *ScoreRequest) GetThemeHex() string {
+	if x != nil {
+		return x.ThemeHex
+	}
+	return ""
+}
+
+func (x *ScoreRequest) GetMetric() string {
+	if x != nil {
+		return x.Metric
+	}
+	return ""
+}
+
+func (x *ScoreRequest) GetAggregation() string {
+	if x != nil {
+		return x.Aggregation
+	}
+	return ""
+}
+
+func (x *ScoreRequest) GetNormalization() string {
+	if x != nil {
+		return x.Normalization
+	}
+	return ""
+}
+
+func (x *ScoreRequest) GetCapturedAtMs() int64 {
+	if x != nil {
+		return x.CapturedAtMs
+	}
+	return 0
+}
+
+func (x *ScoreRequest) GetScoringVersion() string {
+	if x != nil {
+		return x.ScoringVersion
+	}
+	return ""
+}
+
+func (x *ScoreRequest) GetBackground() string {
+	if x != nil {
+		return x.Background
+	}
+	return ""
+}
+
+func (x *ScoreRequest) GetGrayscale() string {
+	if x != nil {
+		return x.Grayscale
+	}
+	return ""
+}
+
+func (x *ScoreRequest) GetLang() string {
+	if x != nil {
+		return x.Lang
+	}
+	return ""
+}
+
+func (x *ScoreRequest) GetMask() []byte {
+	if x != nil {
+		return x.Mask
+	}
+	return nil
+}
+
+func (x *ScoreRequest) GetIncludeAllMethods() bool {
+	if x != nil {
+		return x.IncludeAllMethods
+	}
+	return false
+}
+
+func (x *ScoreRequest) GetSeed() uint32 {
+	if x != nil {
+		return x.Seed
+	}
+	return 0
+}
+
+func (x *ScoreRequest) GetVision() string {
+	if x != nil {
+		return x.Vision
+	}
+	return ""
+}
+
+type ScoreResponse struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Score       float64                `protobuf:"fixed64,1,opt,name=score,proto3" json:"score,omitempty"`
+	AvgColorHex string                 `protobuf:"bytes,2,opt,name=avg_color_hex,json=avgColorHex,proto3" json:"avg_color_hex,omitempty"`
+	Method      string                 `protobuf:"bytes,3,opt,name=method,proto3" json:"method,omitempty"`
+	UserId      string                 `protobuf:"bytes,4,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	// Set when the call used a sandbox API key.
+	Sandbox bool `protobuf:"varint,5,opt,name=sandbox,proto3" json:"sandbox,omitempty"`
+	// The average color in other models, as in the HTTP API.
+	AvgColorHsl   *HSL   `protobuf:"bytes,6,opt,name=avg_color_hsl,json=avgColorHsl,proto3" json:"avg_color_hsl,omitempty"`
+	AvgColorLab   *Lab   `protobuf:"bytes,7,opt,name=avg_color_lab,json=avgColorLab,proto3" json:"avg_color_lab,omitempty"`
+	AvgColorOklch *OKLCH `protobuf:"bytes,8,opt,name=avg_color_oklch,json=avgColorOklch,proto3" json:"avg_color_oklch,omitempty"`
+	// A sentence or two for the player on how the photo differs from the
+	// theme.
+	Feedback string `protobuf:"bytes,9,opt,name=feedback,proto3" json:"feedback,omitempty"`
+	// Up to three color clusters of the image nearest the theme, nearest
+	// first.
+	ClosestClusters []*ColorCluster `protobuf:"bytes,10,rep,name=closest_clusters,json=closestClusters,proto3" json:"closest_clusters,omitempty"`
+	// With include_all_methods, the score under each registered method.
+	AllMethods []*MethodScore `protobuf:"bytes,11,rep,name=all_methods,json=allMethods,proto3" json:"all_methods,omitempty"`
+	// Nearest CSS and traditional Japanese color names to the average color.
+	AvgColorNames *ColorNames `protobuf:"bytes,12,opt,name=avg_color_names,json=avgColorNames,proto3" json:"avg_color_names,omitempty"`
+	// Archive key of the image (its SHA-256) when archiving is on.
+	ImageId string `protobuf:"bytes,13,opt,name=image_id,json=imageId,proto3" json:"image_id,omitempty"`
+	// Signed URL of the archived image; only with an object-store archive.
+	ImageUrl string `protobuf:"bytes,14,opt,name=image_url,json=imageUrl,proto3" json:"image_url,omitempty"`
+	// Running experiment the caller was assigned a variant of, by user ID or
+	// x-session-id metadata, and the variant that scored; empty when not
+	// assigned.
+	Experiment    string `protobuf:"bytes,15,opt,name=experiment,proto3" json:"experiment,omitempty"`
+	Variant       string `protobuf:"bytes,16,opt,name=variant,proto3" json:"variant,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScoreResponse) Reset() {
+	*x = ScoreResponse{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScoreResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScoreResponse) ProtoMessage() {}
+
+func (x *ScoreResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScoreResponse.ProtoReflect.Descriptor instead.
+func (*ScoreResponse) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ScoreResponse) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *ScoreResponse) GetAvgColorHex() string {
+	if x != nil {
+		return x.AvgColorHex
+	}
+	return ""
+}
+
+func (x *ScoreResponse) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *ScoreResponse) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *ScoreResponse) GetSandbox() bool {
+	if x != nil {
+		return x.Sandbox
+	}
+	return false
+}
+
+func (x *ScoreResponse) GetAvgColorHsl() *HSL {
+	if x != nil {
+		return x.AvgColorHsl
+	}
+	return nil
+}
+
+func (x *ScoreResponse) GetAvgColorLab() *Lab {
+	if x != nil {
+		return x.AvgColorLab
+	}
+	return nil
+}
+
+func (x *ScoreResponse) GetAvgColorOklch() *OKLCH {
+	if x != nil {
+		return x.AvgColorOklch
+	}
+	return nil
+}
+
+func (x *ScoreResponse) GetFeedback() string {
+	if x != nil {
+		return x.Feedback
+	}
+	return ""
+}
+
+func (x *ScoreResponse) GetClosestClusters() []*ColorCluster {
+	if x != nil {
+		return x.ClosestClusters
+	}
+	return nil
+}
+
+func (x *ScoreResponse) GetAllMethods() []*MethodScore {
+	if x != nil {
+		return x.AllMethods
+	}
+	return nil
+}
+
+func (x *ScoreResponse) GetAvgColorNames() *ColorNames {
+	if x != nil {
+		return x.AvgColorNames
+	}
+	return nil
+}
+
+func (x *ScoreResponse) GetImageId() string {
+	if x != nil {
+		return x.ImageId
+	}
+	return ""
+}
+
+func (x *ScoreResponse) GetImageUrl() string {
+	if x != nil {
+		return x.ImageUrl
+	}
+	return ""
+}
+
+func (x *ScoreResponse) GetExperiment() string {
+	if x != nil {
+		return x.Experiment
+	}
+	return ""
+}
+
+func (x *ScoreResponse) GetVariant() string {
+	if x != nil {
+		return x.Variant
+	}
+	return ""
+}
+
+type ColorNames struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Css           *NamedColor            `protobuf:"bytes,1,opt,name=css,proto3" json:"css,omitempty"`
+	Japanese      *NamedColor            `protobuf:"bytes,2,opt,name=japanese,proto3" json:"japanese,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ColorNames) Reset() {
+	*x = ColorNames{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ColorNames) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ColorNames) ProtoMessage() {}
+
+func (x *ColorNames) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ColorNames.ProtoReflect.Descriptor instead.
+func (*ColorNames) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ColorNames) GetCss() *NamedColor {
+	if x != nil {
+		return x.Css
+	}
+	return nil
+}
+
+func (x *ColorNames) GetJapanese() *NamedColor {
+	if x != nil {
+		return x.Japanese
+	}
+	return nil
+}
+
+// A named color, and its CIEDE2000 difference from the color it names.
+type NamedColor struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Hex           string                 `protobuf:"bytes,2,opt,name=hex,proto3" json:"hex,omitempty"`
+	Distance      float64                `protobuf:"fixed64,3,opt,name=distance,proto3" json:"distance,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NamedColor) Reset() {
+	*x = NamedColor{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NamedColor) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NamedColor) ProtoMessage() {}
+
+func (x *NamedColor) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NamedColor.ProtoReflect.Descriptor instead.
+func (*NamedColor) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *NamedColor) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *NamedColor) GetHex() string {
+	if x != nil {
+		return x.Hex
+	}
+	return ""
+}
+
+func (x *NamedColor) GetDistance() float64 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+type MethodScore struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Method        string                 `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	Score         float64                `protobuf:"fixed64,2,opt,name=score,proto3" json:"score,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MethodScore) Reset() {
+	*x = MethodScore{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MethodScore) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MethodScore) ProtoMessage() {}
+
+func (x *MethodScore) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MethodScore.ProtoReflect.Descriptor instead.
+func (*MethodScore) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *MethodScore) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *MethodScore) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+// A group of similar pixels: its mean color, its distance to the theme in
+// the scoring metric's units and its share of the image.
+type ColorCluster struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hex           string                 `protobuf:"bytes,1,opt,name=hex,proto3" json:"hex,omitempty"`
+	Distance      float64                `protobuf:"fixed64,2,opt,name=distance,proto3" json:"distance,omitempty"`
+	Share         float64                `protobuf:"fixed64,3,opt,name=share,proto3" json:"share,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ColorCluster) Reset() {
+	*x = ColorCluster{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ColorCluster) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ColorCluster) ProtoMessage() {}
+
+func (x *ColorCluster) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ColorCluster.ProtoReflect.Descriptor instead.
+func (*ColorCluster) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ColorCluster) GetHex() string {
+	if x != nil {
+		return x.Hex
+	}
+	return ""
+}
+
+func (x *ColorCluster) GetDistance() float64 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+func (x *ColorCluster) GetShare() float64 {
+	if x != nil {
+		return x.Share
+	}
+	return 0
+}
+
+// Hue in degrees; saturation and lightness in percent.
+type HSL struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	H             float64                `protobuf:"fixed64,1,opt,name=h,proto3" json:"h,omitempty"`
+	S             float64                `protobuf:"fixed64,2,opt,name=s,proto3" json:"s,omitempty"`
+	L             float64                `protobuf:"fixed64,3,opt,name=l,proto3" json:"l,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HSL) Reset() {
+	*x = HSL{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HSL) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HSL) ProtoMessage() {}
+
+func (x *HSL) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HSL.ProtoReflect.Descriptor instead.
+func (*HSL) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *HSL) GetH() float64 {
+	if x != nil {
+		return x.H
+	}
+	return 0
+}
+
+func (x *HSL) GetS() float64 {
+	if x != nil {
+		return x.S
+	}
+	return 0
+}
+
+func (x *HSL) GetL() float64 {
+	if x != nil {
+		return x.L
+	}
+	return 0
+}
+
+// CIELAB with a D65 white point.
+type Lab struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	L             float64                `protobuf:"fixed64,1,opt,name=l,proto3" json:"l,omitempty"`
+	A             float64                `protobuf:"fixed64,2,opt,name=a,proto3" json:"a,omitempty"`
+	B             float64                `protobuf:"fixed64,3,opt,name=b,proto3" json:"b,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Lab) Reset() {
+	*x = Lab{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Lab) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Lab) ProtoMessage() {}
+
+func (x *Lab) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Lab.ProtoReflect.Descriptor instead.
+func (*Lab) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Lab) GetL() float64 {
+	if x != nil {
+		return x.L
+	}
+	return 0
+}
+
+func (x *Lab) GetA() float64 {
+	if x != nil {
+		return x.A
+	}
+	return 0
+}
+
+func (x *Lab) GetB() float64 {
+	if x != nil {
+		return x.B
+	}
+	return 0
+}
+
+// Lightness in [0, 1], chroma, and hue in degrees.
+type OKLCH struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	L             float64                `protobuf:"fixed64,1,opt,name=l,proto3" json:"l,omitempty"`
+	C             float64                `protobuf:"fixed64,2,opt,name=c,proto3" json:"c,omitempty"`
+	H             float64                `protobuf:"fixed64,3,opt,name=h,proto3" json:"h,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OKLCH) Reset() {
+	*x = OKLCH{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OKLCH) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OKLCH) ProtoMessage() {}
+
+func (x *OKLCH) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OKLCH.ProtoReflect.Descriptor instead.
+func (*OKLCH) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *OKLCH) GetL() float64 {
+	if x != nil {
+		return x.L
+	}
+	return 0
+}
+
+func (x *OKLCH) GetC() float64 {
+	if x != nil {
+		return x.C
+	}
+	return 0
+}
+
+func (x *OKLCH) GetH() float64 {
+	if x != nil {
+		return x.H
+	}
+	return 0
+}
+
+type BatchScoreRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Requests      []*ScoreRequest        `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchScoreRequest) Reset() {
+	*x = BatchScoreRequest{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchScoreRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchScoreRequest) ProtoMessage() {}
+
+func (x *BatchScoreRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchScoreRequest.ProtoReflect.Descriptor instead.
+func (*BatchScoreRequest) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *BatchScoreRequest) GetRequests() []*ScoreRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+type BatchScoreResult struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Response *ScoreResponse         `protobuf:"bytes,1,opt,name=response,proto3" json:"response,omitempty"`
+	// Set instead of response when this item failed.
+	Error string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	// Stable code for error, e.g. "INVALID_THEME_HEX".
+	ErrorCode     string `protobuf:"bytes,3,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchScoreResult) Reset() {
+	*x = BatchScoreResult{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchScoreResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchScoreResult) ProtoMessage() {}
+
+func (x *BatchScoreResult) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchScoreResult.ProtoReflect.Descriptor instead.
+func (*BatchScoreResult) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *BatchScoreResult) GetResponse() *ScoreResponse {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *BatchScoreResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *BatchScoreResult) GetErrorCode() string {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ""
+}
+
+type BatchScoreResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// In the same order as BatchScoreRequest.requests.
+	Results       []*BatchScoreResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchScoreResponse) Reset() {
+	*x = BatchScoreResponse{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchScoreResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchScoreResponse) ProtoMessage() {}
+
+func (x *BatchScoreResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchScoreResponse.ProtoReflect.Descriptor instead.
+func (*BatchScoreResponse) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *BatchScoreResponse) GetResults() []*BatchScoreResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type ExtractPaletteRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Image []byte                 `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	// Number of colors to return; 0 means the server default.
+	Count         int32 `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExtractPaletteRequest) Reset() {
+	*x = ExtractPaletteRequest{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExtractPaletteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExtractPaletteRequest) ProtoMessage() {}
+
+func (x *ExtractPaletteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExtractPaletteRequest.ProtoReflect.Descriptor instead.
+func (*ExtractPaletteRequest) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ExtractPaletteRequest) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *ExtractPaletteRequest) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type PaletteColor struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Hex   string                 `protobuf:"bytes,1,opt,name=hex,proto3" json:"hex,omitempty"`
+	// Share of the image's (alpha-weighted) pixels, in [0, 1].
+	Proportion    float64 `protobuf:"fixed64,2,opt,name=proportion,proto3" json:"proportion,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PaletteColor) Reset() {
+	*x = PaletteColor{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PaletteColor) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PaletteColor) ProtoMessage() {}
+
+func (x *PaletteColor) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PaletteColor.ProtoReflect.Descriptor instead.
+func (*PaletteColor) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *PaletteColor) GetHex() string {
+	if x != nil {
+		return x.Hex
+	}
+	return ""
+}
+
+func (x *PaletteColor) GetProportion() float64 {
+	if x != nil {
+		return x.Proportion
+	}
+	return 0
+}
+
+type ExtractPaletteResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Ordered by descending proportion.
+	Colors        []*PaletteColor `protobuf:"bytes,1,rep,name=colors,proto3" json:"colors,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExtractPaletteResponse) Reset() {
+	*x = ExtractPaletteResponse{}
+	mi := &file_iropico_v1_iropico_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExtractPaletteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExtractPaletteResponse) ProtoMessage() {}
+
+func (x *ExtractPaletteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_iropico_v1_iropico_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExtractPaletteResponse.ProtoReflect.Descriptor instead.
+func (*ExtractPaletteResponse) Descriptor() ([]byte, []int) {
+	return file_iropico_v1_iropico_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ExtractPaletteResponse) GetColors() []*PaletteColor {
+	if x != nil {
+		return x.Colors
+	}
+	return nil
+}
+
+var File_iropico_v1_iropico_proto protoreflect.FileDescriptor
+
+const file_iropico_v1_iropico_proto_rawDesc = "" +
+	"\n" +
+	"\x18iropico/v1/iropico.proto\x12\n" +
+	"iropico.v1\"\xb2\x03\n" +
+	"\fScoreRequest\x12\x14\n" +
+	"\x05image\x18\x01 \x01(\fR\x05image\x12\x1b\n" +
+	"\ttheme_hex\x18\x02 \x01(\tR\bthemeHex\x12\x16\n" +
+	"\x06metric\x18\x03 \x01(\tR\x06metric\x12 \n" +
+	"\vaggregation\x18\x04 \x01(\tR\vaggregation\x12$\n" +
+	"\rnormalization\x18\x05 \x01(\tR\rnormalization\x12$\n" +
+	"\x0ecaptured_at_ms\x18\x06 \x01(\x03R\fcapturedAtMs\x12'\n" +
+	"\x0fscoring_version\x18\a \x01(\tR\x0escoringVersion\x12\x1e\n" +
+	"\n" +
+	"background\x18\b \x01(\tR\n" +
+	"background\x12\x1c\n" +
+	"\tgrayscale\x18\t \x01(\tR\tgrayscale\x12\x12\n" +
+	"\x04lang\x18\n" +
+	" \x01(\tR\x04lang\x12\x12\n" +
+	"\x04mask\x18\v \x01(\fR\x04mask\x12.\n" +
+	"\x13include_all_methods\x18\f \x01(\bR\x11includeAllMethods\x12\x12\n" +
+	"\x04seed\x18\r \x01(\rR\x04seed\x12\x16\n" +
+	"\x06vision\x18\x0e \x01(\tR\x06vision\"\x86\x05\n" +
+	"\rScoreResponse\x12\x14\n" +
+	"\x05score\x18\x01 \x01(\x01R\x05score\x12\"\n" +
+	"\ravg_color_hex\x18\x02 \x01(\tR\vavgColorHex\x12\x16\n" +
+	"\x06method\x18\x03 \x01(\tR\x06method\x12\x17\n" +
+	"\auser_id\x18\x04 \x01(\tR\x06userId\x12\x18\n" +
+	"\asandbox\x18\x05 \x01(\bR\asandbox\x123\n" +
+	"\ravg_color_hsl\x18\x06 \x01(\v2\x0f.iropico.v1.HSLR\vavgColorHsl\x123\n" +
+	"\ravg_color_lab\x18\a \x01(\v2\x0f.iropico.v1.LabR\vavgColorLab\x129\n" +
+	"\x0favg_color_oklch\x18\b \x01(\v2\x11.iropico.v1.OKLCHR\ravgColorOklch\x12\x1a\n" +
+	"\bfeedback\x18\t \x01(\tR\bfeedback\x12C\n" +
+	"\x10closest_clusters\x18\n" +
+	" \x03(\v2\x18.iropico.v1.ColorClusterR\x0fclosestClusters\x128\n" +
+	"\vall_methods\x18\v \x03(\v2\x17.iropico.v1.MethodScoreR\n" +
+	"allMethods\x12>\n" +
+	"\x0favg_color_names\x18\f \x01(\v2\x16.iropico.v1.ColorNamesR\ravgColorNames\x12\x19\n" +
+	"\bimage_id\x18\r \x01(\tR\aimageId\x12\x1b\n" +
+	"\timage_url\x18\x0e \x01(\tR\bimageUrl\x12\x1e\n" +
+	"\n" +
+	"experiment\x18\x0f \x01(\tR\n" +
+	"experiment\x12\x18\n" +
+	"\avariant\x18\x10 \x01(\tR\avariant\"j\n" +
+	"\n" +
+	"ColorNames\x12(\n" +
+	"\x03css\x18\x01 \x01(\v2\x16.iropico.v1.NamedColorR\x03css\x122\n" +
+	"\bjapanese\x18\x02 \x01(\v2\x16.iropico.v1.NamedColorR\bjapanese\"N\n" +
+	"\n" +
+	"NamedColor\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x10\n" +
+	"\x03hex\x18\x02 \x01(\tR\x03hex\x12\x1a\n" +
+	"\bdistance\x18\x03 \x01(\x01R\bdistance\";\n" +
+	"\vMethodScore\x12\x16\n" +
+	"\x06method\x18\x01 \x01(\tR\x06method\x12\x14\n" +
+	"\x05score\x18\x02 \x01(\x01R\x05score\"R\n" +
+	"\fColorCluster\x12\x10\n" +
+	"\x03hex\x18\x01 \x01(\tR\x03hex\x12\x1a\n" +
+	"\bdistance\x18\x02 \x01(\x01R\bdistance\x12\x14\n" +
+	"\x05share\x18\x03 \x01(\x01R\x05share\"/\n" +
+	"\x03HSL\x12\f\n" +
+	"\x01h\x18\x01 \x01(\x01R\x01h\x12\f\n" +
+	"\x01s\x18\x02 \x01(\x01R\x01s\x12\f\n" +
+	"\x01l\x18\x03 \x01(\x01R\x01l\"/\n" +
+	"\x03Lab\x12\f\n" +
+	"\x01l\x18\x01 \x01(\x01R\x01l\x12\f\n" +
+	"\x01a\x18\x02 \x01(\x01R\x01a\x12\f\n" +
+	"\x01b\x18\x03 \x01(\x01R\x01b\"1\n" +
+	"\x05OKLCH\x12\f\n" +
+	"\x01l\x18\x01 \x01(\x01R\x01l\x12\f\n" +
+	"\x01c\x18\x02 \x01(\x01R\x01c\x12\f\n" +
+	"\x01h\x18\x03 \x01(\x01R\x01h\"I\n" +
+	"\x11BatchScoreRequest\x124\n" +
+	"\brequests\x18\x01 \x03(\v2\x18.iropico.v1.ScoreRequestR\brequests\"~\n" +
+	"\x10BatchScoreResult\x125\n" +
+	"\bresponse\x18\x01 \x01(\v2\x19.iropico.v1.ScoreResponseR\bresponse\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\x03 \x01(\tR\terrorCode\"L\n" +
+	"\x12BatchScoreResponse\x126\n" +
+	"\aresults\x18\x01 \x03(\v2\x1c.iropico.v1.BatchScoreResultR\aresults\"C\n" +
+	"\x15ExtractPaletteRequest\x12\x14\n" +
+	"\x05image\x18\x01 \x01(\fR\x05image\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x05R\x05count\"@\n" +
+	"\fPaletteColor\x12\x10\n" +
+	"\x03hex\x18\x01 \x01(\tR\x03hex\x12\x1e\n" +
+	"\n" +
+	"proportion\x18\x02 \x01(\x01R\n" +
+	"proportion\"J\n" +
+	"\x16ExtractPaletteResponse\x120\n" +
+	"\x06colors\x18\x01 \x03(\v2\x18.iropico.v1.PaletteColorR\x06colors2\xf4\x01\n" +
+	"\x0eScoringService\x12<\n" +
+	"\x05Score\x12\x18.iropico.v1.ScoreRequest\x1a\x19.iropico.v1.ScoreResponse\x12K\n" +
+	"\n" +
+	"BatchScore\x12\x1d.iropico.v1.BatchScoreRequest\x1a\x1e.iropico.v1.BatchScoreResponse\x12W\n" +
+	"\x0eExtractPalette\x12!.iropico.v1.ExtractPaletteRequest\x1a\".iropico.v1.ExtractPaletteResponseBGZEgithub.com/hiromuota166/iropico_color_calc/proto/iropico/v1;iropicov1b\x06proto3"
+
+var (
+	file_iropico_v1_iropico_proto_rawDescOnce sync.Once
+	file_iropico_v1_iropico_proto_rawDescData []byte
+)
+
+func file_iropico_v1_iropico_proto_rawDescGZIP() []byte {
+	file_iropico_v1_iropico_proto_rawDescOnce.Do(func() {
+		file_iropico_v1_iropico_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_iropico_v1_iropico_proto_rawDesc), len(file_iropico_v1_iropico_proto_rawDesc)))
+	})
+	return file_iropico_v1_iropico_proto_rawDescData
+}
+
+var file_iropico_v1_iropico_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
+var file_iropico_v1_iropico_proto_goTypes = []any{
+	(*ScoreRequest)(nil),           // 0: iropico.v1.ScoreRequest
+	(*ScoreResponse)(nil),          // 1: iropico.v1.ScoreResponse
+	(*ColorNames)(nil),             // 2: iropico.v1.ColorNames
+	(*NamedColor)(nil),             // 3: iropico.v1.NamedColor
+	(*MethodScore)(nil),            // 4: iropico.v1.MethodScore
+	(*ColorCluster)(nil),           // 5: iropico.v1.ColorCluster
+	(*HSL)(nil),                    // 6: iropico.v1.HSL
+	(*Lab)(nil),                    // 7: iropico.v1.Lab
+	(*OKLCH)(nil),                  // 8: iropico.v1.OKLCH
+	(*BatchScoreRequest)(nil),      // 9: iropico.v1.BatchScoreRequest
+	(*BatchScoreResult)(nil),       // 10: iropico.v1.BatchScoreResult
+	(*BatchScoreResponse)(nil),     // 11: iropico.v1.BatchScoreResponse
+	(*ExtractPaletteRequest)(nil),  // 12: iropico.v1.ExtractPaletteRequest
+	(*PaletteColor)(nil),           // 13: iropico.v1.PaletteColor
+	(*ExtractPaletteResponse)(nil), // 14: iropico.v1.ExtractPaletteResponse
+}
+var file_iropico_v1_iropico_proto_depIdxs = []int32{
+	6,  // 0: iropico.v1.ScoreResponse.avg_color_hsl:type_name -> iropico.v1.HSL
+	7,  // 1: iropico.v1.ScoreResponse.avg_color_lab:type_name -> iropico.v1.Lab
+	8,  // 2: iropico.v1.ScoreResponse.avg_color_oklch:type_name -> iropico.v1.OKLCH
+	5,  // 3: iropico.v1.ScoreResponse.closest_clusters:type_name -> iropico.v1.ColorCluster
+	4,  // 4: iropico.v1.ScoreResponse.all_methods:type_name -> iropico.v1.MethodScore
+	2,  // 5: iropico.v1.ScoreResponse.avg_color_names:type_name -> iropico.v1.ColorNames
+	3,  // 6: iropico.v1.ColorNames.css:type_name -> iropico.v1.NamedColor
+	3,  // 7: iropico.v1.ColorNames.japanese:type_name -> iropico.v1.NamedColor
+	0,  // 8: iropico.v1.BatchScoreRequest.requests:type_name -> iropico.v1.ScoreRequest
+	1,  // 9: iropico.v1.BatchScoreResult.response:type_name -> iropico.v1.ScoreResponse
+	10, // 10: iropico.v1.BatchScoreResponse.results:type_name -> iropico.v1.BatchScoreResult
+	13, // 11: iropico.v1.ExtractPaletteResponse.colors:type_name -> iropico.v1.PaletteColor
+	0,  // 12: iropico.v1.ScoringService.Score:input_type -> iropico.v1.ScoreRequest
+	9,  // 13: iropico.v1.ScoringService.BatchScore:input_type -> iropico.v1.BatchScoreRequest
+	12, // 14: iropico.v1.ScoringService.ExtractPalette:input_type -> iropico.v1.ExtractPaletteRequest
+	1,  // 15: iropico.v1.ScoringService.Score:output_type -> iropico.v1.ScoreResponse
+	11, // 16: iropico.v1.ScoringService.BatchScore:output_type -> iropico.v1.BatchScoreResponse
+	14, // 17: iropico.v1.ScoringService.ExtractPalette:output_type -> iropico.v1.ExtractPaletteResponse
+	15, // [15:18] is the sub-list for method output_type
+	12, // [12:15] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
+}
+
+func init() { file_iropico_v1_iropico_proto_init() }
+func file_iropico_v1_iropico_proto_init() {
+	if File_iropico_v1_iropico_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_iropico_v1_iropico_proto_rawDesc), len(file_iropico_v1_iropico_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   15,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_iropico_v1_iropico_proto_goTypes,
+		DependencyIndexes: file_iropico_v1_iropico_proto_depIdxs,
+		MessageInfos:      file_iropico_v1_iropico_proto_msgTypes,
+	}.Build()
+	File_iropico_v1_iropico_proto = out.File
+	file_iropico_v1_iropico_proto_goTypes = nil
+	file_iropico_v1_iropico_proto_depIdxs = nil
+}
@@ -0,0 +1,207 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v28.3.0
+// source: iropico/v1/iropico.proto
+
+package iropicov1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ScoringService_Score_FullMethodName          = "/iropico.v1.ScoringService/Score"
+	ScoringService_BatchScore_FullMethodName     = "/iropico.v1.ScoringService/BatchScore"
+	ScoringService_ExtractPalette_FullMethodName = "/iropico.v1.ScoringService/ExtractPalette"
+)
+
+// ScoringServiceClient is the client API for ScoringService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ScoringService exposes the same scoring core as the HTTP API, with raw
+// image bytes instead of base64.
+type ScoringServiceClient interface {
+	Score(ctx context.Context, in *ScoreRequest, opts ...grpc.CallOption) (*ScoreResponse, error)
+	// BatchScore scores each request independently; one bad image does not
+	// fail the batch.
+	BatchScore(ctx context.Context, in *BatchScoreRequest, opts ...grpc.CallOption) (*BatchScoreResponse, error)
+	ExtractPalette(ctx context.Context, in *ExtractPaletteRequest, opts ...grpc.CallOption) (*ExtractPaletteResponse, error)
+}
+
+type scoringServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewScoringServiceClient(cc grpc.ClientConnInterface) ScoringServiceClient {
+	return &scoringServiceClient{cc}
+}
+
+func (c *scoringServiceClient) Score(ctx context.Context, in *ScoreRequest, opts ...grpc.CallOption) (*ScoreResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ScoreResponse)
+	err := c.cc.Invoke(ctx, ScoringService_Score_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scoringServiceClient) BatchScore(ctx context.Context, in *BatchScoreRequest, opts ...grpc.CallOption) (*BatchScoreResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchScoreResponse)
+	err := c.cc.Invoke(ctx, ScoringService_BatchScore_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scoringServiceClient) ExtractPalette(ctx context.Context, in *ExtractPaletteRequest, opts ...grpc.CallOption) (*ExtractPaletteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExtractPaletteResponse)
+	err := c.cc.Invoke(ctx, ScoringService_ExtractPalette_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ScoringServiceServer is the server API for ScoringService service.
+// All implementations must embed UnimplementedScoringServiceServer
+// for forward compatibility.
+//
+// ScoringService exposes the same scoring core as the HTTP API, with raw
+// image bytes instead of base64.
+type ScoringServiceServer interface {
+	Score(context.Context, *ScoreRequest) (*ScoreResponse, error)
+	// BatchScore scores each request independently; one bad image does not
+	// fail the batch.
+	BatchScore(context.Context, *BatchScoreRequest) (*BatchScoreResponse, error)
+	ExtractPalette(context.Context, *ExtractPaletteRequest) (*ExtractPaletteResponse, error)
+	mustEmbedUnimplementedScoringServiceServer()
+}
+
+// UnimplementedScoringServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedScoringServiceServer struct{}
+
+func (UnimplementedScoringServiceServer) Score(context.Context, *ScoreRequest) (*ScoreResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Score not implemented")
+}
+func (UnimplementedScoringServiceServer) BatchScore(context.Context, *BatchScoreRequest) (*BatchScoreResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method BatchScore not implemented")
+}
+func (UnimplementedScoringServiceServer) ExtractPalette(context.Context, *ExtractPaletteRequest) (*ExtractPaletteResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ExtractPalette not implemented")
+}
+func (UnimplementedScoringServiceServer) mustEmbedUnimplementedScoringServiceServer() {}
+func (UnimplementedScoringServiceServer) testEmbeddedByValue()                        {}
+
+// UnsafeScoringServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ScoringServiceServer will
+// result in compilation errors.
+type UnsafeScoringServiceServer interface {
+	mustEmbedUnimplementedScoringServiceServer()
+}
+
+func RegisterScoringServiceServer(s grpc.ServiceRegistrar, srv ScoringServiceServer) {
+	// If the following call panics, it indicates UnimplementedScoringServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ScoringService_ServiceDesc, srv)
+}
+
+func _ScoringService_Score_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScoreRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScoringServiceServer).Score(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ScoringService_Score_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScoringServiceServer).Score(ctx, req.(*ScoreRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ScoringService_BatchScore_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchScoreRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScoringServiceServer).BatchScore(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ScoringService_BatchScore_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScoringServiceServer).BatchScore(ctx, req.(*BatchScoreRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ScoringService_ExtractPalette_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtractPaletteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScoringServiceServer).ExtractPalette(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ScoringService_ExtractPalette_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScoringServiceServer).ExtractPalette(ctx, req.(*ExtractPaletteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ScoringService_ServiceDesc is the grpc.ServiceDesc for ScoringService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ScoringService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "iropico.v1.ScoringService",
+	HandlerType: (*ScoringServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Score",
+			Handler:    _ScoringService_Score_Handler,
+		},
+		{
+			MethodName: "BatchScore",
+			Handler:    _ScoringService_BatchScore_Handler,
+		},
+		{
+			MethodName: "ExtractPalette",
+			Handler:    _ScoringService_ExtractPalette_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "iropico/v1/iropico.proto",
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Quotas cap how many submissions a client may have scored per UTC day, on
+// top of the per-minute rate limits: API keys, sandbox keys, and players by
+// user ID or, without an ID token, by IP each have their own cap in the
+// quota config. Rate limits are per instance, so a client reaching several
+// Cloud Run instances gets several allowances; with quota.redis_url set the
+// daily counts live in Redis and every instance shares them. Without it
+// each instance counts on its own.
+//
+// Every submission scored from its image counts, across /score, batches
+// and rooms, over HTTP or gRPC. A submission is charged up front, so a
+// client over its cap is turned away before any work is done, and refunded
+// if it turns out not to count: if it fails or is rejected, if its result
+// came from the cache, or if it was coalesced with an identical submission
+// already in flight, which is charged instead. Idempotent replays and
+// rescored submissions are never charged. If Redis cannot be reached,
+// submissions are let through and the failure is logged, so an outage of
+// the counter does not stop the game.
+
+// quotaCounter counts submissions per key.
+type quotaCounter interface {
+	// add adds n to key's count, creating it to expire at expires, and
+	// returns the new count.
+	add(key string, n int, expires time.Time) (int64, error)
+}
+
+var quotas quotaCounter
+
+// newQuotaCounter returns a Redis counter for url, or an in-memory one if
+// url is empty.
+func newQuotaCounter(url string) (quotaCounter, error) {
+	if url == "" {
+		return &memoryQuota{counts: map[string]*memoryCount{}}, nil
+	}
+	c, err := newRedisClient(url)
+	if err != nil {
+		return nil, err
+	}
+	return redisQuota{c}, nil
+}
+
+type memoryQuota struct {
+	mu     sync.Mutex
+	counts map[string]*memoryCount
+	swept  time.Time
+}
+
+type memoryCount struct {
+	n       int64
+	expires time.Time
+}
+
+func (q *memoryQuota) add(key string, n int, expires time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	if now.Sub(q.swept) > bucketIdle {
+		for k, c := range q.counts {
+			if !now.Before(c.expires) {
+				delete(q.counts, k)
+			}
+		}
+		q.swept = now
+	}
+	c := q.counts[key]
+	if c == nil || !now.Before(c.expires) {
+		c = &memoryCount{expires: expires}
+		q.counts[key] = c
+	}
+	c.n += int64(n)
+	return c.n, nil
+}
+
+type redisQuota struct{ c *redisClient }
+
+// add increments and sets the expiry in one transaction, so no count is
+// left without one.
+func (q redisQuota) add(key string, n int, expires time.Time) (int64, error) {
+	replies, err := q.c.do(
+		[]string{"MULTI"},
+		[]string{"INCRBY", key, strconv.Itoa(n)},
+		[]string{"EXPIREAT", key, strconv.FormatInt(expires.Unix(), 10)},
+		[]string{"EXEC"},
+	)
+	if err != nil {
+		return 0, err
+	}
+	exec, ok := replies[3].([]any)
+	if !ok || len(exec) != 2 {
+		return 0, redisError("unexpected EXEC reply")
+	}
+	switch v := exec[0].(type) {
+	case int64:
+		return v, nil
+	case redisError:
+		return 0, v
+	}
+	return 0, redisError("unexpected INCRBY reply")
+}
+
+// quotaClient is who a submission counts against.
+type quotaClient struct {
+	// Kind is "key", "sandbox", "user" or "ip"; "" exempts the submission.
+	Kind, ID string
+}
+
+// quotaClientFor identifies r's client; see quotaClientOf.
+func quotaClientFor(r *http.Request) quotaClient {
+	return quotaClientOf(principalFrom(r.Context()), r.Header.Get("Authorization"), clientIP(r))
+}
+
+// quotaClientOf identifies the client p authenticated with header, from
+// ip: its API key, hashed, else its user ID, else its IP.
+func quotaClientOf(p principal, header, ip string) quotaClient {
+	switch {
+	case p.APIKey:
+		token, _ := bearerToken(header)
+		sum := sha256.Sum256([]byte(token))
+		kind := "key"
+		if p.Sandbox {
+			kind = "sandbox"
+		}
+		return quotaClient{kind, hex.EncodeToString(sum[:8])}
+	case p.UserID != "":
+		return quotaClient{"user", p.UserID}
+	}
+	return quotaClient{"ip", ip}
+}
+
+// limit returns qc's daily cap under q; 0 is unlimited.
+func (qc quotaClient) limit(q QuotaConfig) int {
+	switch qc.Kind {
+	case "key":
+		return q.APIKeyDailySubmissions
+	case "sandbox":
+		return q.SandboxDailySubmissions
+	case "user", "ip":
+		return q.DailySubmissions
+	}
+	return 0
+}
+
+// quotaLogged throttles logging counter failures to one a minute.
+var quotaLogged atomic.Int64
+
+// chargeQuota counts a submission against qc's quota for now's UTC day, or
+// returns a 429 if that would exceed it. refund, non-nil when a submission
+// was counted, takes it back.
+func chargeQuota(qc quotaClient, now time.Time) (refund func(), err error) {
+	limit := qc.limit(config().Quota)
+	if limit <= 0 || quotas == nil {
+		return nil, nil
+	}
+	now = now.UTC()
+	day := now.Format(statsDay)
+	reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	key := "iropico:quota:" + day + ":" + qc.Kind + ":" + qc.ID
+	n, err := quotas.add(key, 1, reset)
+	if err != nil {
+		logQuotaError(err)
+		return nil, nil
+	}
+	refund = func() {
+		if _, err := quotas.add(key, -1, reset); err != nil {
+			logQuotaError(err)
+		}
+	}
+	if n <= int64(limit) {
+		return refund, nil
+	}
+	// A submission turned away does not count either.
+	refund()
+	return nil, &requestError{Status: http.StatusTooManyRequests, Code: codeQuotaExceeded, Msg: "daily submission quota exceeded",
+		Details: map[string]any{"limit": limit, "reset_at": reset.Format(time.RFC3339), "retry_after_seconds": math.Ceil(reset.Sub(now).Seconds())}}
+}
+
+// logQuotaError logs a counter failure, at most once a minute.
+func logQuotaError(err error) {
+	if t := time.Now().Unix(); t-quotaLogged.Load() >= 60 {
+		quotaLogged.Store(t)
+		log.Printf("quota: %v; letting submissions through", err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a per-client token bucket. It is in-memory, so limits are
+// per instance.
+type rateLimiter struct {
+	perSec float64
+	burst  float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+const bucketIdle = 10 * time.Minute
+
+func newRateLimiter(perMinute float64, burst int) *rateLimiter {
+	return &rateLimiter{perSec: perMinute / 60, burst: float64(burst), buckets: map[string]*bucket{}}
+}
+
+// set changes the rate and burst, keeping each client's bucket.
+func (l *rateLimiter) set(perMinute float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perSec, l.burst = perMinute/60, float64(burst)
+}
+
+// allow takes a token for key, or reports how long until one is available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > bucketIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSec)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.perSec * float64(time.Second))
+}
+
+// rateLimits holds the limiters for the current rate_limit config,
+// retuning them when a config reload changes it.
+type rateLimits struct {
+	mu    sync.Mutex
+	rc    RateLimitConfig
+	l, sl *rateLimiter
+}
+
+// get returns the limiters for rc; sl is nil when sandbox keys are
+// unlimited.
+func (rl *rateLimits) get(rc RateLimitConfig) (l, sl *rateLimiter) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.l != nil && rc == rl.rc {
+		return rl.l, rl.sl
+	}
+	rl.rc = rc
+	if rl.l == nil {
+		rl.l = newRateLimiter(rc.RequestsPerMinute, rc.Burst)
+	} else {
+		rl.l.set(rc.RequestsPerMinute, rc.Burst)
+	}
+	if rc.SandboxRequestsPerMinute <= 0 {
+		rl.sl = nil
+		return rl.l, nil
+	}
+	// Scale the burst with the rate so short test bursts fit too.
+	burst := max(rc.Burst, int(float64(rc.Burst)*rc.SandboxRequestsPerMinute/rc.RequestsPerMinute))
+	if rl.sl == nil {
+		rl.sl = newRateLimiter(rc.SandboxRequestsPerMinute, burst)
+	} else {
+		rl.sl.set(rc.SandboxRequestsPerMinute, burst)
+	}
+	return rl.l, rl.sl
+}
+
+// clientLimits are the buckets the HTTP and gRPC servers share, so a
+// client gets one allowance across both.
+var clientLimits rateLimits
+
+// rateLimit takes a token for the client at ip, or reports how long until
+// one is available. It runs before authentication, so it recognizes
+// sandbox keys by their Authorization header itself and gives them their
+// own, relaxed buckets. It reads the rate_limit config per call, so
+// reloads apply without a restart.
+func rateLimit(auth *authenticator, header, ip string) (bool, time.Duration) {
+	rc := config().RateLimit
+	if rc.RequestsPerMinute <= 0 {
+		return true, 0
+	}
+	l, sl := clientLimits.get(rc)
+	limiter := l
+	if auth.sandboxed(header) {
+		if sl == nil {
+			return true, 0
+		}
+		limiter = sl
+	}
+	return limiter.allow(ip, time.Now())
+}
+
+// rateLimitError is the 429 for a client that must wait.
+func rateLimitError(wait time.Duration) *requestError {
+	return &requestError{Status: http.StatusTooManyRequests, Code: codeRateLimited, Msg: "rate limit exceeded",
+		Details: map[string]any{"retry_after_seconds": math.Ceil(wait.Seconds())}}
+}
+
+func withRateLimit(next http.Handler, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := rateLimit(auth, r.Header.Get("Authorization"), clientIP(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeRequestError(w, r, rateLimitError(wait))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP is the client address of r; see forwardedIP.
+func clientIP(r *http.Request) string {
+	return forwardedIP(r.Header.Values("X-Forwarded-For"), r.RemoteAddr)
+}
+
+// forwardedIP is the X-Forwarded-For hop rate_limit.trusted_proxies from
+// the end: proxies append the address they saw, so hops before theirs are
+// the client's to forge. Cloud Run's front end is one such proxy, for HTTP
+// and gRPC alike. Without that many hops it falls back to remote, the
+// connection's address.
+func forwardedIP(xff []string, remote string) string {
+	if n := config().RateLimit.TrustedProxies; n > 0 {
+		var hops []string
+		for _, h := range xff {
+			hops = append(hops, strings.Split(h, ",")...)
+		}
+		if len(hops) >= n {
+			if hop := strings.TrimSpace(hops[len(hops)-n]); hop != "" {
+				return hop
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return remote
+	}
+	return host
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisClient is a minimal Redis client: it pipelines commands over a small
+// pool of connections and speaks just enough RESP2 for the quota counters.
+
+const (
+	redisPoolSize    = 8
+	redisDialTimeout = 2 * time.Second
+	// redisTimeout bounds one round trip; callers fail open past it.
+	redisTimeout = time.Second
+)
+
+type redisOptions struct {
+	addr, user, password string
+	db                   int
+	tls                  bool
+}
+
+// parseRedisURL parses redis://[user:password@]host[:port][/db], or
+// rediss:// for TLS.
+func parseRedisURL(raw string) (redisOptions, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redisOptions{}, err
+	}
+	o := redisOptions{tls: u.Scheme == "rediss"}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return o, fmt.Errorf("scheme %q, want redis or rediss", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return o, errors.New("missing host")
+	}
+	o.addr = u.Host
+	if u.Port() == "" {
+		o.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		o.user = u.User.Username()
+		o.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if o.db, err = strconv.Atoi(db); err != nil || o.db < 0 {
+			return o, fmt.Errorf("database %q, want a number", db)
+		}
+	}
+	return o, nil
+}
+
+type redisClient struct {
+	opts redisOptions
+	idle chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply; the connection stays usable.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func newRedisClient(raw string) (*redisClient, error) {
+	opts, err := parseRedisURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &redisClient{opts: opts, idle: make(chan *redisConn, redisPoolSize)}, nil
+}
+
+// do sends cmds in one write and returns their replies: int64, string,
+// nil, []any or redisError. The first error reply is also returned as the
+// error.
+func (c *redisClient) do(cmds ...[]string) ([]any, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	replies, err := cn.roundTrip(cmds)
+	if err != nil {
+		var re redisError
+		if !errors.As(err, &re) {
+			cn.Close()
+			return nil, err
+		}
+	}
+	c.put(cn)
+	return replies, err
+}
+
+// ping checks the server answers.
+func (c *redisClient) ping() error {
+	_, err := c.do([]string{"PING"})
+	return err
+}
+
+func (c *redisClient) get() (*redisConn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", c.opts.addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.tls {
+		host, _, _ := net.SplitHostPort(c.opts.addr)
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+	cn := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+	var setup [][]string
+	switch {
+	case c.opts.password != "" && c.opts.user != "":
+		setup = append(setup, []string{"AUTH", c.opts.user, c.opts.password})
+	case c.opts.password != "":
+		setup = append(setup, []string{"AUTH", c.opts.password})
+	}
+	if c.opts.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.opts.db)})
+	}
+	if len(setup) > 0 {
+		if _, err := cn.roundTrip(setup); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *redisClient) put(cn *redisConn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+func (cn *redisConn) roundTrip(cmds [][]string) ([]any, error) {
+	cn.SetDeadline(time.Now().Add(redisTimeout))
+	var b []byte
+	for _, cmd := range cmds {
+		b = fmt.Appendf(b, "*%d\r\n", len(cmd))
+		for _, arg := range cmd {
+			b = fmt.Appendf(b, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := cn.Write(b); err != nil {
+		return nil, err
+	}
+	// Every reply is read, even after an error reply, so the connection
+	// stays in step.
+	replies := make([]any, len(cmds))
+	var first error
+	for i := range cmds {
+		v, err := cn.readReply()
+		if err != nil {
+			return nil, err
+		}
+		if re, ok := v.(redisError); ok && first == nil {
+			first = re
+		}
+		replies[i] = v
+	}
+	return replies, first
+}
+
+func (cn *redisConn) readReply() (any, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	kind, rest := line[0], line[1:]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return redisError(rest), nil
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 { // $-1 is nil
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			if arr[i], err = cn.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
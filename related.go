@@ -0,0 +1,55 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"math"
+	"net/http"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// RelatedThemes are colors a fixed hue angle away from a theme in Oklch, at
+// the theme's lightness and chroma, for multi-color rounds and suggesting
+// the next theme. Oklch keeps the rotated colors about as light and vivid
+// as the theme, which rotating HSL hue does not.
+type RelatedThemes struct {
+	ThemeHex      string         `json:"theme_hex"`
+	Complementary RelatedColor   `json:"complementary" doc:"Hue rotated by 180°."`
+	Analogous     []RelatedColor `json:"analogous" doc:"Hue rotated by -30° and +30°."`
+	Triadic       []RelatedColor `json:"triadic" doc:"Hue rotated by 120° and 240°."`
+}
+
+type RelatedColor struct {
+	Hex   string `json:"hex"`
+	OKLCH OKLCH  `json:"oklch" doc:"Chroma is lower than the theme's where the rotated color would fall outside sRGB."`
+}
+
+func handleRelatedThemes(w http.ResponseWriter, r *http.Request) {
+	hex := cmp.Or(r.URL.Query().Get("hex"), activeTheme.get().ThemeHex)
+	tr, tg, tb, _, err := colormath.ParseColor(hex)
+	if err != nil {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidThemeHex, Field: "hex", Msg: "bad hex: " + err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(relatedThemes(tr, tg, tb))
+}
+
+// relatedThemes rotates the theme's Oklch hue. The hue of a gray is
+// undefined, so a gray's related colors are the gray itself.
+func relatedThemes(tr, tg, tb uint8) RelatedThemes {
+	lin := colormath.SRGB8ToLinear
+	lch := colormath.OklabToOklch(colormath.LinearToOklab(lin(tr), lin(tg), lin(tb)))
+	rotate := func(deg float64) RelatedColor {
+		lr, lg, lb := colormath.OklchToLinear(colormath.Vec3{lch[0], lch[1], math.Mod(lch[2]+deg, 360)})
+		_, _, oklch := avgColorModels(lr, lg, lb)
+		return RelatedColor{Hex: colormath.LinearHex(lr, lg, lb), OKLCH: oklch}
+	}
+	return RelatedThemes{
+		ThemeHex:      "#" + themeKey(tr, tg, tb),
+		Complementary: rotate(180),
+		Analogous:     []RelatedColor{rotate(-30 + 360), rotate(30)},
+		Triadic:       []RelatedColor{rotate(120), rotate(240)},
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/scoring"
+)
+
+// Rescoring recomputes room leaderboards after a scoring change mid-event:
+// the archived submissions of the chosen rooms are scored again under one
+// method, with each room's theme and options, and the new scores are kept
+// next to the original ones. Rooms then report both leaderboards, so
+// every player is ranked by the same algorithm whenever they submitted.
+
+type RoomRescoreRequest struct {
+	RoomIDs        []string  `json:"room_ids,omitempty" doc:"Rooms to rescore; leave empty to select rooms by creation time instead."`
+	From           time.Time `json:"from,omitempty" doc:"RFC 3339; without room_ids, rescore rooms created at or after this time."`
+	To             time.Time `json:"to,omitempty" doc:"RFC 3339; without room_ids, rescore rooms created before this time."`
+	Metric         string    `json:"metric,omitempty" enum:"@metrics"`
+	Aggregation    string    `json:"aggregation,omitempty" enum:"@aggregations"`
+	ScoringVersion string    `json:"scoring_version,omitempty" doc:"Supplies the metric and aggregation, as in the /v1 and /v2 score routes."`
+}
+
+type RoomRescoreResp struct {
+	Method string              `json:"method" doc:"Scorer the submissions were rescored by; rescoring again by it replaces these scores."`
+	Rooms  []RoomRescoreResult `json:"rooms" doc:"Oldest room first."`
+}
+
+type RoomRescoreResult struct {
+	RoomID     string        `json:"room_id"`
+	Rescored   int           `json:"rescored" doc:"Distinct archived images scored again."`
+	Failed     int           `json:"failed" doc:"Archived images that could not be fetched or scored; they keep any earlier rescore."`
+	Unarchived int           `json:"unarchived" doc:"Submissions with no archived image, which keep only their original score."`
+	Rankings   []RoomRanking `json:"rankings" doc:"The room's leaderboard under method, as in Room.rescorings."`
+}
+
+// rescoreTarget is what rescoring a room needs, copied out of the store.
+type rescoreTarget struct {
+	st         roomState // Entries are not safe to read
+	images     []string  // distinct archived image IDs
+	unarchived int
+}
+
+// rescoreTargets returns the rooms named by ids or, with no ids, those
+// created in [from, to), where a zero bound is open; oldest first.
+func (s *roomStore) rescoreTargets(ids []string, from, to time.Time) ([]rescoreTarget, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sts []*roomState
+	if len(ids) > 0 {
+		now := time.Now().UTC()
+		for _, id := range ids {
+			if !validRoomID(id) {
+				return nil, errRoomNotFound
+			}
+			st, err := s.lookup(id, now)
+			if err != nil {
+				return nil, err
+			}
+			if !slices.Contains(sts, st) {
+				sts = append(sts, st)
+			}
+		}
+	} else {
+		for _, st := range s.rooms {
+			if (from.IsZero() || !st.CreatedAt.Before(from)) && (to.IsZero() || st.CreatedAt.Before(to)) {
+				sts = append(sts, st)
+			}
+		}
+	}
+	sort.Slice(sts, func(i, j int) bool { return sts[i].CreatedAt.Before(sts[j].CreatedAt) })
+
+	out := make([]rescoreTarget, len(sts))
+	for i, st := range sts {
+		out[i].st = *st
+		for _, e := range st.Entries {
+			for _, im := range e.Images {
+				if !slices.Contains(out[i].images, im.ImageID) {
+					out[i].images = append(out[i].images, im.ImageID)
+				}
+			}
+			out[i].unarchived += e.Submissions - len(e.Images)
+		}
+	}
+	return out, nil
+}
+
+// saveRescore stores scores, by image ID, as room id's rescore under
+// method, replacing an earlier one under the same method.
+func (s *roomStore) saveRescore(id, method string, scores map[string]float64, at time.Time) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.rooms[id]
+	if st == nil {
+		return Room{}, errRoomNotFound
+	}
+	for _, e := range st.Entries {
+		for i := range e.Images {
+			im := &e.Images[i]
+			if v, ok := scores[im.ImageID]; ok {
+				if im.Rescores == nil {
+					im.Rescores = map[string]float64{}
+				}
+				im.Rescores[method] = v
+			}
+		}
+	}
+	st.Rescorings = slices.DeleteFunc(st.Rescorings, func(r roomRescoring) bool { return r.Method == method })
+	st.Rescorings = append(st.Rescorings, roomRescoring{Method: method, At: at})
+	if err := s.write(st); err != nil {
+		return Room{}, err
+	}
+	return st.view(), nil
+}
+
+// handleRescoreRooms rescores the selected rooms one after another; a
+// client that disconnects stops it after the room in progress, keeping the
+// rooms already done.
+func handleRescoreRooms(w http.ResponseWriter, r *http.Request) {
+	var req RoomRescoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, r, bodyError(err))
+		return
+	}
+	ranged := !req.From.IsZero() || !req.To.IsZero()
+	switch {
+	case len(req.RoomIDs) == 0 && !ranged:
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "room_ids", Msg: "set room_ids, or from and/or to"})
+		return
+	case len(req.RoomIDs) > 0 && ranged:
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "room_ids", Msg: "set either room_ids or from and to, not both"})
+		return
+	case !req.From.IsZero() && !req.To.IsZero() && !req.From.Before(req.To):
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "to", Msg: "to must be after from"})
+		return
+	}
+	if archive == nil {
+		writeError(w, r, http.StatusNotFound, codeArchiveDisabled, "archive disabled")
+		return
+	}
+	sc, _, err := resolveScorer(scoreParams{Metric: req.Metric, Aggregation: req.Aggregation, Version: req.ScoringVersion})
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	targets, err := rooms.rescoreTargets(req.RoomIDs, req.From, req.To)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	resp := RoomRescoreResp{Method: sc.Name, Rooms: []RoomRescoreResult{}}
+	for _, t := range targets {
+		if r.Context().Err() != nil {
+			return
+		}
+		res := RoomRescoreResult{RoomID: t.st.ID, Unarchived: t.unarchived, Rankings: []RoomRanking{}}
+		scores := map[string]float64{}
+		for _, id := range t.images {
+			score, err := rescoreRoomImage(r.Context(), t.st, sc, id)
+			if err != nil {
+				log.Printf("rescore: room %s image %s: %v", t.st.ID, id, err)
+				res.Failed++
+				continue
+			}
+			scores[id] = score
+		}
+		res.Rescored = len(scores)
+		room, err := rooms.saveRescore(t.st.ID, sc.Name, scores, time.Now().UTC())
+		if err != nil {
+			writeRequestError(w, r, err)
+			return
+		}
+		for _, rs := range room.Rescorings {
+			if rs.Method == sc.Name {
+				res.Rankings = rs.Rankings
+			}
+		}
+		resp.Rooms = append(resp.Rooms, res)
+	}
+	log.Printf("rescored %d rooms by %s", len(resp.Rooms), sc.Name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// rescoreRoomImage scores an archived image with st's theme and options
+// under sc, recording nothing.
+func rescoreRoomImage(ctx context.Context, st roomState, sc scoring.Scorer, id string) (float64, error) {
+	data, err := archive.get(id)
+	if err != nil {
+		return 0, err
+	}
+	resp, _, err := scoreSubmission(ctx, scoreParams{
+		Image:         data,
+		ThemeHex:      "#" + st.ThemeHex,
+		Metric:        sc.Metric.Name,
+		Aggregation:   sc.Aggregation.Name,
+		Normalization: st.Normalization,
+		Background:    st.Background,
+		Grayscale:     st.Grayscale,
+		Vision:        st.Vision,
+		Rescore:       true,
+	})
+	return resp.Score, err
+}
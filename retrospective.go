@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+const (
+	// paletteBits is the per-channel quantization used to bucket submitted
+	// average colors into "palettes".
+	paletteBits  = 3
+	topPalettes  = 8
+	retroCurrent = "current.json"
+	// galleryMax is how many of a round's best archived submissions its
+	// gallery keeps.
+	galleryMax = 100
+	// retroFlushInterval is how often open rounds changed by submissions
+	// are written out; a crash loses at most this much.
+	retroFlushInterval = 5 * time.Second
+)
+
+var retros *retroStore
+
+type Retrospective struct {
+	ThemeHex         string         `json:"theme_hex"`
+	OpenedAt         time.Time      `json:"opened_at"`
+	ClosedAt         *time.Time     `json:"closed_at,omitempty"`
+	Submissions      int            `json:"submissions"`
+	Players          int            `json:"players"`
+	MeanScore        float64        `json:"mean_score"`
+	ScoreHistogram   [10]int        `json:"score_histogram"`
+	AvgCoverage      float64        `json:"avg_coverage"`
+	DominantPalettes []PaletteShare `json:"dominant_palettes"`
+	Gallery          []GalleryImage `json:"gallery,omitempty" doc:"Up to 100 of the round's best-scoring archived submissions, best first; empty when archiving is off."`
+}
+
+type GalleryImage struct {
+	ImageID     string    `json:"image_id" doc:"Archive key of the image; admins can fetch it from /admin/archive/{id}."`
+	UserID      string    `json:"user_id,omitempty"`
+	Score       float64   `json:"score"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	URL         string    `json:"url,omitempty" doc:"Signed URL of the image, minted for this response and valid for archive.signed_url_ttl; only with an object-store archive."`
+}
+
+type PaletteShare struct {
+	Hex   string  `json:"hex"`
+	Count int     `json:"count"`
+	Share float64 `json:"share"`
+}
+
+type RetrospectiveResp struct {
+	ThemeHex string          `json:"theme_hex"`
+	Current  *Retrospective  `json:"current,omitempty"`
+	Rounds   []Retrospective `json:"rounds"`
+}
+
+// retroAccum is the running state of the open round for one theme.
+type retroAccum struct {
+	ThemeHex    string         `json:"theme_hex"`
+	OpenedAt    time.Time      `json:"opened_at"`
+	Count       int            `json:"count"`
+	ScoreSum    float64        `json:"score_sum"`
+	CoverageSum float64        `json:"coverage_sum"`
+	Histogram   [10]int        `json:"histogram"`
+	Palettes    map[string]int `json:"palettes"`
+	// Players counts submissions per verified user ID.
+	Players map[string]int `json:"players,omitempty"`
+	// Gallery is unordered; once full, a better submission replaces the
+	// worst.
+	Gallery []GalleryImage `json:"gallery,omitempty"`
+}
+
+// retroStore keeps one open round per theme. With a directory set, the open
+// round and every closed retrospective are written as JSON under dir/<hex>/.
+// Closed ones are written as they close; open ones, which change with every
+// submission, every retroFlushInterval and on flush.
+type retroStore struct {
+	dir string
+
+	// flushMu serializes writing open rounds, and keeps close from
+	// removing a round's file while a flush is writing it back.
+	flushMu sync.Mutex
+	mu      sync.Mutex
+	open    map[string]*retroAccum
+	dirty   map[string]bool            // open rounds changed since the last flush
+	closed  map[string][]Retrospective // only used without dir
+}
+
+func newRetroStore(dir string) (*retroStore, error) {
+	s := &retroStore{dir: dir, open: map[string]*retroAccum{}, dirty: map[string]bool{}, closed: map[string][]Retrospective{}}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name(), retroCurrent))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var a retroAccum
+		if err := json.Unmarshal(b, &a); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		s.open[e.Name()] = &a
+	}
+	go s.flushEvery(retroFlushInterval)
+	return s, nil
+}
+
+func themeKey(r, g, b uint8) string {
+	return fmt.Sprintf("%02x%02x%02x", r, g, b)
+}
+
+// record adds a submission to theme's open round. imageID is the archive
+// key of the image, or "" if it was not archived.
+func (s *retroStore) record(theme, userID, imageID string, score, coverage, sr, sg, sb float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.open[theme]
+	if a == nil {
+		a = &retroAccum{ThemeHex: theme, OpenedAt: time.Now().UTC(), Palettes: map[string]int{}}
+		s.open[theme] = a
+	}
+	a.Count++
+	a.ScoreSum += score
+	a.CoverageSum += coverage
+	a.Histogram[min(int(score/10), 9)]++
+	a.Palettes[paletteBin(sr, sg, sb)]++
+	if userID != "" {
+		if a.Players == nil {
+			a.Players = map[string]int{}
+		}
+		a.Players[userID]++
+	}
+	if imageID != "" {
+		a.addToGallery(GalleryImage{ImageID: imageID, UserID: userID, Score: score, SubmittedAt: time.Now().UTC()})
+	}
+	s.dirty[theme] = true
+}
+
+// flush writes the open rounds changed since the last flush. Rounds it
+// fails to write stay pending for the next.
+func (s *retroStore) flush() error {
+	if s.dir == "" {
+		return nil
+	}
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.mu.Lock()
+	pending := make(map[string][]byte, len(s.dirty))
+	var errs []error
+	for theme := range s.dirty {
+		b, err := json.Marshal(s.open[theme])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", theme, err))
+			continue
+		}
+		pending[theme] = b
+		delete(s.dirty, theme)
+	}
+	s.mu.Unlock()
+	for theme, b := range pending {
+		if err := s.writeFile(theme, retroCurrent, b); err != nil {
+			errs = append(errs, err)
+			s.mu.Lock()
+			s.dirty[theme] = true
+			s.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flushEvery flushes every d, for the life of the process.
+func (s *retroStore) flushEvery(d time.Duration) {
+	for range time.Tick(d) {
+		if err := s.flush(); err != nil {
+			log.Printf("retrospective: %v", err)
+		}
+	}
+}
+
+// close finalizes the open round for theme and starts a fresh one.
+func (s *retroStore) close(theme string) (*Retrospective, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.open[theme]
+	if a == nil {
+		return nil, nil
+	}
+	r := a.summary()
+	now := time.Now().UTC()
+	r.ClosedAt = &now
+	if s.dir == "" {
+		s.closed[theme] = append(s.closed[theme], r)
+	} else {
+		if err := s.writeJSON(theme, now.Format("20060102T150405.000000000Z")+".json", r); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(filepath.Join(s.dir, theme, retroCurrent)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	delete(s.open, theme)
+	delete(s.dirty, theme)
+	return &r, nil
+}
+
+func (s *retroStore) get(theme string) (RetrospectiveResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := RetrospectiveResp{ThemeHex: "#" + theme, Rounds: []Retrospective{}}
+	if a := s.open[theme]; a != nil {
+		cur := a.summary()
+		resp.Current = &cur
+	}
+	if s.dir == "" {
+		resp.Rounds = append(resp.Rounds, s.closed[theme]...)
+	} else {
+		entries, err := os.ReadDir(filepath.Join(s.dir, theme))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return resp, err
+		}
+		for _, e := range entries {
+			if e.Name() == retroCurrent || !strings.HasSuffix(e.Name(), ".json") {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(s.dir, theme, e.Name()))
+			if err != nil {
+				return resp, err
+			}
+			var r Retrospective
+			if err := json.Unmarshal(b, &r); err != nil {
+				return resp, fmt.Errorf("%s: %w", e.Name(), err)
+			}
+			resp.Rounds = append(resp.Rounds, r)
+		}
+	}
+	sort.Slice(resp.Rounds, func(i, j int) bool { return resp.Rounds[i].ClosedAt.After(*resp.Rounds[j].ClosedAt) })
+	return resp, nil
+}
+
+func (s *retroStore) writeJSON(theme, name string, v any) error {
+	if s.dir == "" {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(theme, name, b)
+}
+
+// writeFile replaces dir/<theme>/name with b.
+func (s *retroStore) writeFile(theme, name string, b []byte) error {
+	dir := filepath.Join(s.dir, theme)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
+
+func (a *retroAccum) addToGallery(g GalleryImage) {
+	if len(a.Gallery) < galleryMax {
+		a.Gallery = append(a.Gallery, g)
+		return
+	}
+	worst := 0
+	for i, h := range a.Gallery {
+		if h.Score < a.Gallery[worst].Score {
+			worst = i
+		}
+	}
+	if g.Score > a.Gallery[worst].Score {
+		a.Gallery[worst] = g
+	}
+}
+
+func (a *retroAccum) summary() Retrospective {
+	r := Retrospective{
+		ThemeHex:       "#" + a.ThemeHex,
+		OpenedAt:       a.OpenedAt,
+		Submissions:    a.Count,
+		Players:        len(a.Players),
+		ScoreHistogram: a.Histogram,
+	}
+	if a.Count > 0 {
+		r.MeanScore = math.Round(a.ScoreSum/float64(a.Count)*10) / 10
+		r.AvgCoverage = math.Round(a.CoverageSum/float64(a.Count)*1000) / 1000
+	}
+	for hex, n := range a.Palettes {
+		r.DominantPalettes = append(r.DominantPalettes, PaletteShare{Hex: hex, Count: n, Share: math.Round(float64(n)/float64(a.Count)*1000) / 1000})
+	}
+	sort.Slice(r.DominantPalettes, func(i, j int) bool {
+		if r.DominantPalettes[i].Count != r.DominantPalettes[j].Count {
+			return r.DominantPalettes[i].Count > r.DominantPalettes[j].Count
+		}
+		return r.DominantPalettes[i].Hex < r.DominantPalettes[j].Hex
+	})
+	if len(r.DominantPalettes) > topPalettes {
+		r.DominantPalettes = r.DominantPalettes[:topPalettes]
+	}
+	r.Gallery = slices.Clone(a.Gallery)
+	sort.SliceStable(r.Gallery, func(i, j int) bool {
+		if r.Gallery[i].Score != r.Gallery[j].Score {
+			return r.Gallery[i].Score > r.Gallery[j].Score
+		}
+		return r.Gallery[i].SubmittedAt.Before(r.Gallery[j].SubmittedAt)
+	})
+	return r
+}
+
+// signGallery mints URLs for r's gallery images. Stored records keep only
+// the keys, since the URLs expire.
+func signGallery(a *imageArchive, r *Retrospective) {
+	if a == nil || len(r.Gallery) == 0 {
+		return
+	}
+	r.Gallery = slices.Clone(r.Gallery)
+	for i := range r.Gallery {
+		r.Gallery[i].URL = a.url(r.Gallery[i].ImageID)
+	}
+}
+
+// paletteBin maps an sRGB color to the center of its quantization bucket.
+func paletteBin(sr, sg, sb float64) string {
+	q := func(c float64) string {
+		v := int(math.Round(c*255)) >> (8 - paletteBits)
+		v = min(max(v, 0), 1<<paletteBits-1)
+		return colormath.HexByte(float64(v<<(8-paletteBits)|1<<(7-paletteBits)) / 255.0)
+	}
+	return "#" + q(sr) + q(sg) + q(sb)
+}
+
+func retroTheme(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tr, tg, tb, err := colormath.ParseHex(r.PathValue("hex"))
+	if err != nil {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidThemeHex, Field: "hex", Msg: "bad theme hex: " + err.Error()})
+		return "", false
+	}
+	return themeKey(tr, tg, tb), true
+}
+
+func handleGetRetrospective(w http.ResponseWriter, r *http.Request) {
+	theme, ok := retroTheme(w, r)
+	if !ok {
+		return
+	}
+	sandbox := principalFrom(r.Context()).Sandbox
+	resp, err := retrosFor(sandbox).get(theme)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "retrospective: "+err.Error())
+		return
+	}
+	if resp.Current != nil {
+		signGallery(archiveFor(sandbox), resp.Current)
+	}
+	for i := range resp.Rounds {
+		signGallery(archiveFor(sandbox), &resp.Rounds[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleCloseRetrospective ends the current round for the theme and persists
+// its retrospective record. Ending a live round is an operator action, so
+// only admin keys may.
+func handleCloseRetrospective(w http.ResponseWriter, r *http.Request) {
+	if !principalFrom(r.Context()).Admin {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "closing a round requires an admin API key")
+		return
+	}
+	theme, ok := retroTheme(w, r)
+	if !ok {
+		return
+	}
+	sandbox := principalFrom(r.Context()).Sandbox
+	rec, err := retrosFor(sandbox).close(theme)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "retrospective: "+err.Error())
+		return
+	}
+	if rec == nil {
+		writeError(w, r, http.StatusNotFound, codeNoSubmissions, "no submissions for theme")
+		return
+	}
+	signGallery(archiveFor(sandbox), rec)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rec)
+}
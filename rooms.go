@@ -0,0 +1,734 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+	"github.com/hiromuota166/iropico_color_calc/pkg/scoring"
+)
+
+// A room is one game round: a theme, a scoring method fixed when the room
+// is created, and a deadline. Each player's best submission is ranked; the
+// room closes at its deadline or when closed early.
+
+const (
+	maxRoomDuration  = 7 * 24 * time.Hour
+	maxRoomPlayers   = 1000
+	maxPlayerNameLen = 64
+	// Closed rooms are dropped, from memory and disk, after roomRetention.
+	roomRetention = 7 * 24 * time.Hour
+	// A submission is a near-duplicate of another player's when their
+	// perceptual hashes differ in at most duplicateHashBits bits and no
+	// channel of their average colors by more than duplicateColorDelta.
+	// Re-encoded or resized copies of a photo stay well within both, while
+	// two real photos of one flat surface rarely match on the hash.
+	duplicateHashBits   = 10
+	duplicateColorDelta = 4
+)
+
+var rooms, sandboxRooms *roomStore
+
+func roomsFor(sandbox bool) *roomStore {
+	if sandbox {
+		return sandboxRooms
+	}
+	return rooms
+}
+
+type CreateRoomRequest struct {
+	ThemeHex       string    `json:"theme_hex,omitempty" doc:"Theme color, as in ScoreRequest; defaults to the active theme."`
+	Deadline       time.Time `json:"deadline" doc:"RFC 3339 time after which submissions are refused; at most 7 days ahead."`
+	Metric         string    `json:"metric,omitempty" enum:"@metrics"`
+	Aggregation    string    `json:"aggregation,omitempty" enum:"@aggregations"`
+	Normalization  string    `json:"normalization,omitempty" enum:"gamut,global"`
+	Background     string    `json:"background,omitempty" enum:"@backgrounds"`
+	Grayscale      string    `json:"grayscale,omitempty" enum:"@grayscaleModes"`
+	Vision         string    `json:"vision,omitempty" enum:"@visionModes" doc:"As in ScoreRequest, for rooms of color-blind players; every submission is scored so."`
+	ScoringVersion string    `json:"scoring_version,omitempty" doc:"Scoring semantics, as in the /v1 and /v2 score routes; defaults to v1."`
+}
+
+type Room struct {
+	ID          string          `json:"id"`
+	ThemeHex    string          `json:"theme_hex"`
+	Method      string          `json:"method" doc:"Scorer every submission is judged by."`
+	Vision      string          `json:"vision,omitempty" enum:"@visionModes" doc:"Vision mode every submission is scored under, when the room was created with one."`
+	CreatedAt   time.Time       `json:"created_at"`
+	Deadline    time.Time       `json:"deadline"`
+	ClosedAt    *time.Time      `json:"closed_at,omitempty" doc:"Set once the room is closed, early or at its deadline."`
+	Submissions int             `json:"submissions"`
+	Rankings    []RoomRanking   `json:"rankings" doc:"Each player's best score, highest first; final once closed_at is set."`
+	Rescorings  []RoomRescoring `json:"rescorings,omitempty" doc:"Leaderboards recomputed by /admin/rescore under other methods, oldest first; rankings keeps the original scores."`
+}
+
+type RoomRescoring struct {
+	Method     string        `json:"method" doc:"Scorer the room's archived submissions were rescored by."`
+	RescoredAt time.Time     `json:"rescored_at"`
+	Rankings   []RoomRanking `json:"rankings" doc:"Each player's best rescored submission, ranked as rankings; players with no archived submission are left out, and submissions after rescored_at are not counted until rescored again."`
+}
+
+type RoomRanking struct {
+	Rank        int       `json:"rank" doc:"Players with equal scores share a rank."`
+	Player      string    `json:"player"`
+	Score       float64   `json:"score"`
+	AvgColorHex string    `json:"avg_color_hex"`
+	SubmittedAt time.Time `json:"submitted_at" doc:"When the best submission arrived."`
+	Submissions int       `json:"submissions"`
+	DuplicateOf string    `json:"duplicate_of,omitempty" doc:"Set when the best submission is a near-duplicate of one this other player submitted earlier; see RoomSubmissionResp.duplicate_of."`
+}
+
+type RoomSubmissionRequest struct {
+	ImageBase64  string `json:"image_base64" doc:"As in ScoreRequest."`
+	MaskBase64   string `json:"mask_base64,omitempty" doc:"As in ScoreRequest."`
+	Player       string `json:"player,omitempty" doc:"Player name, up to 64 bytes; required unless called with an ID token, whose user ID is used instead."`
+	Lang         string `json:"lang,omitempty" enum:"@languages" doc:"As in ScoreRequest."`
+	CapturedAtMs int64  `json:"captured_at_ms,omitempty"`
+}
+
+type RoomSubmissionResp struct {
+	Result ScoreResponse `json:"result"`
+	Player string        `json:"player"`
+	Best   float64       `json:"best" doc:"The player's best score in the room so far."`
+	Rank   int           `json:"rank" doc:"The player's current rank."`
+	// DuplicateOf catches players re-submitting one photo under several
+	// names to farm the leaderboard.
+	DuplicateOf string `json:"duplicate_of,omitempty" doc:"Set when the image is a near-duplicate, by perceptual hash and average color, of one this other player submitted to the room earlier. The submission still counts, but is flagged for review."`
+}
+
+// roomState is a room as stored: its resolved scoring parameters and each
+// player's best entry.
+type roomState struct {
+	ID            string                `json:"id"`
+	ThemeHex      string                `json:"theme_hex"`
+	Metric        string                `json:"metric"`
+	Aggregation   string                `json:"aggregation"`
+	Normalization string                `json:"normalization"`
+	Background    string                `json:"background"`
+	Grayscale     string                `json:"grayscale"`
+	Vision        string                `json:"vision,omitempty"`
+	Method        string                `json:"method"`
+	CreatedAt     time.Time             `json:"created_at"`
+	Deadline      time.Time             `json:"deadline"`
+	ClosedAt      *time.Time            `json:"closed_at,omitempty"`
+	Submissions   int                   `json:"submissions"`
+	Entries       map[string]*roomEntry `json:"entries"`
+	Rescorings    []roomRescoring       `json:"rescorings,omitempty"`
+	// Creator is the client that created the room, as roomCreator names
+	// it; only it, or an admin, may close the room early. Rooms created
+	// before it was recorded have none.
+	Creator string `json:"creator,omitempty"`
+	// Options are every scoring option, resolved when the room was
+	// created, so a config reload mid-round cannot change how later
+	// entries are scored. Rooms created before they were pinned have none
+	// and resolve them per submission.
+	Options *scoring.Options `json:"options,omitempty"`
+}
+
+type roomEntry struct {
+	Score       float64   `json:"score"`
+	AvgColorHex string    `json:"avg_color_hex"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	Submissions int       `json:"submissions"`
+	// DuplicateOf is set when the best submission is a near-duplicate of
+	// another player's.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// Prints identify the player's distinct submissions, to spot the same
+	// photo submitted by another player.
+	Prints []roomPrint `json:"prints,omitempty"`
+	// Images are the player's archived submissions, which /admin/rescore
+	// can score again; with archiving off there are none.
+	Images []roomImage `json:"images,omitempty"`
+}
+
+type roomImage struct {
+	ImageID     string    `json:"image_id"`
+	Score       float64   `json:"score"`
+	AvgColorHex string    `json:"avg_color_hex"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	// Rescores holds the image's score under each method it was rescored
+	// by, keyed by scorer name.
+	Rescores map[string]float64 `json:"rescores,omitempty"`
+}
+
+// roomPrint is a submission's perceptual hash and average color, and when
+// it was first submitted.
+type roomPrint struct {
+	Hash        uint64    `json:"hash"`
+	AvgColorHex string    `json:"avg_color_hex"`
+	At          time.Time `json:"at"`
+}
+
+// near reports whether p and q look like the same photo.
+func (p roomPrint) near(q roomPrint) bool {
+	if imaging.HashDistance(p.Hash, q.Hash) > duplicateHashBits {
+		return false
+	}
+	pr, pg, pb, err1 := colormath.ParseHex(p.AvgColorHex)
+	qr, qg, qb, err2 := colormath.ParseHex(q.AvgColorHex)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	delta := func(a, b uint8) int { return max(int(a)-int(b), int(b)-int(a)) }
+	return max(delta(pr, qr), delta(pg, qg), delta(pb, qb)) <= duplicateColorDelta
+}
+
+// duplicateOf returns the player other than player who submitted a photo
+// near p before player first did, preferring the nearest hash, then the
+// first name; "" if none. Whoever submitted a photo first is never the
+// duplicate, however often they submit it again.
+func (st *roomState) duplicateOf(player string, p roomPrint) string {
+	first := p.At
+	if e := st.Entries[player]; e != nil {
+		for _, q := range e.Prints {
+			if p.near(q) && q.At.Before(first) {
+				first = q.At
+			}
+		}
+	}
+	var dup string
+	best := duplicateHashBits + 1
+	for other, e := range st.Entries {
+		if other == player {
+			continue
+		}
+		for _, q := range e.Prints {
+			if !p.near(q) || !q.At.Before(first) {
+				continue
+			}
+			if d := imaging.HashDistance(p.Hash, q.Hash); d < best || (d == best && other < dup) {
+				dup, best = other, d
+			}
+		}
+	}
+	return dup
+}
+
+// roomRescoring records a rescore of the room; the scores are kept on its
+// images.
+type roomRescoring struct {
+	Method string    `json:"method"`
+	At     time.Time `json:"at"`
+}
+
+// roomStore keeps rooms in memory; with a directory set, each room is also
+// written to dir/<id>.json on every change and reloaded at startup.
+type roomStore struct {
+	dir string
+
+	mu    sync.Mutex
+	rooms map[string]*roomState
+}
+
+func newRoomStore(dir string) (*roomStore, error) {
+	s := &roomStore{dir: dir, rooms: map[string]*roomState{}}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var st roomState
+		if err := json.Unmarshal(b, &st); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		s.rooms[st.ID] = &st
+	}
+	return s, nil
+}
+
+func newRoomID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validRoomID(id string) bool {
+	if len(id) != 12 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
+}
+
+var errRoomNotFound = &requestError{Status: http.StatusNotFound, Code: codeNotFound, Msg: "no such room"}
+
+func (s *roomStore) create(st *roomState) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(st.CreatedAt)
+	s.rooms[st.ID] = st
+	if err := s.write(st); err != nil {
+		delete(s.rooms, st.ID)
+		return Room{}, err
+	}
+	return st.view(), nil
+}
+
+// lookup returns the room with id, closing it first if its deadline passed.
+// Callers hold s.mu.
+func (s *roomStore) lookup(id string, now time.Time) (*roomState, error) {
+	st := s.rooms[id]
+	if st == nil {
+		return nil, errRoomNotFound
+	}
+	if st.ClosedAt == nil && !now.Before(st.Deadline) {
+		closed := st.Deadline
+		st.ClosedAt = &closed
+		if err := s.write(st); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+func (s *roomStore) get(id string) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, err := s.lookup(id, time.Now().UTC())
+	if err != nil {
+		return Room{}, err
+	}
+	return st.view(), nil
+}
+
+// open returns the room's state if it accepts a submission from player at
+// t, so a refused one is not scored for nothing. Its Entries are not safe
+// to read.
+func (s *roomStore) open(id, player string, t time.Time) (roomState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, err := s.lookup(id, time.Now().UTC())
+	if err != nil {
+		return roomState{}, err
+	}
+	if err := st.accepts(player, t); err != nil {
+		return roomState{}, err
+	}
+	return *st, nil
+}
+
+func (st *roomState) accepts(player string, t time.Time) error {
+	if st.ClosedAt != nil && !t.Before(*st.ClosedAt) {
+		return errRoomClosed(st)
+	}
+	if st.Entries[player] == nil && len(st.Entries) >= maxRoomPlayers {
+		return &requestError{Status: http.StatusConflict, Code: codeRoomFull, Msg: fmt.Sprintf("room already has %d players", maxRoomPlayers)}
+	}
+	return nil
+}
+
+// submit records player's score, received at t, keeping their best. It
+// also returns the player whose earlier submission this one is a
+// near-duplicate of, if any.
+func (s *roomStore) submit(id, player string, resp ScoreResponse, t time.Time) (Room, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, err := s.lookup(id, time.Now().UTC())
+	if err != nil {
+		return Room{}, "", err
+	}
+	if err := st.accepts(player, t); err != nil {
+		return Room{}, "", err
+	}
+	e := st.Entries[player]
+	if e == nil {
+		e = &roomEntry{}
+		st.Entries[player] = e
+	}
+	fp := roomPrint{resp.hash, resp.AvgColorHex, t}
+	dup := st.duplicateOf(player, fp)
+	e.Submissions++
+	st.Submissions++
+	if e.Submissions == 1 || resp.Score > e.Score {
+		e.Score, e.AvgColorHex, e.SubmittedAt, e.DuplicateOf = resp.Score, resp.AvgColorHex, t, dup
+	}
+	if !slices.ContainsFunc(e.Prints, func(q roomPrint) bool { return q.Hash == fp.Hash && q.AvgColorHex == fp.AvgColorHex }) {
+		e.Prints = append(e.Prints, fp)
+	}
+	if resp.ImageID != "" {
+		e.Images = append(e.Images, roomImage{ImageID: resp.ImageID, Score: resp.Score, AvgColorHex: resp.AvgColorHex, SubmittedAt: t})
+	}
+	if err := s.write(st); err != nil {
+		return Room{}, "", err
+	}
+	return st.view(), dup, nil
+}
+
+// duplicateOf returns the player other than player whose submission to
+// room id resp, received at t, is a near-duplicate of, if any.
+func (s *roomStore) duplicateOf(id, player string, resp ScoreResponse, t time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.rooms[id]
+	if st == nil {
+		return ""
+	}
+	return st.duplicateOf(player, roomPrint{resp.hash, resp.AvgColorHex, t})
+}
+
+// close closes the room on behalf of closer, as roomCreator names it, who
+// must have created it unless admin is set.
+func (s *roomStore) close(id, closer string, admin bool) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	st, err := s.lookup(id, now)
+	if err != nil {
+		return Room{}, err
+	}
+	if !admin && (st.Creator == "" || st.Creator != closer) {
+		return Room{}, &requestError{Status: http.StatusForbidden, Code: codeForbidden, Msg: "only the room's creator or an admin may close it"}
+	}
+	if st.ClosedAt == nil {
+		st.ClosedAt = &now
+		if err := s.write(st); err != nil {
+			return Room{}, err
+		}
+	}
+	return st.view(), nil
+}
+
+// sweep drops rooms closed more than roomRetention before now. Callers hold
+// s.mu.
+func (s *roomStore) sweep(now time.Time) {
+	for id, st := range s.rooms {
+		closed := st.Deadline
+		if st.ClosedAt != nil {
+			closed = *st.ClosedAt
+		}
+		if now.Sub(closed) < roomRetention {
+			continue
+		}
+		if s.dir != "" {
+			if err := os.Remove(filepath.Join(s.dir, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+		}
+		delete(s.rooms, id)
+	}
+}
+
+func (s *roomStore) write(st *roomState) error {
+	if s.dir == "" {
+		return nil
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, "."+st.ID+".json.tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, st.ID+".json"))
+}
+
+func errRoomClosed(st *roomState) *requestError {
+	return &requestError{Status: http.StatusConflict, Code: codeRoomClosed, Msg: "room is closed",
+		Details: map[string]any{"closed_at": st.ClosedAt.Format(time.RFC3339)}}
+}
+
+func (st *roomState) view() Room {
+	r := Room{
+		ID:          st.ID,
+		ThemeHex:    "#" + st.ThemeHex,
+		Method:      st.Method,
+		Vision:      st.Vision,
+		CreatedAt:   st.CreatedAt,
+		Deadline:    st.Deadline,
+		ClosedAt:    st.ClosedAt,
+		Submissions: st.Submissions,
+		Rankings:    []RoomRanking{},
+	}
+	for player, e := range st.Entries {
+		r.Rankings = append(r.Rankings, RoomRanking{Player: player, Score: e.Score, AvgColorHex: e.AvgColorHex, SubmittedAt: e.SubmittedAt, Submissions: e.Submissions, DuplicateOf: e.DuplicateOf})
+	}
+	rank(r.Rankings)
+	for _, rs := range st.Rescorings {
+		r.Rescorings = append(r.Rescorings, RoomRescoring{Method: rs.Method, RescoredAt: rs.At, Rankings: st.rescoredRankings(rs.Method)})
+	}
+	return r
+}
+
+// rescoredRankings ranks each player's best image under method.
+func (st *roomState) rescoredRankings(method string) []RoomRanking {
+	out := []RoomRanking{}
+	for player, e := range st.Entries {
+		var best *roomImage
+		for i, im := range e.Images {
+			score, ok := im.Rescores[method]
+			if ok && (best == nil || score > best.Rescores[method]) {
+				best = &e.Images[i]
+			}
+		}
+		if best != nil {
+			out = append(out, RoomRanking{Player: player, Score: best.Rescores[method], AvgColorHex: best.AvgColorHex, SubmittedAt: best.SubmittedAt, Submissions: e.Submissions})
+		}
+	}
+	rank(out)
+	return out
+}
+
+// rank sorts rankings best first and numbers them; ties go to whoever got
+// there first.
+func rank(rankings []RoomRanking) {
+	sort.Slice(rankings, func(i, j int) bool {
+		a, b := rankings[i], rankings[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if !a.SubmittedAt.Equal(b.SubmittedAt) {
+			return a.SubmittedAt.Before(b.SubmittedAt)
+		}
+		return a.Player < b.Player
+	})
+	for i := range rankings {
+		rankings[i].Rank = i + 1
+		if i > 0 && rankings[i].Score == rankings[i-1].Score {
+			rankings[i].Rank = rankings[i-1].Rank
+		}
+	}
+}
+
+// roomCreator names the client making r for roomState.Creator: its API
+// key, user ID or IP, as quotas tell clients apart.
+func roomCreator(r *http.Request) string {
+	qc := quotaClientFor(r)
+	return qc.Kind + ":" + qc.ID
+}
+
+// roomPath checks the {id} path parameter.
+func roomPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if !validRoomID(id) {
+		writeRequestError(w, r, errRoomNotFound)
+		return "", false
+	}
+	return id, true
+}
+
+func handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	var req CreateRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, r, bodyError(err))
+		return
+	}
+	if req.ThemeHex == "" {
+		req.ThemeHex = activeTheme.get().ThemeHex
+	}
+	var errs fieldErrors
+	tr, tg, tb, _, err := colormath.ParseColor(req.ThemeHex)
+	if err != nil {
+		errs.add(themeHexError(err))
+	}
+	now := time.Now().UTC()
+	if !req.Deadline.After(now) || req.Deadline.Sub(now) > maxRoomDuration {
+		errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "deadline",
+			Msg: "deadline must be in the future and at most 7 days ahead"})
+	}
+	sc, opts, err := resolveScorer(scoreParams{
+		Metric:        req.Metric,
+		Aggregation:   req.Aggregation,
+		Normalization: req.Normalization,
+		Background:    req.Background,
+		Grayscale:     req.Grayscale,
+		Vision:        req.Vision,
+		Version:       req.ScoringVersion,
+	})
+	errs.add(err)
+	if err := errs.err(); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	room, err := roomsFor(principalFrom(r.Context()).Sandbox).create(&roomState{
+		ID:            newRoomID(),
+		ThemeHex:      themeKey(tr, tg, tb),
+		Metric:        sc.Metric.Name,
+		Aggregation:   sc.Aggregation.Name,
+		Normalization: opts.Normalization,
+		Background:    opts.Background,
+		Grayscale:     opts.Grayscale,
+		Vision:        opts.Vision,
+		Method:        sc.Name,
+		Creator:       roomCreator(r),
+		Options:       &opts,
+		CreatedAt:     now,
+		Deadline:      req.Deadline.UTC(),
+		Entries:       map[string]*roomEntry{},
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "rooms: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(room)
+}
+
+func handleGetRoom(w http.ResponseWriter, r *http.Request) {
+	id, ok := roomPath(w, r)
+	if !ok {
+		return
+	}
+	room, err := roomsFor(principalFrom(r.Context()).Sandbox).get(id)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
+
+// handleCloseRoom closes a room early, for its creator under /rooms and for
+// any admin under /admin/rooms.
+func handleCloseRoom(w http.ResponseWriter, r *http.Request) {
+	id, ok := roomPath(w, r)
+	if !ok {
+		return
+	}
+	p := principalFrom(r.Context())
+	room, err := roomsFor(p.Sandbox).close(id, roomCreator(r), p.Admin)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
+
+// handleRoomSubmission scores an image with the room's theme and method,
+// as a regular submission, and ranks it in the room.
+func handleRoomSubmission(w http.ResponseWriter, r *http.Request) {
+	id, ok := roomPath(w, r)
+	if !ok {
+		return
+	}
+	received := receivedAt(r.Context())
+	r.Body = http.MaxBytesReader(w, r.Body, config().Limits.MaxBodyBytes)
+	var req RoomSubmissionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, r, bodyError(err))
+		return
+	}
+	p := principalFrom(r.Context())
+	player := req.Player
+	if p.UserID != "" {
+		player = p.UserID
+	}
+	if player == "" || len(player) > maxPlayerNameLen {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "player",
+			Msg: fmt.Sprintf("player must be 1 to %d bytes", maxPlayerNameLen)})
+		return
+	}
+	store := roomsFor(p.Sandbox)
+	st, err := store.open(id, player, received)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	buf := imaging.GetBuffer()
+	defer imaging.PutBuffer(buf)
+	var errs fieldErrors
+	if err := imaging.DecodeBase64Into(buf, req.ImageBase64); err != nil {
+		errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidBase64, Field: "image_base64", Msg: "bad image: " + err.Error()})
+	}
+	maskBuf := imaging.GetBuffer()
+	defer imaging.PutBuffer(maskBuf)
+	mask, err := readMaskBase64(maskBuf, req.MaskBase64)
+	errs.add(err)
+	// A key is scoped to its room and player, and a replay is not counted
+	// again.
+	idemKey := r.Header.Get("Idempotency-Key")
+	if idemKey != "" {
+		idemKey = id + "|" + player + "|" + idemKey
+	}
+	captured := capturedAt(req.CapturedAtMs)
+	sp := scoreParams{
+		Image:         buf.Bytes(),
+		Mask:          mask,
+		ThemeHex:      st.ThemeHex,
+		Metric:        st.Metric,
+		Aggregation:   st.Aggregation,
+		Normalization: st.Normalization,
+		Background:    st.Background,
+		Grayscale:     st.Grayscale,
+		Vision:        st.Vision,
+		Options:       st.Options,
+		Lang:          cmp.Or(req.Lang, requestLang(r)),
+		UserID:        p.UserID,
+		Sandbox:       p.Sandbox,
+		ReceivedAt:    received,
+		CapturedAt:    captured,
+
+		IdempotencyKey: idemKey,
+		Quota:          quotaClientFor(r),
+	}
+	if len(errs) > 0 {
+		errs.add(sp.validate())
+		writeRequestError(w, r, errs.err())
+		return
+	}
+	resp, scored, err := scoreSubmission(r.Context(), sp)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	var room Room
+	var dup string
+	if scored.Replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+		room, err = store.get(id)
+		dup = store.duplicateOf(id, player, resp, received)
+	} else {
+		room, dup, err = store.submit(id, player, resp, received)
+		if dup != "" && !p.Sandbox {
+			flagged.push(FlaggedSubmission{
+				At:       time.Now().UTC(),
+				ThemeHex: "#" + st.ThemeHex,
+				UserID:   p.UserID,
+				Score:    resp.Score,
+				ImageID:  imageID(sp.Image),
+				Reason:   fmt.Sprintf("room %s: %s's submission is a near-duplicate of %s's", id, player, dup),
+			})
+		}
+	}
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	out := RoomSubmissionResp{Result: resp, Player: player, DuplicateOf: dup}
+	for _, rk := range room.Rankings {
+		if rk.Player == player {
+			out.Best, out.Rank = rk.Score, rk.Rank
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+	observeDone(received, captured)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// The HTTP server is the route table on a ServeMux, wrapped in a chain of
+// middleware, outermost first:
+//
+//	receivedAt → access log → recovery → CORS → rate limit → auth → router
+//
+// Heavy routes are further wrapped by the work limiter in newRouter. The
+// arrival time is stamped first so every later stage, and the access log,
+// measures from it; recovery sits inside the log so a panic is logged as
+// the 500 it becomes.
+
+// middleware wraps a handler in one cross-cutting concern.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in mws, the first outermost.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// serverHandler is the handler of the main HTTP server.
+func serverHandler(routes []apiRoute, auth *authenticator, origins []string) http.Handler {
+	public := publicPaths(routes)
+	return chain(newRouter(routes),
+		withReceivedAt,
+		withAccessLog,
+		withRecovery,
+		func(h http.Handler) http.Handler { return withCORS(h, origins) },
+		func(h http.Handler) http.Handler { return withRateLimit(h, auth) },
+		func(h http.Handler) http.Handler { return withAuth(h, auth, public) },
+	)
+}
+
+// newRouter serves routes, the OpenAPI document and its docs page.
+// Requests no route matches get JSON errors like every other failure: 404
+// for unknown paths, and 405 with an Allow header for a known path under
+// another method.
+func newRouter(routes []apiRoute) http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		h := rt.Handler
+		if rt.Heavy {
+			h = work.wrap(h)
+		}
+		mux.HandleFunc(rt.Method+" "+rt.Path, h)
+	}
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI(routes))
+	mux.HandleFunc("GET /docs", handleDocs)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		// The mux's own fallback says which it is, in plain text.
+		rec := &headerRecorder{header: http.Header{}}
+		h.ServeHTTP(rec, r)
+		if rec.status != http.StatusMethodNotAllowed {
+			writeError(w, r, http.StatusNotFound, codeNotFound, "no route for "+r.URL.Path)
+			return
+		}
+		allow := rec.header.Get("Allow")
+		w.Header().Set("Allow", allow)
+		writeRequestError(w, r, &requestError{Status: http.StatusMethodNotAllowed, Code: codeMethodNotAllowed,
+			Msg: r.Method + " not allowed on " + r.URL.Path, Details: map[string]any{"allow": strings.Split(allow, ", ")}})
+	})
+}
+
+// headerRecorder keeps the headers and status a handler writes and drops
+// its body.
+type headerRecorder struct {
+	header http.Header
+	status int
+}
+
+func (h *headerRecorder) Header() http.Header         { return h.header }
+func (h *headerRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (h *headerRecorder) WriteHeader(status int)      { h.status = status }
+
+// statusWriter records the status and size of a response. It unwraps for
+// http.ResponseController, so event streams can still flush through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// withRecovery turns a handler's panic into a 500 INTERNAL error and logs
+// it with its stack, instead of net/http dropping the connection. If the
+// response had already started, the connection is dropped after all, as a
+// half-written body cannot be repaired.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, v, debug.Stack())
+			if sw.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			writeError(sw, r, http.StatusInternalServerError, codeInternal, "internal error")
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// withAccessLog logs one line per request when server.access_log is set:
+// method, path, status, response bytes and time since arrival. Probes are
+// left out, as orchestrators poll them every few seconds.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config().Server.AccessLog || probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			log.Printf("%s %s %d %dB %s", r.Method, r.URL.Path, status, sw.bytes,
+				time.Since(receivedAt(r.Context())).Round(time.Millisecond/10))
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
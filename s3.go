@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Store keeps blobs as objects in an S3-compatible bucket, signing
+// requests with AWS Signature Version 4. Google Cloud Storage accepts the
+// same requests through its XML API when given HMAC keys, so one store
+// covers both without pulling in either SDK.
+type s3Store struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	pathStyle bool
+	keyID     string
+	secret    string
+	http      *http.Client
+}
+
+const (
+	s3Timeout = 30 * time.Second
+	// s3MaxSignedURLTTL is the longest expiry SigV4 allows.
+	s3MaxSignedURLTTL = 7 * 24 * time.Hour
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeFormat      = "20060102T150405Z"
+)
+
+func newS3Store(c ArchiveStoreConfig) (*s3Store, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("bad endpoint %q", endpoint)
+	}
+	return &s3Store{
+		endpoint:  u,
+		bucket:    c.Bucket,
+		region:    c.Region,
+		pathStyle: c.PathStyle,
+		keyID:     c.AccessKeyID,
+		secret:    c.SecretAccessKey,
+		http:      &http.Client{Timeout: s3Timeout},
+	}, nil
+}
+
+// objectURL addresses key virtual-hosted style (bucket.host/key) unless
+// the store is path style (host/bucket/key), which MinIO and the like need.
+func (s *s3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimRight(u.Path, "/")
+	if s.pathStyle {
+		u.Path += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path += "/" + key
+	u.RawPath = s3EscapePath(u.Path)
+	return &u
+}
+
+func (s *s3Store) do(method, key string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, body, time.Now())
+	return s.http.Do(req)
+}
+
+// s3Error reads a failed response's S3 error code, if it has one.
+func s3Error(op, key string, resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	msg := resp.Status
+	if _, rest, ok := strings.Cut(string(b), "<Code>"); ok {
+		code, _, _ := strings.Cut(rest, "</Code>")
+		msg += " " + code
+	}
+	return fmt.Errorf("s3 %s %s: %s", op, key, msg)
+}
+
+func (s *s3Store) get(key string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, errBlobNotFound
+	}
+	return nil, s3Error("get", key, resp)
+}
+
+// put stores data with a sniffed Content-Type, so signed URLs to images
+// display in browsers.
+func (s *s3Store) put(key string, data []byte) error {
+	resp, err := s.do(http.MethodPut, key, data, http.Header{"Content-Type": {http.DetectContentType(data)}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("put", key, resp)
+	}
+	return nil
+}
+
+// gcs reports whether the store is Google Cloud Storage, whose XML API
+// takes generation preconditions instead of S3's If-Match and
+// If-None-Match.
+func (s *s3Store) gcs() bool {
+	return s.endpoint.Hostname() == "storage.googleapis.com"
+}
+
+// version is the version of the object resp returned: its generation on
+// Google Cloud Storage, its ETag elsewhere.
+func (s *s3Store) version(resp *http.Response) string {
+	if s.gcs() {
+		return resp.Header.Get("X-Goog-Generation")
+	}
+	return resp.Header.Get("ETag")
+}
+
+func (s *s3Store) getVersion(key string) ([]byte, string, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		b, err := io.ReadAll(resp.Body)
+		return b, s.version(resp), err
+	case http.StatusNotFound:
+		return nil, "", errBlobNotFound
+	}
+	return nil, "", s3Error("get", key, resp)
+}
+
+// putIf is put with a precondition on the version. S3 answers a failed
+// one with 412, or with 409 when a concurrent conditional write wins.
+func (s *s3Store) putIf(key string, data []byte, version string) (string, error) {
+	h := http.Header{"Content-Type": {http.DetectContentType(data)}}
+	switch {
+	case s.gcs() && version == "":
+		h.Set("X-Goog-If-Generation-Match", "0")
+	case s.gcs():
+		h.Set("X-Goog-If-Generation-Match", version)
+	case version == "":
+		h.Set("If-None-Match", "*")
+	default:
+		h.Set("If-Match", version)
+	}
+	resp, err := s.do(http.MethodPut, key, data, h)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return s.version(resp), nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return "", errBlobChanged
+	}
+	return "", s3Error("put", key, resp)
+}
+
+func (s *s3Store) delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error("delete", key, resp)
+	}
+	return nil
+}
+
+// signedURL returns a presigned GET URL for key valid for ttl.
+func (s *s3Store) signedURL(key string, ttl time.Duration) (string, error) {
+	if ttl <= 0 || ttl > s3MaxSignedURLTTL {
+		return "", fmt.Errorf("signed url ttl %v out of range", ttl)
+	}
+	return s.presign(http.MethodGet, key, ttl, time.Now()), nil
+}
+
+func (s *s3Store) presign(method, key string, ttl time.Duration, now time.Time) string {
+	u := s.objectURL(key)
+	now = now.UTC()
+	q := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.keyID + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format(s3TimeFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	canonical := strings.Join([]string{method, u.EscapedPath(), s3CanonicalQuery(q), "host:" + u.Host + "\n", "host", s3UnsignedPayload}, "\n")
+	q.Set("X-Amz-Signature", s.signature(canonical, now))
+	u.RawQuery = s3CanonicalQuery(q)
+	return u.String()
+}
+
+// sign adds SigV4 Authorization, X-Amz-Date and X-Amz-Content-Sha256
+// headers to req, signing the host and every header already set.
+func (s *s3Store) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), s3CanonicalQuery(req.URL.Query()),
+		canonHeaders.String(), signed, hex.EncodeToString(sum[:])}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.keyID, s.scope(now), signed, s.signature(canonical, now)))
+}
+
+func (s *s3Store) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+func (s *s3Store) signature(canonical string, now time.Time) string {
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format(s3TimeFormat) + "\n" + s.scope(now) + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + s.secret)
+	for _, part := range []string{now.Format("20060102"), s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// s3CanonicalQuery sorts and encodes q as SigV4 requires, spaces as %20.
+func s3CanonicalQuery(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+// s3EscapePath percent-encodes everything in p but unreserved characters
+// and slashes, the object key encoding SigV4 signs.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
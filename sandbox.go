@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Requests made with a sandbox API key score exactly like production ones,
+// but their writes land in a separate namespace: their own retrospectives,
+// stats and archive, no flagging, and the relaxed sandbox rate limit.
+var (
+	sandboxRetros  *retroStore
+	sandboxArchive *imageArchive
+)
+
+const sandboxNamespace = "sandbox"
+
+func retrosFor(sandbox bool) *retroStore {
+	if sandbox {
+		return sandboxRetros
+	}
+	return retros
+}
+
+func archiveFor(sandbox bool) *imageArchive {
+	if sandbox {
+		return sandboxArchive
+	}
+	return archive
+}
+
+// sandboxDir nests the sandbox retrospectives or rooms under the production
+// directory; the name cannot collide with a theme's hex directory or a room
+// file.
+func sandboxDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, sandboxNamespace)
+}
+
+// prefixStore scopes a blobStore to keys under prefix.
+type prefixStore struct {
+	blobStore
+	prefix string
+}
+
+func (s prefixStore) get(key string) ([]byte, error) {
+	return s.blobStore.get(path.Join(s.prefix, key))
+}
+
+func (s prefixStore) put(key string, data []byte) error {
+	return s.blobStore.put(path.Join(s.prefix, key), data)
+}
+
+func (s prefixStore) delete(key string) error {
+	return s.blobStore.delete(path.Join(s.prefix, key))
+}
+
+func (s prefixStore) getVersion(key string) ([]byte, string, error) {
+	return s.blobStore.getVersion(path.Join(s.prefix, key))
+}
+
+func (s prefixStore) putIf(key string, data []byte, version string) (string, error) {
+	return s.blobStore.putIf(path.Join(s.prefix, key), data, version)
+}
+
+func (s prefixStore) signedURL(key string, ttl time.Duration) (string, error) {
+	if u, ok := s.blobStore.(urlSigner); ok {
+		return u.signedURL(path.Join(s.prefix, key), ttl)
+	}
+	return "", errNoSignedURLs
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+)
+
+type ScoreRequest struct {
+	ImageBase64 string `json:"image_base64" doc:"PNG, JPEG or GIF as standard or URL-safe base64; a data: URL prefix is allowed. To skip JSON and base64, post the raw image instead with its image/* Content-Type and the other fields as query parameters."`
+	MaskBase64  string `json:"mask_base64,omitempty" doc:"Optional mask the size of the image, encoded as image_base64: white pixels are scored, black or transparent ones ignored, and grays weigh in between. JSON bodies only."`
+	ThemeHex    string `json:"theme_hex,omitempty" doc:"Theme color as hex (#RGB or #RRGGBB, alpha ignored), rgb(), hsl() or a CSS color name; defaults to the active theme."`
+	Metric      string `json:"metric,omitempty" enum:"@metrics" doc:"Color distance; defaults to the scoring version's: rgb under v1, ciede2000 under v2."`
+	Aggregation string `json:"aggregation,omitempty" enum:"@aggregations" doc:"How per-pixel distances combine into one score."`
+	// Normalization is "gamut" or "global"; the version picks by default.
+	Normalization string `json:"normalization,omitempty" enum:"gamut,global" doc:"gamut scales by the farthest color from the theme; global by the largest distance in sRGB. Defaults to the scoring version's: global under v1, gamut under v2."`
+	Background    string `json:"background,omitempty" enum:"@backgrounds" doc:"Transparent pixels: alpha weighs by opacity, white and theme composite over that color, ignore skips them. theme and ignore reject mostly transparent images."`
+	Grayscale     string `json:"grayscale,omitempty" enum:"@grayscaleModes" doc:"on scores by lightness, ignoring hue and mostly chroma; auto does so for near-gray themes; off never. Defaults to the server's setting."`
+	Vision        string `json:"vision,omitempty" enum:"@visionModes" doc:"Whose color vision to score as: under protanopia, deuteranopia or tritanopia both the image and the theme are simulated as a player with that color blindness sees them, and avg_color_* and closest_clusters describe what they see. Defaults to normal."`
+	Lang          string `json:"lang,omitempty" enum:"@languages" doc:"Language of the feedback text; defaults to the one Accept-Language prefers, then ja."`
+	// IncludeAllMethods is for evaluating scoring methods side by side.
+	IncludeAllMethods bool  `json:"include_all_methods,omitempty" doc:"Also score the image under every registered metric and aggregation, with the same options, in all_methods."`
+	CapturedAtMs      int64 `json:"captured_at_ms,omitempty" doc:"When the client captured the image, in Unix milliseconds; used for latency reporting."`
+	// Seed is for settling disputed scores and estimating how much a score
+	// depends on which pixels were sampled.
+	Seed uint32 `json:"seed,omitempty" doc:"Places the grid of pixels sampled: 0 (default) starts it at the top-left pixel, other values elsewhere in the first grid cell. Scoring an image again with the same seed and options gives the same result; averaging over several seeds gives a score independent of the grid."`
+}
+
+type ScoreResponse struct {
+	Score       float64 `json:"score" doc:"0 to 100, rounded to one decimal."`
+	AvgColorHex string  `json:"avg_color_hex" doc:"Alpha-weighted average color of the image as #rrggbb."`
+	// The same average color in other models, converted unrounded from
+	// linear sRGB.
+	AvgColorHSL   HSL        `json:"avg_color_hsl"`
+	AvgColorLab   Lab        `json:"avg_color_lab"`
+	AvgColorOKLCH OKLCH      `json:"avg_color_oklch"`
+	AvgColorNames ColorNames `json:"avg_color_names" doc:"Nearest CSS and traditional Japanese color names to the average color."`
+	Method        string     `json:"method" doc:"Scorer that produced the score."`
+	Feedback      string     `json:"feedback" doc:"One or two sentences for the player on how the photo differs from the theme, in the requested lang."`
+	// ClosestClusters shows the player which parts of the photo came
+	// closest.
+	ClosestClusters []ColorCluster `json:"closest_clusters" doc:"Up to three color clusters of the image nearest the theme, nearest first."`
+	AllMethods      []MethodScore  `json:"all_methods,omitempty" doc:"With include_all_methods, the score under each registered method, in registration order."`
+	ImageID         string         `json:"image_id,omitempty" doc:"Archive key of the image (its SHA-256) when archiving is on. The image is stored in the background, so it can take a moment to become readable."`
+	ImageURL        string         `json:"image_url,omitempty" doc:"Signed URL of the archived image, valid for archive.signed_url_ttl (an hour by default); only with an object-store archive."`
+	UserID          string         `json:"user_id,omitempty" doc:"Verified user ID when called with an ID token."`
+	Sandbox         bool           `json:"sandbox,omitempty" doc:"Set when the submission was recorded in the sandbox namespace."`
+	Experiment      string         `json:"experiment,omitempty" doc:"Running experiment the caller was assigned a variant of, if any; requests naming their metric or aggregation are not assigned."`
+	Variant         string         `json:"variant,omitempty" doc:"Variant of experiment whose scoring method produced the score."`
+
+	// hash is the image's imaging.DHash, by which rooms spot
+	// near-duplicates.
+	hash uint64
+}
+
+type ColorCluster struct {
+	Hex      string  `json:"hex" doc:"Mean color of the cluster as #rrggbb."`
+	Distance float64 `json:"distance" doc:"Distance to the theme in the scoring metric's units, rounded to three decimals."`
+	Share    float64 `json:"share" doc:"Share of the image's pixels in the cluster, 0 to 1."`
+}
+
+type MethodScore struct {
+	Method string  `json:"method"`
+	Score  float64 `json:"score" doc:"Rounded as ScoreResponse.score."`
+}
+
+type HSL struct {
+	H float64 `json:"h" doc:"Hue in degrees, [0, 360); 0 for grays."`
+	S float64 `json:"s" doc:"Saturation in percent."`
+	L float64 `json:"l" doc:"Lightness in percent."`
+}
+
+// Lab is CIELAB with a D65 white point, the space the lab76, cie94 and
+// ciede2000 metrics measure in.
+type Lab struct {
+	L float64 `json:"l" doc:"Lightness, 0 to 100."`
+	A float64 `json:"a"`
+	B float64 `json:"b"`
+}
+
+type OKLCH struct {
+	L float64 `json:"l" doc:"Lightness, 0 to 1."`
+	C float64 `json:"c" doc:"Chroma."`
+	H float64 `json:"h" doc:"Hue in degrees, [0, 360); 0 for grays."`
+}
+
+// avgColorModels converts an average color in linear sRGB for
+// ScoreResponse, rounded to what a color picker displays.
+func avgColorModels(lr, lg, lb float64) (HSL, Lab, OKLCH) {
+	// Adding 0 turns -0 into 0.
+	round := func(v, scale float64) float64 { return math.Round(v*scale)/scale + 0 }
+	h, s, l := colormath.SRGBToHSL(colormath.LinearToSRGB(lr), colormath.LinearToSRGB(lg), colormath.LinearToSRGB(lb))
+	lab := colormath.LinearToLab(lr, lg, lb)
+	lch := colormath.OklabToOklch(colormath.LinearToOklab(lr, lg, lb))
+	lc := OKLCH{L: round(lch[0], 1e4), C: round(lch[1], 1e4), H: round(lch[2], 10)}
+	if lc.C == 0 {
+		lc.H = 0 // hue is noise without chroma
+	}
+	return HSL{H: round(h, 10), S: round(100*s, 10), L: round(100*l, 10)},
+		Lab{L: round(lab[0], 100), A: round(lab[1], 100), B: round(lab[2], 100)},
+		lc
+}
+
+type DebugReq struct {
+	ImageBase64 string `json:"image_base64"`
+	SwatchGrid  int    `json:"swatch_grid,omitempty" doc:"Swatches per row and column, at most 32; defaults to 8."`
+	Seed        uint32 `json:"seed,omitempty" doc:"Sampling grid to show, as in ScoreRequest."`
+}
+
+// Bounds of DebugReq.SwatchGrid.
+const (
+	defaultSwatchGrid = 8
+	maxSwatchGrid     = 32
+)
+
+type DebugResp struct {
+	DecodedLen int               `json:"decoded_len"`
+	First8Hex  string            `json:"first8_hex"`
+	MimeGuess  string            `json:"mime_guess"`
+	DecodeOK   bool              `json:"decode_ok"`
+	DecodeErr  string            `json:"decode_err"`
+	Format     string            `json:"format" doc:"Decoder used: png, jpeg or gif; empty if none matched."`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	JPEG       *imaging.JPEGInfo `json:"jpeg,omitempty" doc:"Frame details and decode workarounds, for JPEGs."`
+	PNG        *imaging.PNGInfo  `json:"png,omitempty" doc:"Bit depth, color chunks and gamma correction, for PNGs."`
+	// What scoring sees, for settling disputed scores; set when the image
+	// decoded.
+	SampleCols       int        `json:"sample_cols,omitempty" doc:"Width of the grid of pixels scoring samples under the configured budget."`
+	SampleRows       int        `json:"sample_rows,omitempty"`
+	Swatches         [][]string `json:"swatches,omitempty" doc:"The samples averaged down to at most swatch_grid×swatch_grid cells, row by row, as #rrggbb; empty for fully transparent cells."`
+	AvgColorHex      string     `json:"avg_color_hex,omitempty" doc:"Alpha-weighted average of the samples, as /score reports it."`
+	DominantColorHex string     `json:"dominant_color_hex,omitempty" doc:"Largest color of the image's palette, as /palette extracts it."`
+	Note             string     `json:"note"`
+}
+
+// scoreHandler serves /score under the defaults of the named scoring
+// version.
+func scoreHandler(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) { handleScore(w, r, version) }
+}
+
+// rawImageTypes are the Content-Types under which /score takes the image
+// bytes as the whole body, saving the base64 overhead; the other
+// ScoreRequest fields then come from rawScoreQuery.
+var rawImageTypes = []string{"image/png", "image/jpeg", "image/gif"}
+
+var rawScoreQuery = map[string]string{
+	"theme_hex":           "Raw image bodies only: as in ScoreRequest.",
+	"metric":              "Raw image bodies only: as in ScoreRequest.",
+	"aggregation":         "Raw image bodies only: as in ScoreRequest.",
+	"normalization":       "Raw image bodies only: as in ScoreRequest.",
+	"background":          "Raw image bodies only: as in ScoreRequest.",
+	"grayscale":           "Raw image bodies only: as in ScoreRequest.",
+	"vision":              "Raw image bodies only: as in ScoreRequest.",
+	"lang":                "Raw image bodies only: as in ScoreRequest.",
+	"include_all_methods": "Raw image bodies only: as in ScoreRequest.",
+	"captured_at_ms":      "Raw image bodies only: as in ScoreRequest.",
+	"seed":                "Raw image bodies only: as in ScoreRequest.",
+}
+
+var scoreHeaders = map[string]string{
+	"X-Theme-Hex":     "Raw image bodies only: theme_hex, when the query has none.",
+	"Accept-Language": "Language of the feedback when lang is unset, and of error messages: ja or en.",
+	"X-Session-ID":    "Client session ID, up to 255 bytes, assigning callers without an ID token to experiment variants; the user ID is used instead when there is one.",
+	"Idempotency-Key": "Retries with the same key within the idempotency TTL (a day by default) get the first successful response, with Idempotent-Replayed: true, instead of a second submission. Reusing a key for a different submission is IDEMPOTENCY_KEY_REUSED.",
+}
+
+// readScoreRequest reads a JSON ScoreRequest, or a raw image body with the
+// fields in the query, and returns it with the image bytes, which are
+// written to buf. It fails only if the body cannot be read; a field it
+// cannot parse is added to errs and left unset, for validation to report
+// with the rest.
+func readScoreRequest(r *http.Request, buf *bytes.Buffer, errs *fieldErrors) (ScoreRequest, []byte, error) {
+	var req ScoreRequest
+	if !isRawImage(r) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, nil, bodyError(err)
+		}
+		if err := imaging.DecodeBase64Into(buf, req.ImageBase64); err != nil {
+			errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidBase64, Field: "image_base64", Msg: "bad image: " + err.Error()})
+			return req, nil, nil
+		}
+		return req, buf.Bytes(), nil
+	}
+
+	if err := readRawImage(r, buf); err != nil {
+		return req, nil, err
+	}
+	q := r.URL.Query()
+	req = ScoreRequest{
+		ThemeHex:      cmp.Or(q.Get("theme_hex"), r.Header.Get("X-Theme-Hex")),
+		Metric:        q.Get("metric"),
+		Aggregation:   q.Get("aggregation"),
+		Normalization: q.Get("normalization"),
+		Background:    q.Get("background"),
+		Grayscale:     q.Get("grayscale"),
+		Vision:        q.Get("vision"),
+		Lang:          q.Get("lang"),
+	}
+	if v := q.Get("captured_at_ms"); v != "" {
+		var err error
+		if req.CapturedAtMs, err = strconv.ParseInt(v, 10, 64); err != nil {
+			errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "captured_at_ms", Msg: "bad captured_at_ms: want Unix milliseconds"})
+		}
+	}
+	if v := q.Get("include_all_methods"); v != "" {
+		var err error
+		if req.IncludeAllMethods, err = strconv.ParseBool(v); err != nil {
+			errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "include_all_methods", Msg: "bad include_all_methods: want true or false"})
+		}
+	}
+	if v := q.Get("seed"); v != "" {
+		seed, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "seed", Msg: "bad seed: want 0 to 4294967295"})
+		}
+		req.Seed = uint32(seed)
+	}
+	return req, buf.Bytes(), nil
+}
+
+// isRawImage reports whether r's body is image bytes rather than JSON.
+func isRawImage(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return slices.Contains(rawImageTypes, mt)
+}
+
+// readRawImage reads a raw image body into buf.
+func readRawImage(r *http.Request, buf *bytes.Buffer) error {
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return bodyError(err)
+		}
+		return &requestError{Status: http.StatusBadRequest, Code: codeCorruptImage, Msg: "reading body: " + err.Error()}
+	}
+	return nil
+}
+
+// readMaskBase64 decodes a mask_base64 field into buf; an empty field
+// means no mask.
+func readMaskBase64(buf *bytes.Buffer, s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if err := imaging.DecodeBase64Into(buf, s); err != nil {
+		return nil, &requestError{Status: http.StatusBadRequest, Code: codeInvalidBase64, Field: "mask_base64", Msg: "bad mask: " + err.Error()}
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeMask decodes a score mask and checks it has img's size.
+func decodeMask(ctx context.Context, data []byte, img image.Image) (image.Image, error) {
+	mask, _, err := imaging.DecodeWithinContext(ctx, data, config().imageLimits())
+	if err != nil {
+		return nil, scoreContextError(ctx, withField(imageDecodeError(err, data), "mask_base64"))
+	}
+	if ms, is := mask.Bounds().Size(), img.Bounds().Size(); ms != is {
+		return nil, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "mask_base64",
+			Msg: fmt.Sprintf("mask is %dx%d but the image is %dx%d", ms.X, ms.Y, is.X, is.Y)}
+	}
+	return mask, nil
+}
+
+func handleScore(w http.ResponseWriter, r *http.Request, version string) {
+	received := receivedAt(r.Context())
+	r.Body = http.MaxBytesReader(w, r.Body, config().Limits.MaxBodyBytes)
+
+	// Nothing keeps the image bytes past scoreSubmission, so their buffer
+	// is reused.
+	buf := imaging.GetBuffer()
+	defer imaging.PutBuffer(buf)
+	var errs fieldErrors
+	req, imgBytes, err := readScoreRequest(r, buf, &errs)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	maskBuf := imaging.GetBuffer()
+	defer imaging.PutBuffer(maskBuf)
+	maskBytes, err := readMaskBase64(maskBuf, req.MaskBase64)
+	errs.add(err)
+
+	timings := &scoreTimings{Read: time.Since(received)}
+	latencies.observe(stageRead, timings.Read)
+	p := scoreRequestParams(r, req, imgBytes, maskBytes, version)
+	p.Timings = timings
+	if len(errs) > 0 {
+		errs.add(p.validate())
+		writeRequestError(w, r, errs.err())
+		return
+	}
+	if negotiateMedia(r.Header.Get("Accept")) == mediaEventStream {
+		streamScore(w, r, p)
+		return
+	}
+	resp, out, err := scoreSubmission(r.Context(), p)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	if out.Shared {
+		w.Header().Set("X-Coalesced", "1")
+	}
+	if out.Replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	w.Header().Set("Server-Timing", timings.header())
+	writeScoreResponse(w, r, resp)
+	observeDone(received, p.CapturedAt)
+}
+
+// scoreRequestParams turns req, from r, into the scoreParams for version.
+func scoreRequestParams(r *http.Request, req ScoreRequest, img, mask []byte, version string) scoreParams {
+	principal := principalFrom(r.Context())
+	return scoreParams{
+		Image:         img,
+		Mask:          mask,
+		ThemeHex:      req.ThemeHex,
+		Metric:        req.Metric,
+		Aggregation:   req.Aggregation,
+		Normalization: req.Normalization,
+		Background:    req.Background,
+		Grayscale:     req.Grayscale,
+		Vision:        req.Vision,
+		Lang:          cmp.Or(req.Lang, requestLang(r)),
+		AllMethods:    req.IncludeAllMethods,
+		Seed:          req.Seed,
+		UserID:        principal.UserID,
+		Sandbox:       principal.Sandbox,
+		Version:       version,
+		ReceivedAt:    receivedAt(r.Context()),
+		CapturedAt:    capturedAt(req.CapturedAtMs),
+
+		IdempotencyKey:    r.Header.Get("Idempotency-Key"),
+		Quota:             quotaClientFor(r),
+		ExperimentSubject: cmp.Or(principal.UserID, r.Header.Get("X-Session-ID")),
+	}
+}
+
+func handleDebug(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config().Limits.MaxBodyBytes)
+	var req DebugReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, r, bodyError(err))
+		return
+	}
+	if req.SwatchGrid < 0 || req.SwatchGrid > maxSwatchGrid {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "swatch_grid",
+			Msg: fmt.Sprintf("swatch_grid must be between 1 and %d, or 0 for the default", maxSwatchGrid)})
+		return
+	}
+	s := strings.TrimSpace(req.ImageBase64)
+	if i := strings.Index(s, ","); i != -1 && strings.HasPrefix(strings.ToLower(s), "data:") {
+		s = s[i+1:]
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidBase64, Field: "image_base64", Msg: "bad base64: " + err.Error()})
+		return
+	}
+
+	first := b
+	if len(first) > 8 {
+		first = first[:8]
+	}
+	mime := http.DetectContentType(b)
+
+	var decOK bool
+	var width, height int
+	var decErr string
+	// JPEGs and PNGs are decoded through DecodeJPEG and DecodePNG directly
+	// to report what they had to work around.
+	var img image.Image
+	var format string
+	var jpegInfo *imaging.JPEGInfo
+	var pngInfo *imaging.PNGInfo
+	if info, ierr := imaging.InspectJPEG(b); ierr == nil {
+		format, jpegInfo = "jpeg", &info
+	} else if info, ierr := imaging.InspectPNG(b); ierr == nil {
+		format, pngInfo = "png", &info
+	}
+	if err = config().imageLimits().Check(b); err == nil {
+		switch {
+		case jpegInfo != nil:
+			img, *jpegInfo, err = imaging.DecodeJPEG(b)
+		case pngInfo != nil:
+			img, *pngInfo, err = imaging.DecodePNG(b)
+		default:
+			img, format, err = imaging.Decode(b)
+		}
+	}
+	if err == nil {
+		decOK = true
+		bounds := img.Bounds()
+		width, height = bounds.Dx(), bounds.Dy()
+	} else {
+		decErr = err.Error()
+		var dimErr *imaging.DimensionError
+		if errors.As(err, &dimErr) {
+			width, height = dimErr.Width, dimErr.Height
+		}
+	}
+
+	resp := DebugResp{
+		DecodedLen: len(b),
+		First8Hex:  hex.EncodeToString(first),
+		MimeGuess:  mime,
+		DecodeOK:   decOK,
+		DecodeErr:  decErr,
+		Format:     format,
+		Width:      width,
+		Height:     height,
+		JPEG:       jpegInfo,
+		PNG:        pngInfo,
+		Note:       "ブラウザの canvas.toDataURL('image/png') で作ったデータなら decode_ok=true になるはず",
+	}
+	if decOK {
+		maxSamples := config().Scoring.MaxSamples
+		samples := imaging.SampleLinearRGB(img, maxSamples, uint64(req.Seed))
+		resp.SampleCols, resp.SampleRows = imaging.GridSize(img.Bounds(), maxSamples, uint64(req.Seed))
+		resp.Swatches = swatches(samples, resp.SampleCols, resp.SampleRows, cmp.Or(req.SwatchGrid, defaultSwatchGrid))
+		avg := imaging.AverageLinearRGB(samples)
+		resp.AvgColorHex = colormath.LinearHex(avg[0], avg[1], avg[2])
+		if p := imaging.ExtractPalette(img, 0, maxSamples); len(p) > 0 {
+			resp.DominantColorHex = p[0].Hex
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// swatches averages a cols×rows sampling grid down to at most n×n cells,
+// weighting samples by alpha as scoring does.
+func swatches(samples []imaging.Sample, cols, rows, n int) [][]string {
+	w, h := min(n, cols), min(n, rows)
+	sums := make([]imaging.Sample, w*h)
+	for i, s := range samples {
+		c := &sums[(i/cols)*h/rows*w+(i%cols)*w/cols]
+		c.R += s.R * s.W
+		c.G += s.G * s.W
+		c.B += s.B * s.W
+		c.W += s.W
+	}
+	out := make([][]string, h)
+	for y := range out {
+		out[y] = make([]string, w)
+		for x := range out[y] {
+			if c := sums[y*w+x]; c.W > 0 {
+				out[y][x] = colormath.LinearHex(c.R/c.W, c.G/c.W, c.B/c.W)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+)
+
+const scoreUsage = `usage: iropico score -theme HEX [flags] <file|dir>...
+
+Scores local images with the same scorer, defaults and rounding as the
+server. Directories are walked for .png, .jpg, .jpeg and .gif files.
+Scoring defaults come from CONFIG_FILE and the environment, as for serve.
+
+flags:
+`
+
+// ScoreFileResult is one line of `iropico score -json` output.
+type ScoreFileResult struct {
+	File string `json:"file"`
+	ScoreResponse
+}
+
+func runScore(args []string) int {
+	flags := flag.NewFlagSet("score", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), scoreUsage)
+		flags.PrintDefaults()
+	}
+	theme := flags.String("theme", "", "theme color: hex, rgb(), hsl() or a CSS name (required)")
+	version := flags.String("version", "", "scoring version whose defaults apply (v1 or v2; default v1)")
+	metricName := flags.String("metric", "", "color metric (default: the version's or config's)")
+	aggName := flags.String("aggregation", "", "aggregation (default: the version's or config's)")
+	normalization := flags.String("normalization", "", "gamut or global (default: config's)")
+	background := flags.String("background", "", "alpha, white, theme or ignore (default: config's)")
+	grayscale := flags.String("grayscale", "", "off, auto or on: score gray themes on lightness (default: config's)")
+	vision := flags.String("vision", "", "normal, protanopia, deuteranopia or tritanopia: whose color vision to score as (default: normal)")
+	lang := flags.String("lang", "", "feedback language: ja or en (default: ja)")
+	seed := flags.Uint("seed", 0, "sampling grid placement, as the API's seed (default: the top-left grid)")
+	asJSON := flags.Bool("json", false, "print one JSON object per image")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *theme == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "score:", err)
+		return 2
+	}
+	currentConfig.Store(cfg)
+	tr, tg, tb, _, err := colormath.ParseColor(*theme)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "score: bad theme %q: %v\n", *theme, err)
+		return 2
+	}
+	sc, opts, err := resolveScorer(scoreParams{
+		Version:       *version,
+		Metric:        *metricName,
+		Aggregation:   *aggName,
+		Normalization: *normalization,
+		Background:    *background,
+		Grayscale:     *grayscale,
+		Vision:        *vision,
+		Seed:          uint32(*seed),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "score:", err)
+		return 2
+	}
+
+	if *lang, err = feedbackLang(*lang); err != nil {
+		fmt.Fprintln(os.Stderr, "score:", err)
+		return 2
+	}
+
+	files, err := scoreFiles(flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "score:", err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	status := 0
+	for _, path := range files {
+		img, err := decodeImageFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "score: %s: %v\n", path, err)
+			status = 1
+			continue
+		}
+		resp, res, err := scoreImage(context.Background(), sc, img, nil, tr, tg, tb, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "score: %s: %v\n", path, err)
+			status = 1
+			continue
+		}
+		resp.Feedback = scoreFeedback(res, tr, tg, tb, opts.Vision, *lang)
+		if *asJSON {
+			enc.Encode(ScoreFileResult{File: path, ScoreResponse: resp})
+		} else {
+			fmt.Printf("%s\t%.1f\t%s\t%s\n", path, resp.Score, resp.AvgColorHex, resp.Method)
+		}
+	}
+	return status
+}
+
+// scoreFiles expands directories in args to the images under them, in
+// lexical order so runs over the same folder can be diffed.
+func scoreFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		st, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !st.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".png", ".jpg", ".jpeg", ".gif":
+				if !d.IsDir() {
+					files = append(files, path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func decodeImageFile(path string) (image.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := imaging.Decode(data)
+	return img, err
+}
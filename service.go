@@ -0,0 +1,440 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+	"github.com/hiromuota166/iropico_color_calc/pkg/scoring"
+)
+
+// scoreParams is a transport-independent score request; the HTTP and gRPC
+// front ends both funnel into scoreSubmission.
+type scoreParams struct {
+	// Image and the optional Mask, an encoded image of its size selecting
+	// the pixels to score, are only read during the call; callers may
+	// reuse their buffers.
+	Image         []byte
+	Mask          []byte
+	ThemeHex      string
+	Metric        string
+	Aggregation   string
+	Normalization string
+	Background    string
+	Grayscale     string
+	Vision        string
+	// Options, when set, are used as they are instead of the options the
+	// fields above and the config resolve to, as rooms pin theirs when
+	// created; the fields above must still name the same method.
+	Options *scoring.Options
+	// Lang selects the feedback language; "" is the default.
+	Lang string
+	// AllMethods adds the image's score under every registered scorer.
+	AllMethods bool
+	// Seed places the sampling grid; see scoring.Options.
+	Seed    uint32
+	UserID  string
+	Sandbox bool
+	// ExperimentSubject, the user ID or the client's session ID, assigns
+	// the request to an experiment variant; empty opts out, as rooms do so
+	// their players are scored alike.
+	ExperimentSubject string
+	// Version names the scoringVersion supplying defaults; "" is v1.
+	Version string
+	// Rescore scores without archiving, recording or flagging, for images
+	// that were already submitted.
+	Rescore bool
+	// IdempotencyKey, when set, makes a retry with the same key return the
+	// first successful response instead of being scored and recorded again.
+	IdempotencyKey string
+	// Quota is who the submission counts against; the zero value is
+	// exempt.
+	Quota quotaClient
+
+	// ReceivedAt and the optional client CapturedAt feed the latency
+	// report; Timings, when set, receives this call's stage durations.
+	ReceivedAt, CapturedAt time.Time
+	Timings                *scoreTimings
+	// Progress, when set, is called from the calling goroutine as the image
+	// is decoded and scored. Cached and coalesced submissions skip
+	// straight to the result.
+	Progress func(ScoreProgress)
+}
+
+// progress reports a stage to p.Progress, if set.
+func (p scoreParams) progress(stage string, percent int, partial *MethodScore) {
+	if p.Progress != nil {
+		p.Progress(ScoreProgress{Stage: stage, Percent: percent, Partial: partial})
+	}
+}
+
+// scoreOutcome tells a transport how scoreSubmission produced its
+// response, for the headers it reports.
+type scoreOutcome struct {
+	// Shared is set when a concurrent identical call did the work.
+	Shared bool
+	// Replayed is set when the response was stored under the request's
+	// idempotency key.
+	Replayed bool
+}
+
+// maxIdempotencyKeyLen bounds Idempotency-Key values; clients normally send
+// a UUID.
+const maxIdempotencyKeyLen = 255
+
+// idempotent holds responses by caller and idempotency key. Like the other
+// caches it is per process, so a retry that reaches another replica is
+// scored again.
+var idempotent lruCache[string, idempotentResponse]
+
+type idempotentResponse struct {
+	// request fingerprints the submission, to reject a key reused for a
+	// different one.
+	request string
+	resp    ScoreResponse
+}
+
+// scoreFlight coalesces concurrent identical submissions.
+var scoreFlight flightGroup[ScoreResponse]
+
+// scoreResults caches scores so a retried upload skips the decode and
+// scan. Who submitted it does not matter: the key is the image, theme,
+// method and options only.
+var scoreResults lruCache[scoreResultKey, scoredImage]
+
+type scoreResultKey struct {
+	ImageID, MaskID, Theme, Method string
+	Options                        scoring.Options
+	AllMethods                     bool
+}
+
+type scoredImage struct {
+	resp ScoreResponse // without UserID, Sandbox, Feedback, the archived image and the experiment
+	res  scoring.Result
+}
+
+// scoreSubmission validates p, then decodes and scores the image and records
+// it in the theme's retrospective. Concurrent calls with identical image,
+// theme, method, options and user share one computation. Decoding and
+// scoring stop when ctx is done or limits.score_timeout passes. Client
+// mistakes are returned as *requestError.
+func scoreSubmission(ctx context.Context, p scoreParams) (resp ScoreResponse, out scoreOutcome, err error) {
+	if p.ThemeHex == "" {
+		p.ThemeHex = activeTheme.get().ThemeHex
+	}
+	if err := p.validate(); err != nil {
+		return ScoreResponse{}, out, err
+	}
+	var experiment string
+	variant, assigned := experimentVariant(p)
+	if assigned {
+		experiment = config().Experiment.Name
+		p.Metric, p.Aggregation = variant.Metric, variant.Aggregation
+	}
+	tr, tg, tb, _, err := colormath.ParseColor(p.ThemeHex)
+	if err != nil {
+		return ScoreResponse{}, out, themeHexError(err)
+	}
+	sc, opts, err := resolveScorer(p)
+	if err != nil {
+		return ScoreResponse{}, out, err
+	}
+	lang, err := feedbackLang(p.Lang)
+	if err != nil {
+		return ScoreResponse{}, out, err
+	}
+
+	if !p.Rescore && !p.CapturedAt.IsZero() {
+		latencies.observeSpan(stageNetwork, p.CapturedAt, p.ReceivedAt)
+	}
+
+	id := imageID(p.Image)
+	var maskID string
+	if len(p.Mask) > 0 {
+		maskID = imageID(p.Mask)
+	}
+	key := fmt.Sprintf("%s|%s|%s|%s|%+v|%t|%s|%s|%t|%t|%s/%s", id, maskID, themeKey(tr, tg, tb), sc.Name, opts, p.AllMethods, lang, p.UserID, p.Sandbox, p.Rescore, experiment, variant.Name)
+	var idemKey string
+	if p.IdempotencyKey != "" && !p.Rescore {
+		idemKey = fmt.Sprintf("%s|%t|%s", p.UserID, p.Sandbox, p.IdempotencyKey)
+		if prev, ok := idempotent.get(idemKey, time.Duration(config().Cache.IdempotencyTTL)); ok {
+			if prev.request != key {
+				return ScoreResponse{}, out, &requestError{Status: http.StatusUnprocessableEntity, Code: codeIdempotencyKeyReused, Field: "Idempotency-Key",
+					Msg: "Idempotency-Key was already used for a different submission"}
+			}
+			out.Replayed = true
+			return prev.resp, out, nil
+		}
+	}
+	var refund func()
+	if !p.Rescore {
+		if refund, err = chargeQuota(p.Quota, time.Now()); err != nil {
+			return ScoreResponse{}, out, err
+		}
+	}
+	// scored is set when this caller's compute scored the image itself,
+	// the only case that keeps the quota charge.
+	var scored bool
+	compute := func() (ScoreResponse, error) {
+		// The archive key is the image's hash, so the response can carry
+		// it while the image is stored in the background.
+		var archivedID, archivedURL string
+		if a := archiveFor(p.Sandbox); a != nil && !p.Rescore {
+			a.addAsync(p.Image)
+			archivedID, archivedURL = id, a.url(id)
+		}
+		cacheCfg := config().Cache
+		rkey := scoreResultKey{id, maskID, themeKey(tr, tg, tb), sc.Name, opts, p.AllMethods}
+		hit, cached := scoreResults.get(rkey, time.Duration(cacheCfg.TTL))
+		if !cached {
+			ctx, cancel := scoreContext(ctx)
+			defer cancel()
+			t0 := time.Now()
+			img, _, err := imaging.DecodeWithinContext(ctx, p.Image, config().imageLimits())
+			if err != nil {
+				return ScoreResponse{}, scoreContextError(ctx, imageDecodeError(err, p.Image))
+			}
+			var mask image.Image
+			if len(p.Mask) > 0 {
+				if mask, err = decodeMask(ctx, p.Mask, img); err != nil {
+					return ScoreResponse{}, err
+				}
+			}
+			t1 := time.Now()
+			p.progress(progressDecoded, 50, nil)
+			if hit.resp, hit.res, err = scoreImage(ctx, sc, img, mask, tr, tg, tb, opts); err != nil {
+				return ScoreResponse{}, err
+			}
+			hit.resp.hash = imaging.DHash(img)
+			if !p.AllMethods {
+				p.progress(progressScored, 95, &MethodScore{hit.resp.Method, hit.resp.Score})
+			} else {
+				p.progress(progressScored, 60, &MethodScore{hit.resp.Method, hit.resp.Score})
+				if hit.resp.AllMethods, err = allMethodScores(ctx, img, mask, tr, tg, tb, opts, func(done, total int, m MethodScore) {
+					p.progress(progressMethod, 60+35*done/total, &m)
+				}); err != nil {
+					return ScoreResponse{}, err
+				}
+			}
+			t2 := time.Now()
+			latencies.observe(stageDecode, t1.Sub(t0))
+			latencies.observe(stageScore, t2.Sub(t1))
+			if p.Timings != nil {
+				p.Timings.Decode, p.Timings.Score = t1.Sub(t0), t2.Sub(t1)
+			}
+			scoreResults.add(rkey, hit, cacheCfg.Entries)
+			scored = true
+		} else if p.Timings != nil {
+			p.Timings.Cached = true
+		}
+		resp, res := hit.resp, hit.res
+		resp.UserID, resp.Sandbox = p.UserID, p.Sandbox
+		resp.ImageID, resp.ImageURL = archivedID, archivedURL
+		resp.Experiment, resp.Variant = experiment, variant.Name
+		resp.Feedback = scoreFeedback(res, tr, tg, tb, opts.Vision, lang)
+		if p.Rescore {
+			return resp, nil
+		}
+		sr, sg, sb := colormath.LinearToSRGB(res.AvgR), colormath.LinearToSRGB(res.AvgG), colormath.LinearToSRGB(res.AvgB)
+		retrosFor(p.Sandbox).record(themeKey(tr, tg, tb), resp.UserID, archivedID, resp.Score, res.Coverage, sr, sg, sb)
+		if err := statsFor(p.Sandbox).record(themeKey(tr, tg, tb), resp.Method, experiment, variant.Name, resp.Score, time.Now()); err != nil {
+			log.Printf("stats: %v", err)
+		}
+		if reason := flagReason(resp.Score, res); reason != "" && !p.Sandbox {
+			flagged.push(FlaggedSubmission{
+				At:       time.Now().UTC(),
+				ThemeHex: "#" + themeKey(tr, tg, tb),
+				UserID:   resp.UserID,
+				Score:    resp.Score,
+				ImageID:  id,
+				Reason:   reason,
+			})
+		}
+		return resp, nil
+	}
+	// A shared computation stops with the caller running it; the others
+	// run it again rather than fail with that caller's cancellation.
+	for {
+		resp, err, out.Shared = scoreFlight.Do(key, compute)
+		if !out.Shared || !errors.Is(err, errScoreCanceled) || ctx.Err() != nil {
+			break
+		}
+	}
+	if refund != nil && !scored {
+		refund()
+	}
+	if err == nil && idemKey != "" {
+		idempotent.add(idemKey, idempotentResponse{key, resp}, config().Cache.IdempotencyEntries)
+	}
+	return resp, out, err
+}
+
+// resolveScorer applies the scoring version's method and the configured
+// option defaults to what p requests, reporting every field it rejects.
+func resolveScorer(p scoreParams) (scoring.Scorer, scoring.Options, error) {
+	cfg := config()
+	ver, ok := scoring.LookupVersion(p.Version)
+	if !ok {
+		return scoring.Scorer{}, scoring.Options{}, &requestError{Status: http.StatusNotFound, Code: codeUnknownVersion, Msg: "unknown api version " + p.Version}
+	}
+	var errs fieldErrors
+	metricName := cmp.Or(p.Metric, ver.Metric)
+	aggName := cmp.Or(p.Aggregation, ver.Aggregation)
+	if _, ok := scoring.LookupMetric(metricName); !ok {
+		errs.add(&requestError{Status: http.StatusBadRequest, Code: codeUnknownMetric, Field: "metric",
+			Msg: fmt.Sprintf("unknown metric %q", metricName), Details: map[string]any{"allowed": apiEnums["metrics"]()}})
+	}
+	if _, ok := scoring.LookupAggregation(aggName); !ok {
+		errs.add(&requestError{Status: http.StatusBadRequest, Code: codeUnknownAggregation, Field: "aggregation",
+			Msg: fmt.Sprintf("unknown aggregation %q", aggName), Details: map[string]any{"allowed": apiEnums["aggregations"]()}})
+	}
+	opts := cfg.scoreOptions()
+	opts.Seed = uint64(p.Seed)
+	opts.Normalization = cmp.Or(p.Normalization, ver.Normalization)
+	if p.Background != "" {
+		if !slices.Contains(scoring.Backgrounds, p.Background) {
+			errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "background",
+				Msg: fmt.Sprintf("unknown background %q", p.Background), Details: map[string]any{"allowed": scoring.Backgrounds}})
+		} else {
+			opts.Background = p.Background
+		}
+	}
+	if p.Grayscale != "" {
+		if !slices.Contains(scoring.GrayscaleModes, p.Grayscale) {
+			errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "grayscale",
+				Msg: fmt.Sprintf("unknown grayscale mode %q", p.Grayscale), Details: map[string]any{"allowed": scoring.GrayscaleModes}})
+		} else {
+			opts.Grayscale = p.Grayscale
+		}
+	}
+	if p.Vision != "" {
+		if !slices.Contains(scoring.VisionModes, p.Vision) {
+			errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "vision",
+				Msg: fmt.Sprintf("unknown vision mode %q", p.Vision), Details: map[string]any{"allowed": scoring.VisionModes}})
+		} else {
+			opts.Vision = p.Vision
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		errs.add(&requestError{Status: http.StatusBadRequest, Code: codeUnknownNormalization, Field: "normalization",
+			Msg: "bad normalization: " + err.Error(), Details: map[string]any{"allowed": []string{scoring.NormalizeGamut, scoring.NormalizeGlobal}}})
+	}
+	if err := errs.err(); err != nil {
+		return scoring.Scorer{}, scoring.Options{}, err
+	}
+	sc, err := scoring.LookupScorer(metricName, aggName)
+	if err != nil {
+		return scoring.Scorer{}, scoring.Options{}, err
+	}
+	if p.Options != nil {
+		opts = *p.Options
+	}
+	return sc, opts, nil
+}
+
+// scoreImage runs sc over the pixels mask selects, or all of img when mask
+// is nil, and shapes the result as the API reports it. A mask selecting
+// nothing is rejected, as are, under the ignore and theme backgrounds,
+// images with too few opaque pixels: there is nothing to judge, or a blank
+// canvas would match. ctx is a scoreContext.
+func scoreImage(ctx context.Context, sc scoring.Scorer, img, mask image.Image, tr, tg, tb uint8, opts scoring.Options) (ScoreResponse, scoring.Result, error) {
+	res, err := sc.ScoreContext(ctx, img, mask, tr, tg, tb, opts)
+	if err != nil {
+		return ScoreResponse{}, res, scoreContextError(ctx, err)
+	}
+	if mask != nil && res.Selected == 0 {
+		return ScoreResponse{}, res, &requestError{Status: http.StatusUnprocessableEntity, Code: codeEmptyMask, Field: "mask_base64", Msg: "mask selects no pixels"}
+	}
+	if minOpaque := config().Scoring.MinOpaqueFraction; (opts.Background == scoring.BackgroundIgnore || opts.Background == scoring.BackgroundTheme) && res.Opaque < minOpaque {
+		return ScoreResponse{}, res, &requestError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    codeMostlyTransparent,
+			Field:   "image_base64",
+			Msg:     "image is almost entirely transparent",
+			Details: map[string]any{"opaque_fraction": math.Round(res.Opaque*1000) / 1000, "min_opaque_fraction": minOpaque},
+		}
+	}
+	resp := ScoreResponse{
+		Score:       math.Round(res.Score*10) / 10,
+		AvgColorHex: res.AvgHex(),
+		Method:      res.Method,
+	}
+	resp.AvgColorHSL, resp.AvgColorLab, resp.AvgColorOKLCH = avgColorModels(res.AvgR, res.AvgG, res.AvgB)
+	resp.AvgColorNames = colorNames(res.AvgR, res.AvgG, res.AvgB)
+	resp.ClosestClusters = make([]ColorCluster, len(res.Closest))
+	for i, c := range res.Closest {
+		resp.ClosestClusters[i] = ColorCluster{
+			Hex:      colormath.LinearHex(c.R, c.G, c.B),
+			Distance: math.Round(c.Dist*1000) / 1000,
+			Share:    math.Round(c.Share*1000) / 1000,
+		}
+	}
+	return resp, res, nil
+}
+
+// allMethodScores scores img under every registered scorer with opts,
+// reusing the one decode, and passes each new score to the optional
+// scored. Under grayscale mode several scorers become the same Lightness
+// one, which is listed once.
+func allMethodScores(ctx context.Context, img, mask image.Image, tr, tg, tb uint8, opts scoring.Options, scored func(done, total int, m MethodScore)) ([]MethodScore, error) {
+	var out []MethodScore
+	for i, sc := range scoring.Scorers {
+		res, err := sc.ScoreContext(ctx, img, mask, tr, tg, tb, opts)
+		if err != nil {
+			return nil, scoreContextError(ctx, err)
+		}
+		if slices.ContainsFunc(out, func(m MethodScore) bool { return m.Method == res.Method }) {
+			continue
+		}
+		m := MethodScore{Method: res.Method, Score: math.Round(res.Score*10) / 10}
+		out = append(out, m)
+		if scored != nil {
+			scored(i+1, len(scoring.Scorers), m)
+		}
+	}
+	return out, nil
+}
+
+// errScoreTimeout is the cause of a scoreContext's deadline.
+var errScoreTimeout = errors.New("score timeout")
+
+// errScoreCanceled is returned when the caller went away mid-score; no
+// one reads it, but a coalesced caller still waiting retries on it.
+var errScoreCanceled = &requestError{Status: statusClientClosedRequest, Code: codeCanceled, Msg: "request canceled"}
+
+// statusClientClosedRequest is nginx's status for a client that
+// disconnected before the response; it only shows in logs.
+const statusClientClosedRequest = 499
+
+// scoreContext bounds decoding and scoring one submission by
+// limits.score_timeout, on top of ctx.
+func scoreContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	d := time.Duration(config().Limits.ScoreTimeout)
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, d, errScoreTimeout)
+}
+
+// scoreContextError is the API error for err, returned by work under the
+// scoreContext ctx: the timeout or cancellation if ctx is done, else err.
+func scoreContextError(ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+	if context.Cause(ctx) != errScoreTimeout {
+		return errScoreCanceled
+	}
+	d := time.Duration(config().Limits.ScoreTimeout)
+	return &requestError{Status: http.StatusServiceUnavailable, Code: codeScoreTimeout,
+		Msg: fmt.Sprintf("scoring took longer than %s; try a smaller image", d), Details: map[string]any{"timeout_seconds": d.Seconds()}}
+}
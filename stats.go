@@ -0,0 +1,335 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// Score statistics count every recorded score by theme, UTC day, method and
+// experiment variant, so the effect of a scoring change on the distribution
+// can be checked.
+// Scores are rounded to a tenth, so counting them in tenths keeps medians
+// and percentiles exact.
+
+var stats, sandboxStats *statsStore
+
+func statsFor(sandbox bool) *statsStore {
+	if sandbox {
+		return sandboxStats
+	}
+	return stats
+}
+
+const statsDay = "2006-01-02"
+
+type statsKey struct {
+	Day, Theme, Method string
+	// Experiment and Variant are empty for submissions not assigned one.
+	Experiment, Variant string
+}
+
+// fileKey keys k's counts within its day and theme file: the method, then
+// for experiment submissions "|experiment/variant", which neither a method
+// nor validExperimentName allows.
+func (k statsKey) fileKey() string {
+	if k.Experiment == "" {
+		return k.Method
+	}
+	return k.Method + "|" + k.Experiment + "/" + k.Variant
+}
+
+func parseStatsFileKey(day, theme, s string) statsKey {
+	k := statsKey{Day: day, Theme: theme}
+	var ev string
+	k.Method, ev, _ = strings.Cut(s, "|")
+	k.Experiment, k.Variant, _ = strings.Cut(ev, "/")
+	return k
+}
+
+// scoreCounts maps a score in tenths of a point to how often it was given.
+type scoreCounts map[int]int
+
+// statsStore keeps the counts in memory; with a directory set, each theme's
+// counts for a day are written to dir/<day>/<hex>.json, keyed by
+// statsKey.fileKey, on every change and reloaded at startup.
+type statsStore struct {
+	dir string
+
+	mu     sync.Mutex
+	counts map[statsKey]scoreCounts
+}
+
+func newStatsStore(dir string) (*statsStore, error) {
+	s := &statsStore{dir: dir, counts: map[statsKey]scoreCounts{}}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	days, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range days {
+		if _, err := time.Parse(statsDay, d.Name()); !d.IsDir() || err != nil {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(dir, d.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			theme, ok := strings.CutSuffix(f.Name(), ".json")
+			if !ok || strings.HasPrefix(f.Name(), ".") {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(dir, d.Name(), f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			var byKey map[string]scoreCounts
+			if err := json.Unmarshal(b, &byKey); err != nil {
+				return nil, fmt.Errorf("%s/%s: %w", d.Name(), f.Name(), err)
+			}
+			for k, c := range byKey {
+				s.counts[parseStatsFileKey(d.Name(), theme, k)] = c
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *statsStore) record(theme, method, experiment, variant string, score float64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := statsKey{at.UTC().Format(statsDay), theme, method, experiment, variant}
+	c := s.counts[k]
+	if c == nil {
+		c = scoreCounts{}
+		s.counts[k] = c
+	}
+	c[int(math.Round(score*10))]++
+	return s.write(k.Day, theme)
+}
+
+func (s *statsStore) write(day, theme string) error {
+	if s.dir == "" {
+		return nil
+	}
+	byKey := map[string]scoreCounts{}
+	for k, c := range s.counts {
+		if k.Day == day && k.Theme == theme {
+			byKey[k.fileKey()] = c
+		}
+	}
+	dir := filepath.Join(s.dir, day)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(byKey)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+theme+".json.tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, theme+".json"))
+}
+
+type statsFilter struct {
+	Theme, Method, Experiment string
+	From, To                  string // inclusive days; empty is unbounded
+	// By lists the dimensions groups are split on.
+	By []string
+}
+
+// query merges the counts matching f into one group per distinct value of
+// f.By, plus the total.
+func (s *statsStore) query(f statsFilter) StatsResp {
+	s.mu.Lock()
+	groups := map[statsKey]scoreCounts{}
+	total := scoreCounts{}
+	for k, c := range s.counts {
+		if (f.Theme != "" && k.Theme != f.Theme) || (f.Method != "" && k.Method != f.Method) ||
+			(f.Experiment != "" && k.Experiment != f.Experiment) ||
+			(f.From != "" && k.Day < f.From) || (f.To != "" && k.Day > f.To) {
+			continue
+		}
+		var g statsKey
+		if slices.Contains(f.By, "day") {
+			g.Day = k.Day
+		}
+		if slices.Contains(f.By, "theme") {
+			g.Theme = k.Theme
+		}
+		if slices.Contains(f.By, "method") {
+			g.Method = k.Method
+		}
+		if slices.Contains(f.By, "variant") {
+			g.Experiment, g.Variant = k.Experiment, k.Variant
+		}
+		if groups[g] == nil {
+			groups[g] = scoreCounts{}
+		}
+		for v, n := range c {
+			groups[g][v] += n
+			total[v] += n
+		}
+	}
+	s.mu.Unlock()
+
+	resp := StatsResp{Groups: []ScoreStats{}, Total: total.stats()}
+	for g, c := range groups {
+		st := c.stats()
+		st.Day, st.Method, st.Experiment, st.Variant = g.Day, g.Method, g.Experiment, g.Variant
+		if g.Theme != "" {
+			st.ThemeHex = "#" + g.Theme
+		}
+		resp.Groups = append(resp.Groups, st)
+	}
+	slices.SortFunc(resp.Groups, func(a, b ScoreStats) int {
+		return cmp.Or(cmp.Compare(a.Day, b.Day), cmp.Compare(a.ThemeHex, b.ThemeHex), cmp.Compare(a.Method, b.Method),
+			cmp.Compare(a.Experiment, b.Experiment), cmp.Compare(a.Variant, b.Variant))
+	})
+	return resp
+}
+
+type ScoreStats struct {
+	ThemeHex    string           `json:"theme_hex,omitempty" doc:"Set when grouping by theme."`
+	Day         string           `json:"day,omitempty" doc:"UTC date as YYYY-MM-DD; set when grouping by day."`
+	Method      string           `json:"method,omitempty" doc:"Scorer; set when grouping by method."`
+	Experiment  string           `json:"experiment,omitempty" doc:"Set when grouping by variant, for scores assigned an experiment variant."`
+	Variant     string           `json:"variant,omitempty" doc:"Set with experiment."`
+	Count       int              `json:"count"`
+	Mean        float64          `json:"mean"`
+	Median      float64          `json:"median"`
+	Percentiles ScorePercentiles `json:"percentiles"`
+	Histogram   [10]int          `json:"histogram" doc:"Counts of scores in [0, 10), [10, 20) … [90, 100]."`
+	AtLeast90   float64          `json:"share_at_least_90" doc:"Share of scores of 90 or more, 0 to 1."`
+}
+
+// ScorePercentiles interpolate linearly between the nearest scores.
+type ScorePercentiles struct {
+	P10 float64 `json:"p10"`
+	P25 float64 `json:"p25"`
+	P75 float64 `json:"p75"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+type StatsResp struct {
+	Groups []ScoreStats `json:"groups" doc:"Sorted by day, theme, method, then experiment and variant."`
+	Total  ScoreStats   `json:"total" doc:"Every matching score in one group."`
+}
+
+func (c scoreCounts) stats() ScoreStats {
+	var st ScoreStats
+	tenths := make([]int, 0, len(c))
+	var sum float64
+	for v, n := range c {
+		tenths = append(tenths, v)
+		st.Count += n
+		sum += float64(v*n) / 10
+		st.Histogram[min(v/100, 9)] += n
+		if v >= 900 {
+			st.AtLeast90 += float64(n)
+		}
+	}
+	if st.Count == 0 {
+		return st
+	}
+	slices.Sort(tenths)
+	// at returns the score at 0-based rank i of the sorted scores.
+	at := func(i int) float64 {
+		for _, v := range tenths {
+			if i -= c[v]; i < 0 {
+				return float64(v) / 10
+			}
+		}
+		return float64(tenths[len(tenths)-1]) / 10
+	}
+	pct := func(p float64) float64 {
+		r := p * float64(st.Count-1)
+		lo := int(r)
+		v := at(lo)
+		if f := r - float64(lo); f > 0 {
+			v += (at(lo+1) - v) * f
+		}
+		return math.Round(v*10) / 10
+	}
+	st.Mean = math.Round(sum/float64(st.Count)*10) / 10
+	st.Median = pct(0.5)
+	st.Percentiles = ScorePercentiles{P10: pct(0.1), P25: pct(0.25), P75: pct(0.75), P90: pct(0.9), P99: pct(0.99)}
+	st.AtLeast90 = math.Round(st.AtLeast90/float64(st.Count)*1000) / 1000
+	return st
+}
+
+// statsDimensions are the values group_by accepts.
+var statsDimensions = []string{"theme", "day", "method", "variant"}
+
+var statsQuery = map[string]string{
+	"theme":      "Only this theme, as hex (# optional).",
+	"method":     "Only this scorer, as in ScoreResponse.method.",
+	"experiment": "Only scores assigned a variant of this experiment; with group_by=variant, compares its variants.",
+	"from":       "First UTC day to include, YYYY-MM-DD.",
+	"to":         "Last UTC day to include, YYYY-MM-DD.",
+	"group_by":   "Comma-separated dimensions to split groups on: theme, day, method and/or variant; defaults to all four. Pass none for the total only.",
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	f, err := parseStatsFilter(r)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	resp := statsFor(principalFrom(r.Context()).Sandbox).query(f)
+	if len(f.By) == 0 {
+		resp.Groups = []ScoreStats{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func parseStatsFilter(r *http.Request) (statsFilter, error) {
+	q := r.URL.Query()
+	f := statsFilter{Method: q.Get("method"), Experiment: q.Get("experiment"), From: q.Get("from"), To: q.Get("to"), By: statsDimensions}
+	if v := q.Get("theme"); v != "" {
+		tr, tg, tb, err := colormath.ParseHex(v)
+		if err != nil {
+			return f, &requestError{Status: http.StatusBadRequest, Code: codeInvalidThemeHex, Field: "theme", Msg: "bad theme: " + err.Error()}
+		}
+		f.Theme = themeKey(tr, tg, tb)
+	}
+	for _, d := range []struct{ name, v string }{{"from", f.From}, {"to", f.To}} {
+		if _, err := time.Parse(statsDay, d.v); d.v != "" && err != nil {
+			return f, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: d.name, Msg: "bad " + d.name + ": want YYYY-MM-DD"}
+		}
+	}
+	if q.Has("group_by") {
+		f.By = nil
+		for _, d := range splitList(q.Get("group_by")) {
+			if d == "none" {
+				continue
+			}
+			if !slices.Contains(statsDimensions, d) {
+				return f, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "group_by",
+					Msg: fmt.Sprintf("unknown group_by dimension %q", d), Details: map[string]any{"allowed": statsDimensions}}
+			}
+			f.By = append(f.By, d)
+		}
+	}
+	return f, nil
+}
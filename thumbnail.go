@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/imaging"
+)
+
+const (
+	defaultThumbnailSize = 512
+	maxThumbnailSize     = 2048
+	defaultJPEGQuality   = 85
+)
+
+// thumbnailFormats are the encodings /thumbnail produces; the standard
+// library has no WebP encoder.
+var thumbnailFormats = []string{"jpeg", "png"}
+
+type ThumbnailRequest struct {
+	ImageBase64  string `json:"image_base64" doc:"PNG, JPEG or GIF as standard or URL-safe base64; a data: URL prefix is allowed."`
+	MaxDimension int    `json:"max_dimension,omitempty" doc:"Longest side in pixels, at most 2048; defaults to 512. Smaller images keep their size."`
+	Format       string `json:"format,omitempty" enum:"jpeg,png" doc:"Defaults to jpeg, which is flattened onto white; png keeps transparency."`
+	Quality      int    `json:"quality,omitempty" doc:"JPEG quality, 1 to 100; defaults to 85."`
+}
+
+var rawThumbnailQuery = map[string]string{
+	"max_dimension": "Raw image bodies only: as in ThumbnailRequest.",
+	"format":        "Raw image bodies only: as in ThumbnailRequest.",
+	"quality":       "Raw image bodies only: as in ThumbnailRequest.",
+}
+
+// handleThumbnail returns the image turned upright per its EXIF
+// orientation and scaled down, so clients need not resize on-device.
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config().Limits.MaxBodyBytes)
+	buf := imaging.GetBuffer()
+	defer imaging.PutBuffer(buf)
+	req, data, err := readThumbnailRequest(r, buf)
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	size := req.MaxDimension
+	if size == 0 {
+		size = defaultThumbnailSize
+	}
+	if size < 1 || size > maxThumbnailSize {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "max_dimension",
+			Msg: fmt.Sprintf("max_dimension must be between 1 and %d, or 0 for the default", maxThumbnailSize)})
+		return
+	}
+	format := req.Format
+	if format == "" {
+		format = thumbnailFormats[0]
+	}
+	if !slices.Contains(thumbnailFormats, format) {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "format",
+			Msg: fmt.Sprintf("unknown format %q", format), Details: map[string]any{"allowed": thumbnailFormats}})
+		return
+	}
+	quality := req.Quality
+	if quality == 0 {
+		quality = defaultJPEGQuality
+	}
+	if quality < 1 || quality > 100 {
+		writeRequestError(w, r, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "quality",
+			Msg: "quality must be between 1 and 100, or 0 for the default"})
+		return
+	}
+
+	img, _, err := imaging.DecodeWithin(data, config().imageLimits())
+	if err != nil {
+		writeRequestError(w, r, imageDecodeError(err, data))
+		return
+	}
+	thumb := imaging.Thumbnail(img, imaging.Orientation(data), size)
+
+	var out bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&out, thumb)
+	} else {
+		flat := image.NewRGBA(thumb.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), thumb, image.Point{}, draw.Over)
+		err = jpeg.Encode(&out, flat, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "encode thumbnail: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "image/"+format)
+	w.Header().Set("Content-Length", strconv.Itoa(out.Len()))
+	w.Write(out.Bytes())
+}
+
+// readThumbnailRequest reads a JSON ThumbnailRequest, or a raw image body
+// with the fields in the query, and returns it with the image bytes, which
+// are written to buf.
+func readThumbnailRequest(r *http.Request, buf *bytes.Buffer) (ThumbnailRequest, []byte, error) {
+	var req ThumbnailRequest
+	if !isRawImage(r) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, nil, bodyError(err)
+		}
+		if err := imaging.DecodeBase64Into(buf, req.ImageBase64); err != nil {
+			return req, nil, &requestError{Status: http.StatusBadRequest, Code: codeInvalidBase64, Field: "image_base64", Msg: "bad image: " + err.Error()}
+		}
+		return req, buf.Bytes(), nil
+	}
+	if err := readRawImage(r, buf); err != nil {
+		return req, nil, err
+	}
+	q := r.URL.Query()
+	req.Format = q.Get("format")
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"max_dimension", &req.MaxDimension}, {"quality", &req.Quality}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return req, nil, &requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: p.name, Msg: "bad " + p.name + ": want an integer"}
+			}
+			*p.dst = n
+		}
+	}
+	return req, buf.Bytes(), nil
+}
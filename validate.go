@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/hiromuota166/iropico_color_calc/pkg/colormath"
+)
+
+// Requests are validated in one pass that reports every problem it finds,
+// so a client can fix them all before retrying: the error names the first
+// problem, as before, and lists all of them in APIError.errors. Problems
+// that only decoding or scoring the image can reveal still come one at a
+// time, after validation has passed.
+
+// fieldErrors collects a request's problems, at most one per field.
+type fieldErrors []*requestError
+
+// add records err, or each problem of an error already listing several,
+// unless its field was already reported; nil is ignored.
+func (fe *fieldErrors) add(err error) {
+	if err == nil {
+		return
+	}
+	var re *requestError
+	if !errors.As(err, &re) {
+		re = &requestError{Status: http.StatusInternalServerError, Code: codeInternal, Msg: err.Error()}
+	}
+	if len(re.Fields) > 0 {
+		for _, f := range re.Fields {
+			fe.add(f)
+		}
+		return
+	}
+	if re.Field != "" && slices.ContainsFunc(*fe, func(e *requestError) bool { return e.Field == re.Field }) {
+		return
+	}
+	*fe = append(*fe, re)
+}
+
+// err returns nil if no problem was found, and otherwise the first one with
+// Fields listing them all.
+func (fe fieldErrors) err() error {
+	if len(fe) == 0 {
+		return nil
+	}
+	first := *fe[0]
+	first.Fields = fe
+	return &first
+}
+
+// themeHexError reports an unparsable theme_hex.
+func themeHexError(err error) *requestError {
+	return &requestError{Status: http.StatusBadRequest, Code: codeInvalidThemeHex, Field: "theme_hex", Msg: "bad theme_hex: " + err.Error()}
+}
+
+// validate checks everything about p that needs no full decode: that the
+// image is there, in a supported format and within the size limits, as is
+// the mask, and that the theme, method, options, lang and header values
+// are acceptable.
+func (p scoreParams) validate() error {
+	var errs fieldErrors
+	limits := config().imageLimits()
+	if len(p.Image) == 0 {
+		errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "image_base64", Msg: "image_base64 is required"})
+	} else if err := limits.Check(p.Image); err != nil {
+		errs.add(imageDecodeError(err, p.Image))
+	}
+	if len(p.Mask) > 0 {
+		if err := limits.Check(p.Mask); err != nil {
+			errs.add(withField(imageDecodeError(err, p.Mask), "mask_base64"))
+		}
+	}
+	if p.ThemeHex != "" {
+		if _, _, _, _, err := colormath.ParseColor(p.ThemeHex); err != nil {
+			errs.add(themeHexError(err))
+		}
+	}
+	_, _, err := resolveScorer(p)
+	errs.add(err)
+	_, err = feedbackLang(p.Lang)
+	errs.add(err)
+	if len(p.IdempotencyKey) > maxIdempotencyKeyLen {
+		errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "Idempotency-Key",
+			Msg: fmt.Sprintf("Idempotency-Key longer than %d bytes", maxIdempotencyKeyLen)})
+	}
+	if len(p.ExperimentSubject) > maxSessionIDLen {
+		errs.add(&requestError{Status: http.StatusBadRequest, Code: codeInvalidParameter, Field: "X-Session-ID",
+			Msg: fmt.Sprintf("X-Session-ID longer than %d bytes", maxSessionIDLen)})
+	}
+	return errs.err()
+}